@@ -0,0 +1,481 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/EmadHeravi/streamsow/config"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/sanitise"
+)
+
+const (
+	apiPrefix       = "/api/flows"
+	apiMaxBodyBytes = 1 << 20
+
+	apiErrBadRequest = "badrequest"
+	apiErrValidation = "validation"
+	apiErrNotFound   = "notfound"
+	apiErrConflict   = "conflict"
+	apiErrMethod     = "method"
+	apiErrInternal   = "internal"
+	apiErrConfigSave = "configsave"
+	apiErrAuth       = "unauthorized"
+)
+
+// apiError is returned as {"error": {...}} on every failed api call.
+type apiError struct {
+	Status  int    `json:"status"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Flow    string `json:"flow,omitempty"`
+	Input   string `json:"input,omitempty"`
+	Output  string `json:"output,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, typ string, format string, a ...interface{}) *apiError {
+	return &apiError{
+		Status:  status,
+		Type:    typ,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		logging.Log.Error().Err(err).Msg("api: unable to marshal to json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("Unable to marshal to json"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(bytes)
+}
+
+func writeAPIError(w http.ResponseWriter, e *apiError) {
+	writeJSON(w, e.Status, map[string]*apiError{"error": e})
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) *apiError {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return newAPIError(http.StatusBadRequest, apiErrBadRequest, "invalid request body: %s", err)
+	}
+	return nil
+}
+
+// copyConfig returns a copy of c that can be modified without touching c.
+func copyConfig(c *config.Config) *config.Config {
+	n := *c
+	n.Flows = make([]config.Flow, len(c.Flows))
+	for i, f := range c.Flows {
+		f.Inputs = append([]config.Input(nil), f.Inputs...)
+		f.Outputs = append([]config.Output(nil), f.Outputs...)
+		n.Flows[i] = f
+	}
+	return &n
+}
+
+func findFlow(c *config.Config, identifier string) int {
+	for i := range c.Flows {
+		if c.Flows[i].Identifier == identifier {
+			return i
+		}
+	}
+	return -1
+}
+
+func validateAPIConfig(c *config.Config) *apiError {
+	seen := make(map[string]bool)
+	for i := range c.Flows {
+		f := &c.Flows[i]
+		if seen[f.Identifier] {
+			e := newAPIError(http.StatusConflict, apiErrConflict, "duplicate flow identifier: %s", f.Identifier)
+			e.Flow = f.Identifier
+			return e
+		}
+		seen[f.Identifier] = true
+		if err := f.ValidateFlowConfig(); err != nil {
+			e := newAPIError(http.StatusUnprocessableEntity, apiErrValidation, "%s", err)
+			e.Flow = f.Identifier
+			return e
+		}
+		if err := config.ValidateFlowConfig(f); err != nil {
+			e := newAPIError(http.StatusUnprocessableEntity, apiErrValidation, "%s", err)
+			e.Flow = f.Identifier
+			return e
+		}
+	}
+	return nil
+}
+
+// restoreSecrets puts the secrets of the running config back into the urls
+// of c that hold the placeholder returned by GET, matched by flow and input
+// or output identifier. Caller must hold configLock.
+func restoreSecrets(c *config.Config) *apiError {
+	for i := range c.Flows {
+		f := &c.Flows[i]
+		var running *config.Flow
+		if j := findFlow(runningConfig, f.Identifier); j >= 0 {
+			running = &runningConfig.Flows[j]
+		}
+		for j := range f.Inputs {
+			in := &f.Inputs[j]
+			original := ""
+			if running != nil {
+				for _, r := range running.Inputs {
+					if r.Identifier == in.Identifier {
+						original = r.URL
+						break
+					}
+				}
+			}
+			u, err := sanitise.Restore(in.URL, original)
+			if err != nil {
+				e := newAPIError(http.StatusUnprocessableEntity, apiErrValidation, "input %s: %s", in.Identifier, err)
+				e.Flow, e.Input = f.Identifier, in.Identifier
+				return e
+			}
+			in.URL = u
+		}
+		for j := range f.Outputs {
+			out := &f.Outputs[j]
+			original := ""
+			if running != nil {
+				for _, r := range running.Outputs {
+					if r.Identifier == out.Identifier {
+						original = r.URL
+						break
+					}
+				}
+			}
+			u, err := sanitise.Restore(out.URL, original)
+			if err != nil {
+				e := newAPIError(http.StatusUnprocessableEntity, apiErrValidation, "output %s: %s", out.Identifier, err)
+				e.Flow, e.Output = f.Identifier, out.Identifier
+				return e
+			}
+			out.URL = u
+		}
+	}
+	return nil
+}
+
+// updateFlows applies mutate to a copy of the running config, validates the
+// result and applies it to the running flows. When apiwriteconfig is set the
+// new config is written back to the config file.
+func updateFlows(ctx context.Context, mutate func(c *config.Config) *apiError) *apiError {
+	configLock.Lock()
+	defer configLock.Unlock()
+	if runningConfig == nil {
+		return newAPIError(http.StatusServiceUnavailable, apiErrInternal, "config not loaded yet")
+	}
+
+	conf := copyConfig(runningConfig)
+	if e := mutate(conf); e != nil {
+		return e
+	}
+	if e := restoreSecrets(conf); e != nil {
+		return e
+	}
+	if e := validateAPIConfig(conf); e != nil {
+		return e
+	}
+
+	flowsLock.Lock()
+	err := applyFlows(ctx, conf.Flows)
+	flowsLock.Unlock()
+	if err != nil {
+		return newAPIError(http.StatusInternalServerError, apiErrInternal, "%s", err)
+	}
+	runningConfig = conf
+
+	if conf.APIWriteConfig && configFile != "" {
+		if err := config.SaveToFile(configFile, conf); err != nil {
+			logging.Log.Error().Err(err).Msgf("api: failed to write config file %s", configFile)
+			return newAPIError(http.StatusInternalServerError, apiErrConfigSave,
+				"change applied, but writing config file failed: %s", err)
+		}
+	}
+	return nil
+}
+
+// runningFlows returns a copy of the running flows with the secrets in the
+// urls redacted.
+func runningFlows() ([]config.Flow, *apiError) {
+	configLock.Lock()
+	defer configLock.Unlock()
+	if runningConfig == nil {
+		return nil, newAPIError(http.StatusServiceUnavailable, apiErrInternal, "config not loaded yet")
+	}
+	fcs := copyConfig(runningConfig).Flows
+	for i := range fcs {
+		fc := &fcs[i]
+		for j := range fc.Inputs {
+			fc.Inputs[j].URL = sanitise.String(fc.Inputs[j].URL)
+		}
+		for j := range fc.Outputs {
+			fc.Outputs[j].URL = sanitise.String(fc.Outputs[j].URL)
+		}
+	}
+	return fcs, nil
+}
+
+// apiAuth requires the bearer token on every request when one is set.
+func apiAuth(token string, h http.HandlerFunc) http.HandlerFunc {
+	if token == "" {
+		return h
+	}
+	expected := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, newAPIError(http.StatusUnauthorized, apiErrAuth, "missing or invalid api token"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func methodNotAllowed(r *http.Request) *apiError {
+	return newAPIError(http.StatusMethodNotAllowed, apiErrMethod, "method %s not allowed on %s", r.Method, r.URL.Path)
+}
+
+func flowNotFound(identifier string) *apiError {
+	e := newAPIError(http.StatusNotFound, apiErrNotFound, "flow %s not found", identifier)
+	e.Flow = identifier
+	return e
+}
+
+// apiHandler serves:
+//
+//	GET, POST               /api/flows
+//	GET, PUT, DELETE        /api/flows/{flow}
+//	POST                    /api/flows/{flow}/inputs
+//	DELETE                  /api/flows/{flow}/inputs/{input}
+//	POST                    /api/flows/{flow}/outputs
+//	DELETE                  /api/flows/{flow}/outputs/{output}
+func apiHandler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var parts []string
+		if p := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"); p != "" {
+			parts = strings.Split(p, "/")
+		}
+		var (
+			status = http.StatusOK
+			result interface{}
+			e      *apiError
+		)
+		switch len(parts) {
+		case 0:
+			status, result, e = apiFlows(ctx, w, r)
+		case 1:
+			status, result, e = apiFlow(ctx, w, r, parts[0])
+		case 2, 3:
+			if parts[1] != "inputs" && parts[1] != "outputs" {
+				e = newAPIError(http.StatusNotFound, apiErrNotFound, "%s not found", r.URL.Path)
+				break
+			}
+			id := ""
+			if len(parts) == 3 {
+				id = parts[2]
+			}
+			status, result, e = apiFlowMember(ctx, w, r, parts[0], parts[1] == "inputs", id)
+		default:
+			e = newAPIError(http.StatusNotFound, apiErrNotFound, "%s not found", r.URL.Path)
+		}
+		if e != nil {
+			writeAPIError(w, e)
+			return
+		}
+		if result == nil {
+			w.WriteHeader(status)
+			return
+		}
+		writeJSON(w, status, result)
+	}
+}
+
+func apiFlows(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, interface{}, *apiError) {
+	switch r.Method {
+	case http.MethodGet:
+		fcs, e := runningFlows()
+		return http.StatusOK, fcs, e
+	case http.MethodPost:
+		var fc config.Flow
+		if e := decodeBody(w, r, &fc); e != nil {
+			return 0, nil, e
+		}
+		e := updateFlows(ctx, func(c *config.Config) *apiError {
+			if findFlow(c, fc.Identifier) >= 0 {
+				e := newAPIError(http.StatusConflict, apiErrConflict, "flow %s already exists", fc.Identifier)
+				e.Flow = fc.Identifier
+				return e
+			}
+			c.Flows = append(c.Flows, fc)
+			return nil
+		})
+		return http.StatusCreated, fc, e
+	}
+	return 0, nil, methodNotAllowed(r)
+}
+
+func apiFlow(ctx context.Context, w http.ResponseWriter, r *http.Request, identifier string) (int, interface{}, *apiError) {
+	switch r.Method {
+	case http.MethodGet:
+		fcs, e := runningFlows()
+		if e != nil {
+			return 0, nil, e
+		}
+		for _, fc := range fcs {
+			if fc.Identifier == identifier {
+				return http.StatusOK, fc, nil
+			}
+		}
+		return 0, nil, flowNotFound(identifier)
+	case http.MethodPut:
+		var fc config.Flow
+		if e := decodeBody(w, r, &fc); e != nil {
+			return 0, nil, e
+		}
+		if fc.Identifier == "" {
+			fc.Identifier = identifier
+		}
+		if fc.Identifier != identifier {
+			e := newAPIError(http.StatusBadRequest, apiErrBadRequest, "flow identifier %s doesn't match %s", fc.Identifier, identifier)
+			e.Flow = identifier
+			return 0, nil, e
+		}
+		e := updateFlows(ctx, func(c *config.Config) *apiError {
+			i := findFlow(c, identifier)
+			if i < 0 {
+				return flowNotFound(identifier)
+			}
+			c.Flows[i] = fc
+			return nil
+		})
+		return http.StatusOK, fc, e
+	case http.MethodDelete:
+		e := updateFlows(ctx, func(c *config.Config) *apiError {
+			i := findFlow(c, identifier)
+			if i < 0 {
+				return flowNotFound(identifier)
+			}
+			c.Flows = append(c.Flows[:i], c.Flows[i+1:]...)
+			return nil
+		})
+		return http.StatusNoContent, nil, e
+	}
+	return 0, nil, methodNotAllowed(r)
+}
+
+// apiFlowMember adds (POST without id) or removes (DELETE with id) a single
+// input or output of a running flow.
+func apiFlowMember(ctx context.Context, w http.ResponseWriter, r *http.Request, flowIdentifier string, isInput bool, identifier string) (int, interface{}, *apiError) {
+	kind := "output"
+	if isInput {
+		kind = "input"
+	}
+	notFound := func() *apiError {
+		e := newAPIError(http.StatusNotFound, apiErrNotFound, "%s %s not found in flow %s", kind, identifier, flowIdentifier)
+		e.Flow = flowIdentifier
+		if isInput {
+			e.Input = identifier
+		} else {
+			e.Output = identifier
+		}
+		return e
+	}
+
+	switch {
+	case r.Method == http.MethodPost && identifier == "":
+		var (
+			in     config.Input
+			out    config.Output
+			result interface{}
+			id     string
+			e      *apiError
+		)
+		if isInput {
+			e = decodeBody(w, r, &in)
+			result, id = in, in.Identifier
+		} else {
+			e = decodeBody(w, r, &out)
+			result, id = out, out.Identifier
+		}
+		if e != nil {
+			return 0, nil, e
+		}
+		e = updateFlows(ctx, func(c *config.Config) *apiError {
+			i := findFlow(c, flowIdentifier)
+			if i < 0 {
+				return flowNotFound(flowIdentifier)
+			}
+			f := &c.Flows[i]
+			if isInput {
+				for _, existing := range f.Inputs {
+					if existing.Identifier == id {
+						e := newAPIError(http.StatusConflict, apiErrConflict, "input %s already exists in flow %s", id, flowIdentifier)
+						e.Flow, e.Input = flowIdentifier, id
+						return e
+					}
+				}
+				f.Inputs = append(f.Inputs, in)
+				return nil
+			}
+			for _, existing := range f.Outputs {
+				if existing.Identifier == id {
+					e := newAPIError(http.StatusConflict, apiErrConflict, "output %s already exists in flow %s", id, flowIdentifier)
+					e.Flow, e.Output = flowIdentifier, id
+					return e
+				}
+			}
+			f.Outputs = append(f.Outputs, out)
+			return nil
+		})
+		return http.StatusCreated, result, e
+	case r.Method == http.MethodDelete && identifier != "":
+		e := updateFlows(ctx, func(c *config.Config) *apiError {
+			i := findFlow(c, flowIdentifier)
+			if i < 0 {
+				return flowNotFound(flowIdentifier)
+			}
+			f := &c.Flows[i]
+			if isInput {
+				for j := range f.Inputs {
+					if f.Inputs[j].Identifier == identifier {
+						f.Inputs = append(f.Inputs[:j], f.Inputs[j+1:]...)
+						return nil
+					}
+				}
+				return notFound()
+			}
+			for j := range f.Outputs {
+				if f.Outputs[j].Identifier == identifier {
+					f.Outputs = append(f.Outputs[:j], f.Outputs[j+1:]...)
+					return nil
+				}
+			}
+			return notFound()
+		})
+		return http.StatusNoContent, nil, e
+	}
+	return 0, nil, methodNotAllowed(r)
+}
@@ -8,12 +8,15 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 	"reflect"
 	"time"
 
 	"github.com/EmadHeravi/streamsow/config"
 	"github.com/EmadHeravi/streamsow/flow"
 	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/output/srt"
 	"github.com/EmadHeravi/streamsow/stats"
 )
 
@@ -29,11 +32,7 @@ func createFlow(ctx context.Context, f *config.Flow) error {
 }
 
 func applyConfig(ctx context.Context, c *config.Config) error {
-	var (
-		influxctx context.Context
-		err       error
-	)
-
+	var influxctx context.Context
 	influxctx, influxcancel = context.WithCancel(ctx)
 	if c.InfluxDB.Url != "" {
 		if err := stats.SetupInfluxDB(influxctx, &c.InfluxDB, c.Identifier); err != nil {
@@ -41,11 +40,8 @@ func applyConfig(ctx context.Context, c *config.Config) error {
 		}
 	}
 
-	if c.ListenHTTP != "" {
-		httpsrv, err = startHttpServer(c.ListenHTTP)
-		if err != nil {
-			return err
-		}
+	if err := startHttpServers(ctx, c); err != nil {
+		return err
 	}
 	flowsLock.Lock()
 	defer flowsLock.Unlock()
@@ -55,6 +51,9 @@ func applyConfig(ctx context.Context, c *config.Config) error {
 			return err
 		}
 	}
+	if err := startSRTListener(ctx, c.SRTListener); err != nil {
+		return err
+	}
 	configLock.Lock()
 	runningConfig = c
 	configLock.Unlock()
@@ -95,31 +94,54 @@ func reloadConfigfile(ctx context.Context) {
 		}
 	}
 
-	if runningConfig.ListenHTTP != conf.ListenHTTP {
-		if httpsrv != nil {
-			shutdownctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
-			defer cancel()
-			if err := httpsrv.Shutdown(shutdownctx); err != nil {
-				logging.Log.Error().Err(err).Msg("error stopping webserver")
-				return
-			}
-			httpsrv = nil
+	if runningConfig.ListenHTTP != conf.ListenHTTP || runningConfig.API != conf.API {
+		if err := stopHttpServers(ctx); err != nil {
+			logging.Log.Error().Err(err).Msg("error stopping webserver")
+			return
 		}
-		if conf.ListenHTTP != "" {
-			httpsrv, err = startHttpServer(conf.ListenHTTP)
-			if err != nil {
-				logging.Log.Error().Err(err).Msg("failed to start webserv")
-				return
-			}
+		if err := startHttpServers(ctx, conf); err != nil {
+			logging.Log.Error().Err(err).Msg("failed to start webserv")
+			return
 		}
 	}
 
 	flowsLock.Lock()
 	defer flowsLock.Unlock()
 
+	if err := applyFlows(ctx, conf.Flows); err != nil {
+		logging.Log.Error().Err(err).Msgf("failed to apply flow config: %s", err)
+		return
+	}
+
+	if runningConfig.SRTListener != conf.SRTListener {
+		if err := startSRTListener(ctx, conf.SRTListener); err != nil {
+			logging.Log.Error().Err(err).Msg("failed to start global srt listener")
+			return
+		}
+	}
+
+	runningConfig = conf
+}
+
+// startSRTListener (re)starts the global srt listener, an empty url stops it.
+func startSRTListener(ctx context.Context, listener string) error {
+	if listener == "" {
+		srt.StopListener()
+		return nil
+	}
+	u, err := url.Parse(listener)
+	if err != nil {
+		return err
+	}
+	return srt.StartListener(ctx, u)
+}
+
+// applyFlows brings the running flows in line with the given flow configs,
+// caller must hold configLock and flowsLock.
+func applyFlows(ctx context.Context, fcs []config.Flow) error {
 	checkDelete := make(map[string]int)
 
-	for _, fc := range conf.Flows {
+	for _, fc := range fcs {
 		checkDelete[fc.Identifier] = 1
 	}
 
@@ -132,20 +154,17 @@ func reloadConfigfile(ctx context.Context) {
 		}
 	}
 
-	for _, fc := range conf.Flows {
+	for i := range fcs {
+		fc := fcs[i]
 		if fh, ok := flows[fc.Identifier]; ok {
 			if err := fh.f.UpdateConfig(&fc); err != nil {
-				logging.Log.Error().Err(err).Msg("error updatinf flow config")
-				return
+				return fmt.Errorf("error updating flow %s: %w", fc.Identifier, err)
 			}
 		} else {
-			err := createFlow(ctx, &fc)
-			if err != nil {
-				logging.Log.Error().Err(err).Msgf("couldn't create flow %s: %s", fc.Identifier, err)
-				return
+			if err := createFlow(ctx, &fc); err != nil {
+				return fmt.Errorf("couldn't create flow %s: %w", fc.Identifier, err)
 			}
 		}
 	}
-
-	runningConfig = conf
+	return nil
 }
@@ -7,23 +7,66 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
 
+	"github.com/EmadHeravi/streamsow/config"
 	"github.com/EmadHeravi/streamsow/logging"
 	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output"
 )
 
-func startHttpServer(listen string) (*http.Server, error) {
-	srv := &http.Server{Addr: listen}
+// startHttpServers starts the http server on listenhttp and, when the api
+// has a listen address of its own, the api server.
+func startHttpServers(ctx context.Context, c *config.Config) (err error) {
+	if c.ListenHTTP != "" {
+		httpsrv, err = serveHTTP(c.ListenHTTP, statusMux(ctx, &c.API))
+		if err != nil {
+			return err
+		}
+	}
+	if c.API.Enabled && c.API.Listen != "" {
+		mux := http.NewServeMux()
+		addAPIHandlers(ctx, mux, &c.API)
+		apisrv, err = serveHTTP(c.API.Listen, mux)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stopHttpServers stops the servers started by startHttpServers.
+func stopHttpServers(ctx context.Context) error {
+	for _, srv := range []**http.Server{&httpsrv, &apisrv} {
+		if *srv == nil {
+			continue
+		}
+		shutdownctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		err := (*srv).Shutdown(shutdownctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+		*srv = nil
+	}
+	return nil
+}
 
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+// statusMux serves /status, /metrics, the http outputs and the api when it
+// is enabled without a listen address of its own. Every server gets its own
+// mux, as the server is restarted on config reload.
+func statusMux(ctx context.Context, api *config.API) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
 		status := make(map[string]interface{})
 		status["status"] = "OK"
 		status["OK"] = true
 		statuses := make(map[string]*mainloop.Status)
 		flowsLock.Lock()
+		defer flowsLock.Unlock()
 
 		for id, fh := range flows {
 			statuses[id] = fh.f.Status()
@@ -42,8 +85,23 @@ func startHttpServer(listen string) (*http.Server, error) {
 		}
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		_, _ = w.Write(bytes)
-		flowsLock.Unlock()
 	})
+	mux.HandleFunc("/metrics", metricsHandler)
+	if api.Enabled && api.Listen == "" {
+		addAPIHandlers(ctx, mux, api)
+	}
+	mux.Handle(output.HTTPPathPrefix, output.HTTPHandler())
+	return mux
+}
+
+func addAPIHandlers(ctx context.Context, mux *http.ServeMux, api *config.API) {
+	h := apiAuth(api.Token, apiHandler(ctx))
+	mux.HandleFunc(apiPrefix, h)
+	mux.HandleFunc(apiPrefix+"/", h)
+}
+
+func serveHTTP(listen string, h http.Handler) (*http.Server, error) {
+	srv := &http.Server{Addr: listen, Handler: h, ConnContext: output.HTTPConnContext}
 	ec := make(chan error)
 	go func() {
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
@@ -0,0 +1,61 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/stats"
+)
+
+// addFlowMetrics adds the mainloop status of a flow, queue and hitless
+// stats are left out as they're exposed via the stats sinks.
+func addFlowMetrics(p *stats.PrometheusMetrics, identifier string, s *mainloop.Status) {
+	labels := map[string]string{"identifier": identifier}
+	ok := 0.0
+	if s.OK {
+		ok = 1
+	}
+	p.Add("flow_ok", stats.Gauge, labels, ok)
+	p.Add("flow_bitrate_bps", stats.Gauge, labels, float64(s.Bitrate))
+	p.Add("flow_ms_since_last_packet", stats.Gauge, labels, float64(s.MsSinceLastPacket))
+	p.Add("flow_packets_total", stats.Counter, labels, float64(s.PacketCount))
+	p.Add("flow_output_count", stats.Gauge, labels, float64(s.OutputCount))
+	p.Add("flow_input_switches_total", stats.Counter, labels, float64(s.InputSwitches))
+	for _, in := range s.Inputs {
+		inLabels := map[string]string{"identifier": identifier, "input_identifier": in.Identifier}
+		active := 0.0
+		if in.Active {
+			active = 1
+		}
+		healthy := 0.0
+		if in.Healthy {
+			healthy = 1
+		}
+		p.Add("flow_input_active", stats.Gauge, inLabels, active)
+		p.Add("flow_input_healthy", stats.Gauge, inLabels, healthy)
+		p.Add("flow_input_bitrate_bps", stats.Gauge, inLabels, float64(in.Bitrate))
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	p := stats.NewPrometheusMetrics()
+	flowsLock.Lock()
+	for id, fh := range flows {
+		addFlowMetrics(p, id, fh.f.StatusSnapshot())
+	}
+	flowsLock.Unlock()
+	p.AddStats()
+	p.AddRuntime()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := p.WriteTo(w); err != nil {
+		logging.Log.Error().Err(err).Msg("error writing metrics")
+	}
+}
@@ -34,6 +34,7 @@ var (
 	flowsLock     sync.Mutex
 	flows         map[string]*flowhandle
 	httpsrv       *http.Server
+	apisrv        *http.Server
 )
 
 func init() {
@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -21,10 +23,25 @@ import (
 // ------------------------------------------------------------
 
 type Config struct {
-	Identifier string         `yaml:"identifier"`
-	InfluxDB   InfluxDBConfig `yaml:"influxdb"`
-	ListenHTTP string         `yaml:"listenhttp"`
-	Flows      []Flow         `yaml:"flows"`
+	Identifier string         `yaml:"identifier" json:"identifier"`
+	InfluxDB   InfluxDBConfig `yaml:"influxdb" json:"influxdb"`
+	ListenHTTP string         `yaml:"listenhttp" json:"listenhttp"`
+	// srt:// url of a listener routing callers to flows by stream id
+	SRTListener string `yaml:"srtlistener" json:"srtlistener"`
+	// flow management api, disabled by default
+	API API `yaml:"api" json:"api"`
+	// write changes made via the http api back to the config file
+	APIWriteConfig bool   `yaml:"apiwriteconfig" json:"apiwriteconfig"`
+	Flows          []Flow `yaml:"flows" json:"flows"`
+}
+
+// API configures the flow management api.
+type API struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// (ip):port of a server only serving the api, defaults to listenhttp
+	Listen string `yaml:"listen" json:"listen"`
+	// when set every api request must carry "Authorization: Bearer TOKEN"
+	Token string `yaml:"token" json:"token"`
 }
 
 // ------------------------------------------------------------
@@ -33,22 +50,22 @@ type Config struct {
 
 type InfluxDBConfig struct {
 	// InfluxDB server URL
-	Url string `yaml:"url"`
+	Url string `yaml:"url" json:"url"`
 
 	// API token
-	Token string `yaml:"token"`
+	Token string `yaml:"token" json:"token"`
 
 	// InfluxDB organization
-	Org string `yaml:"org"`
+	Org string `yaml:"org" json:"org"`
 
 	// InfluxDB bucket
-	Bucket string `yaml:"bucket"`
+	Bucket string `yaml:"bucket" json:"bucket"`
 
 	// Measurement overrides
-	SrtMeasurement         string `yaml:"srtmeasurement"`
-	RistRXMeasurement      string `yaml:"ristrxmeasurement"`
-	RistTXMeasurement      string `yaml:"risttxmeasurement"`
-	ApplicationMeasurement string `yaml:"applicationmeasurement"`
+	SrtMeasurement         string `yaml:"srtmeasurement" json:"srtmeasurement"`
+	RistRXMeasurement      string `yaml:"ristrxmeasurement" json:"ristrxmeasurement"`
+	RistTXMeasurement      string `yaml:"risttxmeasurement" json:"risttxmeasurement"`
+	ApplicationMeasurement string `yaml:"applicationmeasurement" json:"applicationmeasurement"`
 }
 
 func (c *InfluxDBConfig) Validate() error {
@@ -82,17 +99,49 @@ func (c *InfluxDBConfig) Validate() error {
 // ------------------------------------------------------------
 
 type Flow struct {
-	Identifier      string   `yaml:"identifier"`
-	Type            string   `yaml:"type"` // RIST or UDP
-	RistProfile     int      `yaml:"ristprofile"`
-	Latency         int      `yaml:"latency"`
-	StreamID        int      `yaml:"streamid"`
-	MinimalBitrate  int      `yaml:"minimalbitrate"`
-	MaxPacketTimeMS int      `yaml:"maxpackettime"`
-	Inputs          []Input  `yaml:"inputs"`
-	Outputs         []Output `yaml:"outputs"`
-	StatsFile       string   `yaml:"statsfile"`
-	StatsStdOut     bool     `yaml:"statsstdout"`
+	Identifier      string   `yaml:"identifier" json:"identifier"`
+	Type            string   `yaml:"type" json:"type"` // RIST or UDP
+	RistProfile     int      `yaml:"ristprofile" json:"ristprofile"`
+	Latency         int      `yaml:"latency" json:"latency"`
+	StreamID        int      `yaml:"streamid" json:"streamid"`
+	MinimalBitrate  int      `yaml:"minimalbitrate" json:"minimalbitrate"`
+	MaxPacketTimeMS int      `yaml:"maxpackettime" json:"maxpackettime"`
+	Inputs          []Input  `yaml:"inputs" json:"inputs"`
+	Outputs         []Output `yaml:"outputs" json:"outputs"`
+	StatsFile       string   `yaml:"statsfile" json:"statsfile"`
+	StatsStdOut     bool     `yaml:"statsstdout" json:"statsstdout"`
+	Failover        Failover `yaml:"failover" json:"failover"`
+	Hitless         Hitless  `yaml:"hitless" json:"hitless"`
+	Analyzer        Analyzer `yaml:"analyzer" json:"analyzer"`
+}
+
+// Failover configures switching between inputs based on their priority.
+type Failover struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// ms without data after which the next input is used
+	SwitchAfterMS int `yaml:"switchafter" json:"switchafter"`
+	// bitrate below which the next input is used, 0 disables the check
+	MinimalBitrate int `yaml:"minimalbitrate" json:"minimalbitrate"`
+	// ms a higher priority input must be healthy before switching back
+	RestoreAfterMS int `yaml:"restoreafter" json:"restoreafter"`
+}
+
+// Hitless configures SMPTE 2022-7 merging of the flow's two rtp:// inputs.
+type Hitless struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// ms a packet missing on both legs is waited for
+	MaxSkewMS int `yaml:"maxskew" json:"maxskew"`
+}
+
+// Analyzer configures TR 101 290 priority 1 checking of the flow's stream.
+type Analyzer struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// max ms between PAT/PMT sections, defaults to 500
+	PSITimeoutMS int `yaml:"psitimeout" json:"psitimeout"`
+	// max ms between packets of a PID referenced in a PMT, defaults to 5000
+	PIDTimeoutMS int `yaml:"pidtimeout" json:"pidtimeout"`
+	// flip the flow status to NOT-OK on priority 1 errors
+	NotOKOnErrors bool `yaml:"notokonerrors" json:"notokonerrors"`
 }
 
 // ------------------------------------------------------------
@@ -100,13 +149,74 @@ type Flow struct {
 // ------------------------------------------------------------
 
 type Input struct {
-	Identifier string `yaml:"identifier"`
-	URL        string `yaml:"url"`
+	Identifier string `yaml:"identifier" json:"identifier"`
+	URL        string `yaml:"url" json:"url"`
+	// lower is preferred, only used when failover is enabled
+	Priority int `yaml:"priority" json:"priority"`
+	// restore null packets removed by a streamzeug sender (srt/rist only)
+	NullReinsertion bool `yaml:"nullreinsertion" json:"nullreinsertion"`
 }
 
 type Output struct {
-	Identifier string `yaml:"identifier"`
-	URL        string `yaml:"url"`
+	Identifier string `yaml:"identifier" json:"identifier"`
+	URL        string `yaml:"url" json:"url"`
+	// blocks queued between mainloop and output, defaults to 256
+	QueueDepth int `yaml:"queuedepth" json:"queuedepth"`
+	// drop-newest (default), drop-oldest or disconnect
+	Backpressure string `yaml:"backpressure" json:"backpressure"`
+	// seconds of sustained backpressure before disconnecting, defaults to 5
+	DisconnectAfter int `yaml:"disconnectafter" json:"disconnectafter"`
+	// retry policy of outputs that reconnect (srt caller, floating udp, rist)
+	Reconnect Reconnect `yaml:"reconnect" json:"reconnect"`
+	// optional TS processing of the stream sent to the output
+	TS TSProcessing `yaml:"ts" json:"ts"`
+	// optional constant bitrate null stuffing and pacing
+	CBR CBR `yaml:"cbr" json:"cbr"`
+	// remove null packets, signalled for reinsertion (srt/rist only)
+	NullDeletion bool `yaml:"nulldeletion" json:"nulldeletion"`
+}
+
+// CBR stuffs the stream sent to an output with null packets to a constant
+// bitrate and paces it in real time, PCRs are corrected.
+type CBR struct {
+	// TS bitrate in bits/s, 0 disables
+	Bitrate int `yaml:"bitrate" json:"bitrate"`
+	// max ms packets are queued when the input exceeds the bitrate,
+	// defaults to 500
+	MaxDelayMS int `yaml:"maxdelay" json:"maxdelay"`
+}
+
+// TSProcessing selects and renumbers the PIDs sent to an output, the PAT
+// and PMTs are rewritten to match.
+type TSProcessing struct {
+	// when not 0 only this program of a multi program TS is passed
+	Program int `yaml:"program" json:"program"`
+	// SDT/EIT handling with a program: filter (default), pass or drop
+	SI string `yaml:"si" json:"si"`
+	// when not empty only these PIDs are passed
+	Pids []int `yaml:"pids" json:"pids"`
+	// PIDs dropped, dropping a PMT PID removes the program
+	DropPids []int `yaml:"droppids" json:"droppids"`
+	// PIDs renumbered, from: to
+	Remap map[int]int `yaml:"remap" json:"remap"`
+}
+
+// Enabled returns true when any processing is configured.
+func (t *TSProcessing) Enabled() bool {
+	return t.Program > 0 || len(t.Pids) > 0 || len(t.DropPids) > 0 || len(t.Remap) > 0
+}
+
+// Reconnect configures the exponential backoff between reconnect attempts,
+// zero values use the defaults.
+type Reconnect struct {
+	// delay before the first attempt in ms, defaults to 100
+	InitialDelayMS int `yaml:"initialdelay" json:"initialdelay"`
+	// upper bound of the delay in ms, defaults to 10000
+	MaxDelayMS int `yaml:"maxdelay" json:"maxdelay"`
+	// random variation of each delay as a fraction, 0-1, defaults to 0.2
+	Jitter float64 `yaml:"jitter" json:"jitter"`
+	// attempts before the output is marked failed, 0 retries forever
+	MaxAttempts int `yaml:"maxattempts" json:"maxattempts"`
 }
 
 // ------------------------------------------------------------
@@ -150,7 +260,7 @@ func (f *Flow) ValidateFlowConfig() error {
 		}
 
 		switch u.Scheme {
-		case "rist", "udp", "rtp":
+		case "rist", "udp", "rtp", "srt", "testsrc", "file":
 		default:
 			return fmt.Errorf("unsupported input scheme: %s", u.Scheme)
 		}
@@ -176,7 +286,9 @@ func (f *Flow) ValidateFlowConfig() error {
 			return fmt.Errorf("invalid output URL: %s", out.URL)
 		}
 
-		if u.Scheme != "srt" {
+		switch u.Scheme {
+		case "srt", "udp", "rtp", "rist", "dektecasi", "file", "http", "hls":
+		default:
 			return fmt.Errorf("unsupported output scheme: %s", u.Scheme)
 		}
 	}
@@ -214,6 +326,38 @@ func LoadFromFile(filename string) (*Config, error) {
 	return &conf, nil
 }
 
+// SaveToFile atomically replaces filename with conf, the file is written to a
+// temporary file in the same directory which is then renamed.
+func SaveToFile(filename string, conf *Config) error {
+	yamlData, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if st, err := os.Stat(filename); err == nil {
+		if err := tmp.Chmod(st.Mode()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if _, err := tmp.Write(yamlData); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
 func ValidateConfig(conf *Config) error {
 	if conf == nil {
 		return errors.New("conf is nil")
@@ -224,6 +368,14 @@ func ValidateConfig(conf *Config) error {
 		return err
 	}
 
+	if err := validateSRTListener(conf.SRTListener); err != nil {
+		return err
+	}
+
+	if conf.API.Enabled && conf.API.Listen == "" && conf.ListenHTTP == "" {
+		return errors.New("api enabled without api.listen or listenhttp")
+	}
+
 	// validate each flow
 	for i := range conf.Flows {
 		if err := ValidateFlowConfig(&conf.Flows[i]); err != nil {
@@ -233,3 +385,23 @@ func ValidateConfig(conf *Config) error {
 
 	return nil
 }
+
+func validateSRTListener(listener string) error {
+	if listener == "" {
+		return nil
+	}
+	u, err := url.Parse(listener)
+	if err != nil {
+		return fmt.Errorf("invalid srtlistener %q: %w", listener, err)
+	}
+	if u.Scheme != "srt" {
+		return errors.New("srtlistener must be an srt:// url")
+	}
+	if u.Port() == "" {
+		return errors.New("srtlistener requires a port")
+	}
+	if mode := u.Query().Get("mode"); mode != "" && mode != "listener" {
+		return errors.New("srtlistener must be in listener mode")
+	}
+	return nil
+}
@@ -24,7 +24,7 @@ func ValidateFlowConfig(c *Flow) error {
 	}
 
 	// --------------------------------------
-	// INPUT VALIDATION (RIST OR UDP/RTP)
+	// INPUT VALIDATION (RIST, UDP/RTP OR SRT)
 	// --------------------------------------
 	for _, in := range c.Inputs {
 		u, err := url.Parse(in.URL)
@@ -36,12 +36,41 @@ func ValidateFlowConfig(c *Flow) error {
 		case "rist":
 		case "udp":
 		case "rtp":
+		case "srt":
+		case "testsrc":
+		case "file":
 			// accepted
 		default:
-			return fmt.Errorf("input scheme %s not supported (rist, udp, rtp allowed)", u.Scheme)
+			return fmt.Errorf("input scheme %s not supported (rist, udp, rtp, srt, testsrc, file allowed)", u.Scheme)
+		}
+		if in.NullReinsertion && u.Scheme != "srt" && u.Scheme != "rist" {
+			return fmt.Errorf("input %s: nullreinsertion is only supported on srt and rist inputs", in.URL)
+		}
+	}
+
+	if c.Hitless.Enabled {
+		rtpInputs := 0
+		for _, in := range c.Inputs {
+			if u, _ := url.Parse(in.URL); u.Scheme == "rtp" {
+				rtpInputs++
+			}
+		}
+		if rtpInputs != 2 {
+			return fmt.Errorf("flow %s: hitless merging requires exactly two rtp inputs", c.Identifier)
+		}
+		if c.Hitless.MaxSkewMS < 0 {
+			return fmt.Errorf("flow %s: hitless maxskew must not be negative", c.Identifier)
 		}
 	}
 
+	if c.Failover.SwitchAfterMS < 0 || c.Failover.RestoreAfterMS < 0 || c.Failover.MinimalBitrate < 0 {
+		return fmt.Errorf("flow %s: failover settings must not be negative", c.Identifier)
+	}
+
+	if c.Analyzer.PSITimeoutMS < 0 || c.Analyzer.PIDTimeoutMS < 0 {
+		return fmt.Errorf("flow %s: analyzer timeouts must not be negative", c.Identifier)
+	}
+
 	// --------------------------------------
 	// OUTPUT VALIDATION
 	// --------------------------------------
@@ -52,12 +81,109 @@ func ValidateFlowConfig(c *Flow) error {
 		}
 
 		switch u.Scheme {
-		case "srt", "udp", "rtp", "dektecasi":
+		case "srt", "udp", "rtp", "rist", "dektecasi", "file", "http", "hls":
 			// accepted
 		default:
 			return fmt.Errorf("output scheme %s not supported", u.Scheme)
 		}
+
+		switch out.Backpressure {
+		case "", "drop-newest", "drop-oldest", "disconnect":
+			// accepted
+		default:
+			return fmt.Errorf("output %s: backpressure policy %s not supported", out.Identifier, out.Backpressure)
+		}
+		if out.QueueDepth < 0 || out.DisconnectAfter < 0 {
+			return fmt.Errorf("output %s: queuedepth and disconnectafter must not be negative", out.Identifier)
+		}
+		r := out.Reconnect
+		if r.InitialDelayMS < 0 || r.MaxDelayMS < 0 || r.MaxAttempts < 0 {
+			return fmt.Errorf("output %s: reconnect settings must not be negative", out.Identifier)
+		}
+		if r.Jitter < 0 || r.Jitter > 1 {
+			return fmt.Errorf("output %s: reconnect jitter must be between 0 and 1", out.Identifier)
+		}
+		if r.MaxDelayMS > 0 && r.InitialDelayMS > r.MaxDelayMS {
+			return fmt.Errorf("output %s: reconnect initialdelay exceeds maxdelay", out.Identifier)
+		}
+		if err := validateTSProcessing(&out.TS); err != nil {
+			return fmt.Errorf("output %s: %w", out.Identifier, err)
+		}
+		if err := validateCBR(&out.CBR); err != nil {
+			return fmt.Errorf("output %s: %w", out.Identifier, err)
+		}
+		if out.NullDeletion {
+			if u.Scheme != "srt" && u.Scheme != "rist" {
+				return fmt.Errorf("output %s: nulldeletion is only supported on srt and rist outputs", out.Identifier)
+			}
+			if out.CBR.Bitrate > 0 {
+				return fmt.Errorf("output %s: nulldeletion can't be combined with cbr", out.Identifier)
+			}
+		}
 	}
 
 	return nil
 }
+
+// maxPID is the highest PID, the null PID
+const maxPID = 0x1fff
+
+func validateTSProcessing(t *TSProcessing) error {
+	if t.Program < 0 || t.Program > 0xffff {
+		return fmt.Errorf("ts: invalid program %d", t.Program)
+	}
+	switch t.SI {
+	case "", "filter", "pass", "drop":
+	default:
+		return fmt.Errorf("ts: invalid si %q, must be filter, pass or drop", t.SI)
+	}
+	for _, pid := range append(append([]int{}, t.Pids...), t.DropPids...) {
+		if pid < 0 || pid > maxPID {
+			return fmt.Errorf("ts: invalid pid %d", pid)
+		}
+	}
+	targets := make(map[int]bool, len(t.Remap))
+	for from, to := range t.Remap {
+		// PAT and null packets keep their PID
+		if from <= 0 || from >= maxPID || to <= 0 || to >= maxPID {
+			return fmt.Errorf("ts: invalid remap %d: %d", from, to)
+		}
+		if targets[to] {
+			return fmt.Errorf("ts: pid %d is remap target more than once", to)
+		}
+		targets[to] = true
+	}
+	keep := make(map[int]bool, len(t.Pids))
+	for _, pid := range t.Pids {
+		keep[pid] = true
+	}
+	dropped := make(map[int]bool, len(t.DropPids))
+	for _, pid := range t.DropPids {
+		dropped[pid] = true
+	}
+	for from, to := range t.Remap {
+		if _, remapped := t.Remap[to]; remapped || dropped[to] {
+			continue
+		}
+		// only a kept target is known to be sent, other collisions depend
+		// on the stream and are left to the processor
+		if keep[to] {
+			return fmt.Errorf("ts: remap %d: %d collides with pid %d which is kept, drop or remap it", from, to, to)
+		}
+	}
+	return nil
+}
+
+// maxCBRDelayMS bounds the queueing of constant bitrate outputs, restamped
+// PCRs move by up to this much.
+const maxCBRDelayMS = 1000
+
+func validateCBR(c *CBR) error {
+	if c.Bitrate < 0 {
+		return fmt.Errorf("cbr: invalid bitrate %d", c.Bitrate)
+	}
+	if c.MaxDelayMS < 0 || c.MaxDelayMS > maxCBRDelayMS {
+		return fmt.Errorf("cbr: maxdelay must be 0-%d ms", maxCBRDelayMS)
+	}
+	return nil
+}
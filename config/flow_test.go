@@ -0,0 +1,31 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V.
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package config
+
+import "testing"
+
+func TestValidateTSProcessingRemap(t *testing.T) {
+	tests := []struct {
+		name  string
+		ts    TSProcessing
+		valid bool
+	}{
+		{"target not listed", TSProcessing{Remap: map[int]int{0x100: 0x200}}, true},
+		{"target dropped", TSProcessing{DropPids: []int{0x200}, Remap: map[int]int{0x100: 0x200}}, true},
+		{"target remapped", TSProcessing{Remap: map[int]int{0x100: 0x200, 0x200: 0x100}}, true},
+		{"target kept", TSProcessing{Pids: []int{0x100, 0x200}, Remap: map[int]int{0x100: 0x200}}, false},
+		{"target not kept", TSProcessing{Pids: []int{0x100}, Remap: map[int]int{0x100: 0x200}}, true},
+		{"target twice", TSProcessing{DropPids: []int{0x200}, Remap: map[int]int{0x100: 0x200, 0x101: 0x200}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTSProcessing(&tt.ts)
+			if (err == nil) != tt.valid {
+				t.Errorf("validateTSProcessing() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
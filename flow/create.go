@@ -13,10 +13,10 @@ import (
 
 	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/EmadHeravi/streamsow/config"
-	"github.com/EmadHeravi/streamsow/input"
 	"github.com/EmadHeravi/streamsow/input/rist"
 	"github.com/EmadHeravi/streamsow/logging"
 	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output/srt"
 	"github.com/EmadHeravi/streamsow/stats"
 )
 
@@ -74,7 +74,8 @@ func CreateFlow(ctx context.Context, c *config.Flow) (*Flow, error) {
 	}
 
 	// INPUTS
-	flow.configuredInputs = make(map[string]input.Input)
+	flow.setupHitless(c)
+	flow.configuredInputs = make(map[string]inhandle)
 	for _, in := range c.Inputs {
 		if err := flow.setupInput(&in); err != nil {
 			return nil, fmt.Errorf("failed to setup input %s: %w", in, err)
@@ -99,11 +100,13 @@ func CreateFlow(ctx context.Context, c *config.Flow) (*Flow, error) {
 	}
 
 	// create mainloop
-	flow.m = mainloop.NewMainloop(flow.context, rf, c.Identifier)
+	flow.m = mainloop.NewMainloop(flow.context, rf, c.Identifier, flow.statsConfig)
+	flow.configureFailover(c)
+	flow.configureAnalyzer(c)
 
-	// start UDP inputs (only now that mainloop / channels exist)
-	if err := flow.startUDPInputs(); err != nil {
-		return nil, fmt.Errorf("failed to start udp inputs: %w", err)
+	// start UDP/SRT inputs (only now that mainloop / channels exist)
+	if err := flow.startInputs(); err != nil {
+		return nil, fmt.Errorf("failed to start inputs: %w", err)
 	}
 
 	// OUTPUTS
@@ -114,5 +117,8 @@ func CreateFlow(ctx context.Context, c *config.Flow) (*Flow, error) {
 		}
 	}
 
+	// reachable through the global srt listener
+	srt.RegisterFlow(flow.context, c.Identifier, flow.m, flow.statsConfig)
+
 	return &flow, nil
 }
@@ -13,10 +13,12 @@ import (
 
 	"code.videolan.org/rist/ristgo"
 	"github.com/EmadHeravi/streamsow/config"
-	"github.com/EmadHeravi/streamsow/input"
+	"github.com/EmadHeravi/streamsow/input/udp"
 	"github.com/EmadHeravi/streamsow/logging"
 	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output/srt"
 	"github.com/EmadHeravi/streamsow/stats"
+	"github.com/EmadHeravi/streamsow/ts"
 )
 
 // Flow is the main running entity
@@ -28,7 +30,8 @@ type Flow struct {
 	configuredOutputs map[string]outhandle
 	configLock        sync.Mutex
 	config            config.Flow
-	configuredInputs  map[string]input.Input
+	configuredInputs  map[string]inhandle
+	hitless           *udp.Merger
 	m                 *mainloop.Mainloop
 	outputWait        *sync.WaitGroup
 	statsConfig       *stats.Stats
@@ -36,7 +39,16 @@ type Flow struct {
 }
 
 func (f *Flow) Status() *mainloop.Status {
-	mlStatus := f.m.Status()
+	return f.status(f.m.Status())
+}
+
+// StatusSnapshot returns the status without restarting the windows /status
+// judges the flow on.
+func (f *Flow) StatusSnapshot() *mainloop.Status {
+	return f.status(f.m.StatusSnapshot())
+}
+
+func (f *Flow) status(mlStatus *mainloop.Status) *mainloop.Status {
 	f.configLock.Lock()
 	defer f.configLock.Unlock()
 
@@ -48,18 +60,53 @@ func (f *Flow) Status() *mainloop.Status {
 		}
 	}
 
+	if f.config.Analyzer.NotOKOnErrors && mlStatus.TR101290 != nil {
+		if mlStatus.TSErrorsSince > 0 || !mlStatus.TR101290.InSync {
+			mlStatus.Status = "NOT-OK"
+			mlStatus.OK = false
+		}
+	}
+
+	if f.hitless != nil {
+		hitlessStats := f.hitless.Stats()
+		mlStatus.Hitless = &hitlessStats
+	}
+
+	mlStatus.Clients = make(map[string]int, len(f.configuredOutputs))
+	for _, oh := range f.configuredOutputs {
+		mlStatus.Clients[oh.conf.Identifier] += oh.out.Count()
+	}
+	mlStatus.Outputs = f.outputStatus()
+
 	return mlStatus
 }
 
+// configureAnalyzer enables or disables the TR 101 290 analyzer.
+func (f *Flow) configureAnalyzer(c *config.Flow) {
+	if !c.Analyzer.Enabled {
+		f.m.ConfigureAnalyzer(nil)
+		return
+	}
+	f.m.ConfigureAnalyzer(&ts.AnalyzerSettings{
+		PSITimeout: time.Duration(c.Analyzer.PSITimeoutMS) * time.Millisecond,
+		PIDTimeout: time.Duration(c.Analyzer.PIDTimeoutMS) * time.Millisecond,
+	})
+}
+
 func (f *Flow) Stop() {
+	srt.UnregisterFlow(f.identifier)
 	f.cancel()
 
 	for _, o := range f.configuredOutputs {
 		o.out.Close()
 	}
 
-	for _, in := range f.configuredInputs {
-		in.Close()
+	for _, ih := range f.configuredInputs {
+		ih.in.Close()
+	}
+
+	if f.hitless != nil {
+		f.hitless.Close()
 	}
 }
 
@@ -3,17 +3,47 @@ package flow
 import (
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/EmadHeravi/streamsow/config"
 	"github.com/EmadHeravi/streamsow/input"
+	"github.com/EmadHeravi/streamsow/input/file"
 	"github.com/EmadHeravi/streamsow/input/rist"
+	"github.com/EmadHeravi/streamsow/input/srt"
+	"github.com/EmadHeravi/streamsow/input/testsrc"
 	"github.com/EmadHeravi/streamsow/input/udp"
+	"github.com/EmadHeravi/streamsow/mainloop"
 )
 
+const (
+	defaultSwitchAfter  = 500 * time.Millisecond
+	defaultRestoreAfter = 5 * time.Second
+	defaultMaxSkew      = 50 * time.Millisecond
+	// name of the merged rtp inputs in the mainloop
+	hitlessInputName = "hitless"
+)
+
+type inhandle struct {
+	in   input.Input
+	conf config.Input
+}
+
+// inputName returns the name an input is known by in the mainloop,
+// the url host is used when no identifier is configured.
+func inputName(c *config.Input) string {
+	if c.Identifier != "" {
+		return c.Identifier
+	}
+	if u, err := url.Parse(c.URL); err == nil {
+		return u.Host
+	}
+	return c.URL
+}
+
 // setupInput chooses and initializes the correct input based on URL scheme.
 // For RIST inputs we use the existing rist.SetupRistInput helper.
-// For UDP/RTP inputs we construct a udp.UdpInput; the reader goroutine
-// is started later once the mainloop is available.
+// For UDP/RTP and SRT inputs we construct an input.Reader; the reader
+// goroutine is started once the mainloop is available.
 func (f *Flow) setupInput(c *config.Input) error {
 	u, err := url.Parse(c.URL)
 	if err != nil {
@@ -30,37 +60,147 @@ func (f *Flow) setupInput(c *config.Input) error {
 		}
 
 	case "udp", "rtp":
-		in, err = udp.NewUdpInput(f.context, u, c.Identifier) // ✔ FIXED
+		if u.Scheme == "rtp" && f.hitless != nil {
+			in, err = f.hitless.AddLeg(u, c.Identifier)
+		} else {
+			in, err = udp.NewUdpInput(f.context, u, c.Identifier, f.statsConfig)
+		}
 		if err != nil {
 			return fmt.Errorf("could not setup udp input %q: %w", c.URL, err)
 		}
 
+	case "file":
+		in, err = file.NewFileInput(f.context, u, c.Identifier)
+		if err != nil {
+			return fmt.Errorf("could not setup file input %q: %w", c.URL, err)
+		}
+
+	case "testsrc":
+		in, err = testsrc.NewTestSrcInput(f.context, u, c.Identifier)
+		if err != nil {
+			return fmt.Errorf("could not setup testsrc input %q: %w", c.URL, err)
+		}
+
+	case "srt":
+		in, err = srt.SetupSrtInput(f.context, u, f.identifier, c.Identifier, f.statsConfig)
+		if err != nil {
+			return fmt.Errorf("could not setup srt input %q: %w", c.URL, err)
+		}
+
 	default:
 		return fmt.Errorf("unsupported input scheme %q", u.Scheme)
 	}
 
-	f.configuredInputs[c.URL] = in
+	// inputs added on config reload are started right away
+	if f.m != nil {
+		if err := f.startReader(c, in); err != nil {
+			in.Close()
+			return fmt.Errorf("failed to start input %s: %w", c.URL, err)
+		}
+	}
+
+	f.configuredInputs[c.URL] = inhandle{
+		in:   in,
+		conf: *c,
+	}
 	return nil
 }
 
-// startUDPInputs is called once the mainloop has been created.
-// It finds all configured UDP/RTP inputs and starts their reader
-// goroutines, wiring them into the mainloop's UDP channel.
-func (f *Flow) startUDPInputs() error {
-	if f.m == nil {
+// startReader starts the reader goroutine of inputs that don't
+// feed the RIST receiver, registering them as mainloop input.
+func (f *Flow) startReader(c *config.Input, in input.Input) error {
+	reader, ok := in.(input.Reader)
+	if !ok {
 		return nil
 	}
+	f.m.SetNullReinsertion(inputName(c), c.NullReinsertion)
+	return reader.StartReader(f.m.AddInput(inputName(c), c.Priority))
+}
 
-	udpChan := f.m.UDPChannel()
+// closeInput stops an input and unregisters it from the mainloop.
+func (f *Flow) closeInput(ih inhandle) {
+	ih.in.Close()
+	if _, ok := ih.in.(input.Reader); ok && f.m != nil {
+		f.m.RemoveInput(inputName(&ih.conf))
+		f.m.SetNullReinsertion(inputName(&ih.conf), false)
+	}
+}
 
-	for urlStr, in := range f.configuredInputs {
-		udpInput, ok := in.(*udp.UdpInput)
-		if !ok {
+// configureFailover passes the flow's failover settings to the mainloop.
+// All rist:// inputs are merged by librist, so the RIST receiver is one
+// input with the best priority of the rist inputs.
+func (f *Flow) configureFailover(c *config.Flow) {
+	settings := mainloop.FailoverSettings{
+		Enabled:        c.Failover.Enabled,
+		SwitchAfter:    time.Duration(c.Failover.SwitchAfterMS) * time.Millisecond,
+		MinimalBitrate: c.Failover.MinimalBitrate,
+		RestoreAfter:   time.Duration(c.Failover.RestoreAfterMS) * time.Millisecond,
+	}
+	if settings.SwitchAfter == 0 {
+		settings.SwitchAfter = defaultSwitchAfter
+	}
+	if settings.RestoreAfter == 0 {
+		settings.RestoreAfter = defaultRestoreAfter
+	}
+
+	hasRist := false
+	ristPriority := 0
+	// the merged rist inputs share the reinsertion state
+	ristNullReinsertion := false
+	for _, in := range c.Inputs {
+		u, err := url.Parse(in.URL)
+		if err != nil || u.Scheme != "rist" {
 			continue
 		}
+		if !hasRist || in.Priority < ristPriority {
+			ristPriority = in.Priority
+		}
+		hasRist = true
+		ristNullReinsertion = ristNullReinsertion || in.NullReinsertion
+	}
+	f.m.SetRistInput(ristPriority, hasRist)
+	f.m.SetNullReinsertion(mainloop.RistInputIdentifier, ristNullReinsertion)
+	f.m.ConfigureFailover(settings)
+}
+
+// setupHitless creates the SMPTE 2022-7 merger the flow's rtp inputs
+// are added to as legs.
+func (f *Flow) setupHitless(c *config.Flow) {
+	if !c.Hitless.Enabled {
+		return
+	}
+	maxSkew := time.Duration(c.Hitless.MaxSkewMS) * time.Millisecond
+	if maxSkew == 0 {
+		maxSkew = defaultMaxSkew
+	}
+	f.hitless = udp.NewMerger(f.context, c.Identifier, maxSkew, f.statsConfig)
+}
+
+// startInputs is called once the mainloop has been created.
+// It starts the reader goroutines of all configured UDP/RTP
+// and SRT inputs, and of the hitless merge.
+func (f *Flow) startInputs() error {
+	if f.m == nil {
+		return nil
+	}
+
+	for urlStr, ih := range f.configuredInputs {
+		if err := f.startReader(&ih.conf, ih.in); err != nil {
+			return fmt.Errorf("failed to start input %s: %w", urlStr, err)
+		}
+	}
 
-		if err := udpInput.StartReader(udpChan); err != nil {
-			return fmt.Errorf("failed to start udp input %s: %w", urlStr, err)
+	if f.hitless != nil {
+		priority := 0
+		first := true
+		for _, ih := range f.configuredInputs {
+			if u, _ := url.Parse(ih.conf.URL); u.Scheme == "rtp" && (first || ih.conf.Priority < priority) {
+				priority = ih.conf.Priority
+				first = false
+			}
+		}
+		if err := f.hitless.StartReader(f.m.AddInput(hitlessInputName, priority)); err != nil {
+			return fmt.Errorf("failed to start hitless merge: %w", err)
 		}
 	}
 
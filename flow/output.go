@@ -9,12 +9,25 @@ package flow
 import (
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/EmadHeravi/streamsow/config"
+	"github.com/EmadHeravi/streamsow/mainloop"
 	"github.com/EmadHeravi/streamsow/output"
 	"github.com/EmadHeravi/streamsow/output/dektecasi"
+	"github.com/EmadHeravi/streamsow/output/file"
+	"github.com/EmadHeravi/streamsow/output/hls"
+	"github.com/EmadHeravi/streamsow/output/httpts"
+	"github.com/EmadHeravi/streamsow/output/rist"
 	"github.com/EmadHeravi/streamsow/output/srt"
 	"github.com/EmadHeravi/streamsow/output/udp"
+	"github.com/EmadHeravi/streamsow/sanitise"
+	"github.com/EmadHeravi/streamsow/ts"
+)
+
+const (
+	defaultDisconnectAfter = 5 * time.Second
+	defaultCBRMaxDelay     = 500 * time.Millisecond
 )
 
 type outhandle struct {
@@ -22,6 +35,100 @@ type outhandle struct {
 	conf config.Output
 }
 
+// outputSettings converts the queue settings of an output config.
+func outputSettings(c *config.Output) mainloop.OutputSettings {
+	settings := mainloop.OutputSettings{
+		QueueDepth:      c.QueueDepth,
+		Policy:          mainloop.BackpressurePolicy(c.Backpressure),
+		DisconnectAfter: time.Duration(c.DisconnectAfter) * time.Second,
+		NullDeletion:    c.NullDeletion,
+	}
+	if settings.Policy == "" {
+		settings.Policy = mainloop.DropNewest
+		// a slow http client is better dropped than served with gaps
+		if u, err := url.Parse(c.URL); err == nil && u.Scheme == "http" {
+			settings.Policy = mainloop.Disconnect
+		}
+	}
+	if settings.DisconnectAfter == 0 {
+		settings.DisconnectAfter = defaultDisconnectAfter
+	}
+	if c.TS.Enabled() {
+		settings.Processing = processorConfig(&c.TS)
+	}
+	if c.CBR.Bitrate > 0 {
+		settings.CBRBitrate = c.CBR.Bitrate
+		settings.CBRMaxDelay = time.Duration(c.CBR.MaxDelayMS) * time.Millisecond
+		if settings.CBRMaxDelay == 0 {
+			settings.CBRMaxDelay = defaultCBRMaxDelay
+		}
+	}
+	return settings
+}
+
+// processorConfig converts the TS processing settings of an output config.
+func processorConfig(t *config.TSProcessing) *ts.ProcessorConfig {
+	pc := &ts.ProcessorConfig{
+		Program: uint16(t.Program),
+		SI:      ts.SIMode(t.SI),
+		Remap:   make(map[uint16]uint16, len(t.Remap)),
+	}
+	for _, pid := range t.Pids {
+		pc.Keep = append(pc.Keep, uint16(pid))
+	}
+	for _, pid := range t.DropPids {
+		pc.Drop = append(pc.Drop, uint16(pid))
+	}
+	for from, to := range t.Remap {
+		pc.Remap[uint16(from)] = uint16(to)
+	}
+	return pc
+}
+
+// reconnectPolicy converts the reconnect settings of an output config.
+func reconnectPolicy(c *config.Output) output.ReconnectPolicy {
+	policy := output.DefaultReconnectPolicy
+	if c.Reconnect.InitialDelayMS > 0 {
+		policy.InitialDelay = time.Duration(c.Reconnect.InitialDelayMS) * time.Millisecond
+	}
+	if c.Reconnect.MaxDelayMS > 0 {
+		policy.MaxDelay = time.Duration(c.Reconnect.MaxDelayMS) * time.Millisecond
+	}
+	if policy.InitialDelay > policy.MaxDelay {
+		policy.MaxDelay = policy.InitialDelay
+	}
+	if c.Reconnect.Jitter > 0 {
+		policy.Jitter = c.Reconnect.Jitter
+	}
+	policy.MaxAttempts = c.Reconnect.MaxAttempts
+	return policy
+}
+
+// outputStatus returns the status of the configured outputs in config
+// order, caller must hold configLock.
+func (f *Flow) outputStatus() []output.Status {
+	statuses := make([]output.Status, 0, len(f.config.Outputs))
+	for _, c := range f.config.Outputs {
+		oh, ok := f.configuredOutputs[c.URL]
+		if !ok {
+			continue
+		}
+		var status output.Status
+		if r, ok := oh.out.(output.StatusReporter); ok {
+			status = r.Status()
+		} else {
+			status.State = output.StateActive
+		}
+		status.Identifier = c.Identifier
+		if u, err := url.Parse(c.URL); err == nil {
+			status.URL = sanitise.URL(u).String()
+			status.Type = u.Scheme
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
 func (f *Flow) setupOutput(c *config.Output) error {
 	// Parse output URL
 	outputURL, err := url.Parse(c.URL)
@@ -31,17 +138,31 @@ func (f *Flow) setupOutput(c *config.Output) error {
 
 	var out output.Output
 
+	f.m.SetOutputSettings(c.Identifier, outputSettings(c))
+
 	// Select correct output handler
 	switch outputURL.Scheme {
 	case "udp", "rtp":
-		out, err = udp.ParseUdpOutput(f.context, outputURL, f.identifier, f.m)
+		out, err = udp.ParseUdpOutput(f.context, outputURL, f.identifier, c.Identifier, f.m, reconnectPolicy(c))
 
 	case "srt":
-		out, err = srt.ParseSrtOutput(f.context, outputURL, f.identifier, c.Identifier, f.m, f.statsConfig, f.outputWait)
+		out, err = srt.ParseSrtOutput(f.context, outputURL, f.identifier, c.Identifier, f.m, f.statsConfig, f.outputWait, reconnectPolicy(c))
+
+	case "rist":
+		out, err = rist.ParseRistOutput(f.context, outputURL, f.identifier, c.Identifier, f.m, f.statsConfig, f.outputWait, reconnectPolicy(c))
 
 	case "dektecasi":
 		out, err = dektecasi.ParseURL(f.context, outputURL, f.identifier, c.Identifier, f.m, f.statsConfig)
 
+	case "file":
+		out, err = file.ParseFileOutput(outputURL, f.identifier, c.Identifier, f.m)
+
+	case "http":
+		out, err = httpts.ParseHTTPOutput(f.context, outputURL, f.identifier, c.Identifier, f.m)
+
+	case "hls":
+		out, err = hls.ParseHLSOutput(outputURL, f.identifier, c.Identifier, f.m)
+
 	default:
 		return fmt.Errorf("output URL scheme not implemented: %s", outputURL.Scheme)
 	}
@@ -45,14 +45,16 @@ func (f *Flow) UpdateConfig(c *config.Flow) (err error) {
 			Msgf("error configuring: %s", err)
 	}()
 
-	// RIST receiver settings changed: rebuild the whole flow
+	// RIST receiver or hitless merge settings changed: rebuild the whole flow
 	if c.Latency != f.config.Latency ||
 		c.RistProfile != f.config.RistProfile ||
-		c.StreamID != f.config.StreamID {
+		c.StreamID != f.config.StreamID ||
+		!reflect.DeepEqual(c.Hitless, f.config.Hitless) ||
+		(c.Hitless.Enabled && !reflect.DeepEqual(c.Inputs, f.config.Inputs)) {
 
 		logging.Log.Info().
 			Str("identifier", f.config.Identifier).
-			Msg("rist settings changed, re-creating")
+			Msg("rist or hitless settings changed, re-creating")
 
 		f.Stop()
 		f.Wait(5 * time.Millisecond)
@@ -76,16 +78,22 @@ func (f *Flow) UpdateConfig(c *config.Flow) (err error) {
 		}
 
 		// Remove inputs that disappeared
-		for url, in := range f.configuredInputs {
+		for url, ih := range f.configuredInputs {
 			if _, ok := checkDelete[url]; !ok {
-				in.Close()
+				f.closeInput(ih)
 				delete(f.configuredInputs, url)
 			}
 		}
 
-		// Add new inputs
+		// Add / reconfigure inputs
 		for _, ic := range c.Inputs {
-			if _, ok := f.configuredInputs[ic.URL]; !ok {
+			if ih, ok := f.configuredInputs[ic.URL]; !ok {
+				if err := f.setupInput(&ic); err != nil {
+					return err
+				}
+			} else if !reflect.DeepEqual(ih.conf, ic) {
+				f.closeInput(ih)
+				delete(f.configuredInputs, ic.URL)
 				if err := f.setupInput(&ic); err != nil {
 					return err
 				}
@@ -93,7 +101,18 @@ func (f *Flow) UpdateConfig(c *config.Flow) (err error) {
 		}
 	}
 
+	if !reflect.DeepEqual(c.Inputs, f.config.Inputs) ||
+		!reflect.DeepEqual(c.Failover, f.config.Failover) {
+		f.configureFailover(c)
+	}
+
+	if !reflect.DeepEqual(c.Analyzer, f.config.Analyzer) {
+		f.configureAnalyzer(c)
+	}
+
 	f.config.Inputs = c.Inputs
+	f.config.Failover = c.Failover
+	f.config.Analyzer = c.Analyzer
 
 	// If after input changes the configs are equal, we’re done
 	if reflect.DeepEqual(f.config, *c) {
@@ -0,0 +1,330 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+// Package file implements playback of transport stream files as input.
+package file
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/input"
+	"github.com/EmadHeravi/streamsow/input/normalizer"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/ts"
+	"github.com/rs/zerolog"
+)
+
+const (
+	packetsPerBlock = 7
+	// packets searched for a PCR when opening the file
+	pcrScanPackets = 10000
+	// PCR jumps larger than this are treated as discontinuity
+	maxPCRJump = ts.PCRClock
+)
+
+var errNoPCR = errors.New("no pid with two pcrs found in file, set a bitrate")
+
+type fileinput struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	logger  zerolog.Logger
+	path    string
+	loop    bool
+	bitrate int
+	pcrPID  uint16
+}
+
+// NewFileInput parses a file:// url, the file is paced by the PCRs of the
+// first PID carrying them unless a bitrate (bits/s) is given. With loop the
+// file is played repeatedly.
+func NewFileInput(ctx context.Context, u *url.URL, identifier string) (input.Input, error) {
+	q := u.Query()
+	in := &fileinput{path: filepath.Join(u.Host, u.Path)}
+	if in.path == "" || in.path == "." {
+		return nil, errors.New("file input requires a path")
+	}
+	var err error
+	if l := q.Get("loop"); l != "" {
+		if in.loop, err = strconv.ParseBool(l); err != nil {
+			return nil, fmt.Errorf("invalid loop %q", l)
+		}
+	}
+	if b := q.Get("bitrate"); b != "" {
+		if in.bitrate, err = strconv.Atoi(b); err != nil || in.bitrate <= 0 {
+			return nil, fmt.Errorf("invalid bitrate %q", b)
+		}
+	}
+	in.logger = logging.Log.With().
+		Str("module", "file-input").
+		Str("identifier", identifier).
+		Str("path", in.path).
+		Logger()
+	if in.bitrate == 0 {
+		if in.pcrPID, err = findPCRPID(in.path); err != nil {
+			return nil, err
+		}
+		in.logger.Info().Msgf("setting up file input %s, paced by pcr pid %d", in.path, in.pcrPID)
+	} else {
+		in.logger.Info().Msgf("setting up file input %s at %d bit/s", in.path, in.bitrate)
+	}
+	in.ctx, in.cancel = context.WithCancel(ctx)
+	return in, nil
+}
+
+// packetReader reads packets, skipping data until the next sync byte when
+// the stream isn't aligned.
+type packetReader struct {
+	r *bufio.Reader
+}
+
+func (p *packetReader) read(packet []byte) error {
+	for {
+		b, err := p.r.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] == ts.SyncByte {
+			break
+		}
+		p.r.Discard(1)
+	}
+	_, err := io.ReadFull(p.r, packet)
+	return err
+}
+
+func findPCRPID(path string) (uint16, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := packetReader{bufio.NewReader(f)}
+	packet := make([]byte, ts.PacketSize)
+	// the pacer needs two PCRs to know the rate
+	lastPCR := make(map[uint16]uint64)
+	for i := 0; i < pcrScanPackets; i++ {
+		if err := r.read(packet); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, err
+		}
+		pcr, ok := ts.PCR(packet)
+		if !ok {
+			continue
+		}
+		pid := ts.PID(packet)
+		if last, ok := lastPCR[pid]; ok && !ts.Discontinuity(packet) {
+			if delta := (pcr + ts.PCRWrap - last) % ts.PCRWrap; delta > 0 && delta <= maxPCRJump {
+				return pid, nil
+			}
+		}
+		lastPCR[pid] = pcr
+	}
+	return 0, errNoPCR
+}
+
+// pacer computes the send time of packets from the PCRs, between PCRs the
+// rate of the previous PCR interval is used.
+type pacer struct {
+	pcrPID        uint16
+	started       bool
+	discontinuity bool
+	lastTime      time.Time
+	lastPCR       uint64
+	lastPos       uint64
+	packetTime    time.Duration
+}
+
+// at returns the send time of the packet at pos.
+func (p *pacer) at(pos uint64) time.Time {
+	return p.lastTime.Add(time.Duration(pos-p.lastPos) * p.packetTime)
+}
+
+// packet updates the clock with the PCR of the packet at pos, it returns
+// true when the PCR is the first after a discontinuity.
+func (p *pacer) packet(packet []byte, pos uint64) bool {
+	if ts.PID(packet) != p.pcrPID {
+		return false
+	}
+	pcr, ok := ts.PCR(packet)
+	if !ok {
+		return false
+	}
+	if !p.started {
+		p.started = true
+		p.lastTime = time.Now()
+		p.lastPCR, p.lastPos = pcr, pos
+		return false
+	}
+	first := false
+	delta := (pcr + ts.PCRWrap - p.lastPCR) % ts.PCRWrap
+	if p.discontinuity || ts.Discontinuity(packet) || delta > maxPCRJump {
+		// continue at the current rate
+		p.lastTime = p.at(pos)
+		first = p.discontinuity
+		p.discontinuity = false
+	} else {
+		p.lastTime = p.lastTime.Add(time.Duration(delta) * time.Second / ts.PCRClock)
+		p.packetTime = time.Duration(delta) * time.Second / ts.PCRClock / time.Duration(pos-p.lastPos)
+	}
+	p.lastPCR, p.lastPos = pcr, pos
+	return first
+}
+
+// restamper keeps the continuity counters continuous over loops, within a
+// pass errors in the file are kept.
+type restamper struct {
+	last   map[uint16]uint8
+	offset map[uint16]uint8
+	rebase map[uint16]bool
+}
+
+func newRestamper() *restamper {
+	return &restamper{
+		last:   make(map[uint16]uint8),
+		offset: make(map[uint16]uint8),
+		rebase: make(map[uint16]bool),
+	}
+}
+
+// loop makes the first packets of the next pass continue the counters.
+func (r *restamper) loop() {
+	for pid := range r.last {
+		r.rebase[pid] = true
+	}
+}
+
+func (r *restamper) packet(p []byte) {
+	pid := ts.PID(p)
+	if pid == ts.NullPID {
+		return
+	}
+	cc := ts.ContinuityCounter(p)
+	if r.rebase[pid] && ts.HasPayload(p) {
+		r.offset[pid] = (r.last[pid] + 1 - cc) & 0x0f
+		delete(r.rebase, pid)
+	} else if r.rebase[pid] {
+		// packets without payload repeat the previous counter
+		r.offset[pid] = (r.last[pid] - cc) & 0x0f
+	}
+	cc = (cc + r.offset[pid]) & 0x0f
+	ts.SetContinuityCounter(p, cc)
+	r.last[pid] = cc
+}
+
+// setDiscontinuity sets the discontinuity_indicator of a packet with an
+// adaptation field.
+func setDiscontinuity(p []byte) {
+	if ts.HasAdaptationField(p) && p[4] > 0 {
+		p[5] |= 0x80
+	}
+}
+
+// StartReader starts playback into c until the input is closed or the end
+// of the file is reached without loop.
+func (in *fileinput) StartReader(c chan<- *libristwrapper.RistDataBlock) error {
+	f, err := os.Open(in.path)
+	if err != nil {
+		return err
+	}
+	go in.run(f, c)
+	return nil
+}
+
+func (in *fileinput) run(f *os.File, c chan<- *libristwrapper.RistDataBlock) {
+	defer f.Close()
+	var n normalizer.Normalizer
+	r := packetReader{bufio.NewReaderSize(f, 64*1024)}
+	p := &pacer{pcrPID: in.pcrPID}
+	if in.bitrate > 0 {
+		p.started = true
+		p.lastTime = time.Now()
+		p.packetTime = time.Duration(ts.PacketSize * 8 * float64(time.Second) / float64(in.bitrate))
+	}
+	cc := newRestamper()
+	buf := make([]byte, packetsPerBlock*ts.PacketSize)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var pos, passStart uint64
+	for {
+		count := 0
+		sendAt := p.at(pos)
+		for count < packetsPerBlock {
+			packet := buf[count*ts.PacketSize : (count+1)*ts.PacketSize]
+			err := r.read(packet)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				if !in.loop || pos == passStart {
+					break
+				}
+				if p.packetTime == 0 {
+					// every pass would be sent at once
+					in.logger.Error().Msg("file has no pcr interval to pace by, not looping")
+					break
+				}
+				passStart = pos
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					in.logger.Error().Err(err).Msg("couldn't rewind file")
+					break
+				}
+				r.r.Reset(f)
+				cc.loop()
+				p.discontinuity = true
+				in.logger.Debug().Msg("looping file")
+				continue
+			}
+			if err != nil {
+				in.logger.Error().Err(err).Msg("error reading file")
+				break
+			}
+			cc.packet(packet)
+			if in.bitrate == 0 && p.packet(packet, pos) {
+				setDiscontinuity(packet)
+			}
+			pos++
+			count++
+		}
+		if count > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(sendAt))
+			select {
+			case <-in.ctx.Done():
+				return
+			case <-timer.C:
+			}
+			rb := n.WrapToRist(buf[:count*ts.PacketSize])
+			select {
+			case c <- rb:
+			case <-in.ctx.Done():
+				rb.Return()
+				return
+			}
+		}
+		if count < packetsPerBlock {
+			in.logger.Info().Msg("end of file reached")
+			return
+		}
+	}
+}
+
+func (in *fileinput) Close() {
+	in.cancel()
+}
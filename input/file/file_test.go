@@ -0,0 +1,112 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/EmadHeravi/streamsow/ts"
+)
+
+// writeFile writes packets to a temporary file and returns its path.
+func writeFile(t *testing.T, packets ...[]byte) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "file-input-*.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, p := range packets {
+		if _, err := f.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f.Name()
+}
+
+func discontinuous(p []byte) []byte {
+	p[5] |= 0x80
+	return p
+}
+
+func TestFindPCRPID(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets [][]byte
+		want    uint16
+		err     error
+	}{
+		{"no pcr", [][]byte{ts.NullPacket()}, 0, errNoPCR},
+		{"one pcr", [][]byte{ts.PCRPacket(0x100, 0, 0)}, 0, errNoPCR},
+		{"pcrs on different pids", [][]byte{ts.PCRPacket(0x100, 0, 0), ts.PCRPacket(0x200, 27000, 0)}, 0, errNoPCR},
+		{"discontinuity", [][]byte{ts.PCRPacket(0x100, 0, 0), discontinuous(ts.PCRPacket(0x100, 27000, 0))}, 0, errNoPCR},
+		{"repeated pcr", [][]byte{ts.PCRPacket(0x100, 27000, 0), ts.PCRPacket(0x100, 27000, 0)}, 0, errNoPCR},
+		{"two pcrs", [][]byte{ts.PCRPacket(0x200, 0, 0), ts.PCRPacket(0x100, 0, 0), ts.NullPacket(), ts.PCRPacket(0x100, 27000, 0)}, 0x100, nil},
+		{"pcr wrap", [][]byte{ts.PCRPacket(0x100, ts.PCRWrap-13500, 0), ts.PCRPacket(0x100, 13500, 0)}, 0x100, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, tt.packets...)
+			defer os.Remove(path)
+			got, err := findPCRPID(path)
+			if err != tt.err || got != tt.want {
+				t.Fatalf("got pid %d, %v, want %d, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestOnePCRFile(t *testing.T) {
+	path := writeFile(t, ts.PCRPacket(0x100, 0, 0), ts.NullPacket(), ts.NullPacket())
+	defer os.Remove(path)
+	u, _ := url.Parse("file://" + path + "?loop=1")
+	if _, err := NewFileInput(context.Background(), u, "test"); err != errNoPCR {
+		t.Fatalf("one pcr file: %v, want %v", err, errNoPCR)
+	}
+	u, _ = url.Parse("file://" + path + "?loop=1&bitrate=1000000")
+	in, err := NewFileInput(context.Background(), u, "test")
+	if err != nil {
+		t.Fatalf("one pcr file with bitrate: %v", err)
+	}
+	in.Close()
+}
+
+func TestPacer(t *testing.T) {
+	p := &pacer{pcrPID: 0x100}
+	// 10ms over 10 packets
+	p.packet(ts.PCRPacket(0x100, 0, 0), 0)
+	start := p.lastTime
+	if p.packetTime != 0 {
+		t.Fatalf("packet time %s after the first pcr", p.packetTime)
+	}
+	p.packet(ts.PCRPacket(0x100, 270000, 0), 10)
+	if p.packetTime != time.Millisecond {
+		t.Fatalf("packet time %s, want 1ms", p.packetTime)
+	}
+	if got := p.at(15).Sub(start); got != 15*time.Millisecond {
+		t.Fatalf("packet 15 at %s, want 15ms", got)
+	}
+	// a jump keeps the rate
+	if p.packet(ts.PCRPacket(0x100, 270000+2*ts.PCRClock, 0), 20) {
+		t.Fatal("jump reported as first pcr after a loop")
+	}
+	if got := p.at(20).Sub(start); got != 20*time.Millisecond {
+		t.Fatalf("packet 20 at %s after a jump, want 20ms", got)
+	}
+	p.discontinuity = true
+	if !p.packet(ts.PCRPacket(0x100, 0, 0), 30) {
+		t.Fatal("first pcr after a loop not reported")
+	}
+	if got := p.at(30).Sub(start); got != 30*time.Millisecond {
+		t.Fatalf("packet 30 at %s after a loop, want 30ms", got)
+	}
+}
@@ -6,6 +6,15 @@
 
 package input
 
+import "code.videolan.org/rist/ristgo/libristwrapper"
+
 type Input interface {
 	Close()
 }
+
+// Reader is an input that doesn't feed the flow's RIST receiver but reads
+// blocks itself; it's started once the flow's mainloop exists.
+type Reader interface {
+	Input
+	StartReader(c chan<- *libristwrapper.RistDataBlock) error
+}
@@ -1,23 +1,68 @@
-package normalizer
-
 /*
-#cgo pkg-config: librist
-#include <librist/librist.h>
-#include <stdlib.h>
-*/
-import "C"
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package normalizer
 
 import (
-	"github.com/EmadHeravi/streamsow/include_srt/libristwrapper"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
 )
 
-// WrapToRist converts raw MPEG-TS data ([]byte) into a proper
-// RIST-compatible data block using libristwrapper.NewRistDataBlock().
-func WrapToRist(data []byte) *libristwrapper.RistDataBlock {
-	return libristwrapper.NewRistDataBlock(data)
+// ntpEpochOffset is the number of seconds between 1900-01-01 and 1970-01-01
+const ntpEpochOffset = 2208988800
+
+// Normalizer converts raw MPEG-TS data read by non-RIST inputs (UDP, SRT)
+// into RIST data blocks, numbering them like a RIST receiver would so the
+// mainloop discontinuity detection keeps working.
+type Normalizer struct {
+	seq uint16
+}
+
+// NTPTime returns t as a 64 bit NTP timestamp, the format RIST uses.
+func NTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix()) + ntpEpochOffset
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// WrapToRist copies data into a new RIST data block. Data is copied as
+// readers reuse their buffers.
+func (n *Normalizer) WrapToRist(data []byte) *libristwrapper.RistDataBlock {
+	if len(data) == 0 {
+		return nil
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	rb := &libristwrapper.RistDataBlock{
+		Data:      buf,
+		SeqNo:     uint32(n.seq),
+		TimeStamp: NTPTime(time.Now()),
+	}
+	n.seq++
+	return rb
+}
+
+// WrapRTP copies the payload of an RTP packet into a new RIST data block,
+// keeping the RTP sequence number so loss on the RTP stream itself is
+// detected by the mainloop.
+func WrapRTP(payload []byte, seq uint16) *libristwrapper.RistDataBlock {
+	if len(payload) == 0 {
+		return nil
+	}
+	buf := make([]byte, len(payload))
+	copy(buf, payload)
+	return &libristwrapper.RistDataBlock{
+		Data:      buf,
+		SeqNo:     uint32(seq),
+		TimeStamp: NTPTime(time.Now()),
+	}
 }
 
-// FreeRistBlock releases all underlying C allocations of a RIST data block.
+// FreeRistBlock releases a RIST data block.
 func FreeRistBlock(rb *libristwrapper.RistDataBlock) {
 	if rb == nil {
 		return
@@ -0,0 +1,285 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/input"
+	"github.com/EmadHeravi/streamsow/input/normalizer"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/sanitise"
+	srtwrap "github.com/EmadHeravi/streamsow/srt"
+	"github.com/EmadHeravi/streamsow/stats"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// SRT live mode payloads are at most 1456 bytes
+	readBufferSize = 1500
+	reconnectDelay = 1 * time.Second
+)
+
+func init() {
+	srtwrap.Init()
+}
+
+type srtinput struct {
+	ctx              context.Context
+	cancel           context.CancelFunc
+	logger           zerolog.Logger
+	url              *url.URL
+	sanitisedURL     *url.URL
+	identifier       string
+	input_identifier string
+	host             string
+	port             uint16
+	options          map[string]string
+	stats            *stats.Stats
+	// lock protects srt and client, the socket is closed by whoever clears
+	// it: the loop using it or Close
+	lock   sync.Mutex
+	srt    *srtwrap.Socket
+	client *srtwrap.Socket
+}
+
+// SetupSrtInput sets up an SRT input in caller or listener mode, the mode
+// follows the same rules as for SRT outputs. The socket is opened once the
+// reader is started.
+func SetupSrtInput(ctx context.Context, u *url.URL, identifier, input_identifier string, s *stats.Stats) (input.Input, error) {
+	var in srtinput
+	in.url = u
+	in.sanitisedURL = sanitise.URL(u)
+	logging.Log.Info().
+		Str("identifier", identifier).
+		Msgf("setting up srt input: %s", in.sanitisedURL)
+
+	in.host = u.Hostname()
+	if in.host == "" {
+		in.host = "0.0.0.0"
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	in.port = uint16(port)
+	in.options = make(map[string]string)
+	for key := range u.Query() {
+		in.options[key] = u.Query().Get(key)
+	}
+	delete(in.options, "identifier")
+	in.options["blocking"] = "0"
+
+	in.identifier = identifier
+	in.input_identifier = input_identifier
+	in.stats = s
+	in.logger = logging.Log.With().
+		Str("module", "srt-input").
+		Str("identifier", identifier).
+		Str("input_identifier", input_identifier).
+		Str("srt-url", in.sanitisedURL.String()).
+		Logger()
+	in.ctx, in.cancel = context.WithCancel(ctx)
+	return &in, nil
+}
+
+func (i *srtinput) newSocket() (*srtwrap.Socket, error) {
+	srtSocket, err := srtwrap.NewSocket(i.host, i.port, i.options)
+	if err != nil {
+		return nil, err
+	}
+	if srtSocket == nil {
+		return nil, errors.New("got nil srtSocket")
+	}
+	return srtSocket, nil
+}
+
+// StartReader opens the SRT socket and starts forwarding received data
+// into c.
+func (i *srtinput) StartReader(c chan<- *libristwrapper.RistDataBlock) error {
+	switch srtwrap.ModeOf(i.host, i.options) {
+	case srtwrap.ModeCaller:
+		go i.callerLoop(c)
+		return nil
+	case srtwrap.ModeFailure:
+		return fmt.Errorf("invalid srt mode %q", i.options["mode"])
+	}
+	srtSocket, err := i.newSocket()
+	if err != nil {
+		return err
+	}
+	if err := srtSocket.Listen(1); err != nil {
+		srtSocket.Close()
+		return err
+	}
+	if !i.publish(&i.srt, srtSocket) {
+		return nil
+	}
+	go i.listenAccept(srtSocket, c)
+	return nil
+}
+
+// publish stores s in slot unless the input is closed, in which case s is
+// closed and false is returned.
+func (i *srtinput) publish(slot **srtwrap.Socket, s *srtwrap.Socket) bool {
+	i.lock.Lock()
+	if i.ctx.Err() != nil {
+		i.lock.Unlock()
+		s.Close()
+		return false
+	}
+	*slot = s
+	i.lock.Unlock()
+	return true
+}
+
+// release closes s unless Close already took it out of slot.
+func (i *srtinput) release(slot **srtwrap.Socket, s *srtwrap.Socket) {
+	i.lock.Lock()
+	if *slot != s {
+		i.lock.Unlock()
+		return
+	}
+	*slot = nil
+	i.lock.Unlock()
+	s.Close()
+}
+
+func (i *srtinput) callerLoop(c chan<- *libristwrapper.RistDataBlock) {
+	for {
+		select {
+		case <-i.ctx.Done():
+			return
+		default:
+			//
+		}
+		srtSocket, err := i.newSocket()
+		if err == nil {
+			err = srtSocket.Connect()
+			if err != nil {
+				srtSocket.Close()
+			}
+		}
+		if err != nil {
+			i.logger.Debug().Err(err).Msg("SRT connect failed")
+			select {
+			case <-i.ctx.Done():
+				return
+			case <-time.After(reconnectDelay):
+			}
+			continue
+		}
+		if !i.publish(&i.srt, srtSocket) {
+			return
+		}
+		i.logger.Info().Msgf("SRT Connected to: %s", i.host)
+		i.readLoop(srtSocket, i.host, c)
+		i.release(&i.srt, srtSocket)
+	}
+}
+
+func (i *srtinput) listenAccept(listener *srtwrap.Socket, c chan<- *libristwrapper.RistDataBlock) {
+	for {
+		srtSocket, u, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-i.ctx.Done():
+			default:
+				i.logger.Error().Err(err).Msg("error in srtsocket listen")
+			}
+			return
+		}
+		host := u.IP.String()
+		i.lock.Lock()
+		if i.client != nil {
+			i.lock.Unlock()
+			i.logger.Warn().Str("client", host).Msgf("rejecting SRT client %s, input already has a sender", host)
+			srtSocket.Close()
+			continue
+		}
+		i.lock.Unlock()
+		if !i.publish(&i.client, srtSocket) {
+			return
+		}
+		i.logger.Info().Str("client", host).Msgf("SRT client %s connected", host)
+		go func() {
+			i.readLoop(srtSocket, host, c)
+			i.release(&i.client, srtSocket)
+			i.logger.Info().Str("client", host).Msgf("SRT client %s disconnected", host)
+		}()
+	}
+}
+
+func (i *srtinput) readLoop(srtSocket *srtwrap.Socket, host string, c chan<- *libristwrapper.RistDataBlock) {
+	var n normalizer.Normalizer
+	statsCtx, statsCancel := context.WithCancel(i.ctx)
+	defer statsCancel()
+	go i.statsLoop(statsCtx, srtSocket, host)
+
+	buf := make([]byte, readBufferSize)
+	for {
+		read, err := srtSocket.Read(buf)
+		if err != nil {
+			select {
+			case <-i.ctx.Done():
+			default:
+				i.logger.Error().Str("client", host).Err(err).Msg("error reading from SRT socket")
+			}
+			return
+		}
+		rb := n.WrapToRist(buf[:read])
+		if rb == nil {
+			continue
+		}
+		select {
+		case c <- rb:
+		case <-i.ctx.Done():
+			rb.Return()
+			return
+		}
+	}
+}
+
+func (i *srtinput) statsLoop(ctx context.Context, srtSocket *srtwrap.Socket, host string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(stats.StatsIntervalSeconds) * time.Second):
+		}
+		statsVal, err := srtSocket.Stats()
+		if err != nil {
+			if errors.Is(err, srtwrap.SRTErrno(srtwrap.ErrNoConn)) || errors.Is(err, srtwrap.SRTErrno(srtwrap.ErrInvSock)) {
+				return
+			}
+			i.logger.Error().Err(err).Msg("error in srt statsloop")
+			return
+		}
+		go i.stats.HandleStats(host, i.input_identifier, i.sanitisedURL, statsVal)
+	}
+}
+
+func (i *srtinput) Close() {
+	i.lock.Lock()
+	i.cancel()
+	client, srtSocket := i.client, i.srt
+	i.client, i.srt = nil, nil
+	i.lock.Unlock()
+	if client != nil {
+		client.Close()
+	}
+	if srtSocket != nil {
+		srtSocket.Close()
+	}
+}
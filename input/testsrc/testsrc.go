@@ -0,0 +1,238 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+// Package testsrc implements a synthetic MPEG-TS input for testing flows
+// without a live feed.
+package testsrc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/input"
+	"github.com/EmadHeravi/streamsow/input/normalizer"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/ts"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultBitrate     = 2000000
+	defaultPackets     = 7
+	defaultPCRInterval = 40 * time.Millisecond
+	defaultPSIInterval = 100 * time.Millisecond
+	defaultProgram     = 1
+	defaultPMTPID      = 0x1000
+	defaultPCRPID      = 0x0100
+)
+
+// testsrc generates a constant bitrate transport stream with a PAT, a PMT
+// and an adaptation field only PCR PID, the rest is null padding. Packets
+// are sent in blocks of the configured size paced at the bitrate, the PCR
+// follows the packet position so it's exact at that bitrate.
+type testsrc struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	logger      zerolog.Logger
+	bitrate     int
+	packets     int
+	pcrInterval time.Duration
+	psiInterval time.Duration
+	program     uint16
+	pmtPID      uint16
+	pcrPID      uint16
+
+	pat      []byte
+	pmt      []byte
+	patCC    uint8
+	pmtCC    uint8
+	position uint64
+	nextPSI  uint64
+	nextPCR  uint64
+}
+
+func parseDuration(q url.Values, key string, def time.Duration) (time.Duration, error) {
+	v := q.Get(key)
+	if v == "" {
+		return def, nil
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func parsePID(q url.Values, key string, def uint16) (uint16, error) {
+	v := q.Get(key)
+	if v == "" {
+		return def, nil
+	}
+	pid, err := strconv.ParseUint(v, 0, 16)
+	if err != nil || pid < 0x10 || pid >= uint64(ts.NullPID) {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return uint16(pid), nil
+}
+
+// NewTestSrcInput parses a testsrc:// url, params are bitrate (bits/s,
+// default 2000000), packets (TS packets per block, default 7), pcrinterval
+// and psiinterval (ms, default 40 and 100), program (default 1), pmtpid
+// (default 0x1000) and pcrpid (default 0x100).
+func NewTestSrcInput(ctx context.Context, u *url.URL, identifier string) (input.Input, error) {
+	q := u.Query()
+	t := &testsrc{
+		bitrate: defaultBitrate,
+		packets: defaultPackets,
+		program: defaultProgram,
+	}
+	var err error
+	if b := q.Get("bitrate"); b != "" {
+		if t.bitrate, err = strconv.Atoi(b); err != nil || t.bitrate <= 0 {
+			return nil, fmt.Errorf("invalid bitrate %q", b)
+		}
+	}
+	if p := q.Get("packets"); p != "" {
+		if t.packets, err = strconv.Atoi(p); err != nil || t.packets <= 0 || t.packets > 7 {
+			return nil, fmt.Errorf("invalid packets %q, must be 1-7", p)
+		}
+	}
+	if t.pcrInterval, err = parseDuration(q, "pcrinterval", defaultPCRInterval); err != nil {
+		return nil, err
+	}
+	if t.psiInterval, err = parseDuration(q, "psiinterval", defaultPSIInterval); err != nil {
+		return nil, err
+	}
+	if p := q.Get("program"); p != "" {
+		program, err := strconv.ParseUint(p, 0, 16)
+		if err != nil || program == 0 {
+			return nil, fmt.Errorf("invalid program %q", p)
+		}
+		t.program = uint16(program)
+	}
+	if t.pmtPID, err = parsePID(q, "pmtpid", defaultPMTPID); err != nil {
+		return nil, err
+	}
+	if t.pcrPID, err = parsePID(q, "pcrpid", defaultPCRPID); err != nil {
+		return nil, err
+	}
+	if t.pmtPID == t.pcrPID {
+		return nil, fmt.Errorf("pmtpid and pcrpid must differ")
+	}
+	// PAT, PMT and PCR may be due in the same PCR interval
+	if t.packetsIn(t.pcrInterval) < 3 {
+		return nil, fmt.Errorf("bitrate %d too low for a pcr interval of %s", t.bitrate, t.pcrInterval)
+	}
+
+	pat := ts.PAT{
+		SectionHeader: ts.SectionHeader{TableIDExtension: 1, CurrentNext: true},
+		Programs:      []ts.Program{{Number: t.program, PID: t.pmtPID}},
+	}
+	pmt := ts.PMT{
+		SectionHeader: ts.SectionHeader{TableIDExtension: t.program, CurrentNext: true},
+		PCRPID:        t.pcrPID,
+	}
+	t.pat = pat.Marshal()
+	t.pmt = pmt.Marshal()
+	// continuity counters start at 0 with the first packet
+	t.patCC, t.pmtCC = 0x0f, 0x0f
+
+	t.logger = logging.Log.With().
+		Str("module", "testsrc-input").
+		Str("identifier", identifier).
+		Str("url", u.String()).
+		Logger()
+	t.logger.Info().Msgf("setting up test source: %d bit/s, %d packets per block", t.bitrate, t.packets)
+	t.ctx, t.cancel = context.WithCancel(ctx)
+	return t, nil
+}
+
+// packetsIn returns the number of packets sent in d at the bitrate.
+func (t *testsrc) packetsIn(d time.Duration) uint64 {
+	return uint64(d.Seconds() * float64(t.bitrate) / (ts.PacketSize * 8))
+}
+
+// timeOf returns the time since the start at which a packet is sent.
+func (t *testsrc) timeOf(position uint64) time.Duration {
+	return time.Duration(float64(position) * ts.PacketSize * 8 / float64(t.bitrate) * float64(time.Second))
+}
+
+// pcrOf returns the PCR of a packet, derived from its position.
+func (t *testsrc) pcrOf(position uint64) uint64 {
+	return uint64(float64(position) * ts.PacketSize * 8 * ts.PCRClock / float64(t.bitrate))
+}
+
+// next returns the next packet, PSI and PCR are inserted when due, nulls
+// pad the remaining bitrate.
+func (t *testsrc) next(pending *[][]byte) []byte {
+	if len(*pending) == 0 {
+		switch {
+		case t.position >= t.nextPSI:
+			*pending = append(*pending, ts.SectionPackets(ts.PATPID, t.pat, &t.patCC)...)
+			*pending = append(*pending, ts.SectionPackets(t.pmtPID, t.pmt, &t.pmtCC)...)
+			t.nextPSI += t.packetsIn(t.psiInterval)
+		case t.position >= t.nextPCR:
+			*pending = append(*pending, ts.PCRPacket(t.pcrPID, t.pcrOf(t.position), 0))
+			t.nextPCR += t.packetsIn(t.pcrInterval)
+		}
+	}
+	var p []byte
+	if len(*pending) > 0 {
+		p = (*pending)[0]
+		*pending = (*pending)[1:]
+	} else {
+		p = ts.NullPacket()
+	}
+	t.position++
+	return p
+}
+
+// StartReader starts generating blocks into c until the input is closed.
+func (t *testsrc) StartReader(c chan<- *libristwrapper.RistDataBlock) error {
+	go t.run(c)
+	return nil
+}
+
+func (t *testsrc) run(c chan<- *libristwrapper.RistDataBlock) {
+	var (
+		n       normalizer.Normalizer
+		pending [][]byte
+	)
+	buf := make([]byte, 0, t.packets*ts.PacketSize)
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-timer.C:
+		}
+		// catch up on blocks due since the last wakeup
+		for time.Since(start) >= t.timeOf(t.position) {
+			buf = buf[:0]
+			for i := 0; i < t.packets; i++ {
+				buf = append(buf, t.next(&pending)...)
+			}
+			rb := n.WrapToRist(buf)
+			select {
+			case c <- rb:
+			case <-t.ctx.Done():
+				rb.Return()
+				return
+			}
+		}
+		timer.Reset(time.Until(start.Add(t.timeOf(t.position))))
+	}
+}
+
+func (t *testsrc) Close() {
+	t.cancel()
+}
@@ -0,0 +1,115 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package testsrc
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/input"
+	"github.com/EmadHeravi/streamsow/ts"
+)
+
+func TestTestSrc(t *testing.T) {
+	const (
+		bitrate = 2000000
+		// read a second of stream
+		want = bitrate / 8
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	u, _ := url.Parse("testsrc://?bitrate=2000000&pcrpid=0x101&pmtpid=0x1001&program=3")
+	in, err := NewTestSrcInput(ctx, u, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	c := make(chan *libristwrapper.RistDataBlock, 16)
+	if err := in.(input.Reader).StartReader(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		data  []byte
+		first time.Time
+	)
+	deadline := time.After(5 * time.Second)
+	for len(data) < want {
+		select {
+		case rb := <-c:
+			if first.IsZero() {
+				first = time.Now()
+			}
+			data = append(data, rb.Data...)
+			rb.Return()
+		case <-deadline:
+			t.Fatalf("read %d bytes, want %d", len(data), want)
+		}
+	}
+	elapsed := time.Since(first)
+
+	if len(data)%ts.PacketSize != 0 {
+		t.Fatalf("read %d bytes, not a multiple of the packet size", len(data))
+	}
+	var (
+		patAsm, pmtAsm ts.SectionAssembler
+		pat            *ts.PAT
+		pmt            *ts.PMT
+		pcrs           []uint64
+		pcrPositions   []int
+	)
+	for i := 0; i < len(data); i += ts.PacketSize {
+		p := data[i : i+ts.PacketSize]
+		if p[0] != ts.SyncByte {
+			t.Fatalf("lost sync at packet %d", i/ts.PacketSize)
+		}
+		switch ts.PID(p) {
+		case ts.PATPID:
+			for _, s := range patAsm.Push(p) {
+				if pat, err = ts.ParsePAT(s); err != nil {
+					t.Fatalf("parsing pat: %v", err)
+				}
+			}
+		case 0x1001:
+			for _, s := range pmtAsm.Push(p) {
+				if pmt, err = ts.ParsePMT(s); err != nil {
+					t.Fatalf("parsing pmt: %v", err)
+				}
+			}
+		case 0x101:
+			if pcr, ok := ts.PCR(p); ok {
+				pcrs = append(pcrs, pcr)
+				pcrPositions = append(pcrPositions, i)
+			}
+		}
+	}
+	if pat == nil || len(pat.Programs) != 1 || pat.Programs[0] != (ts.Program{Number: 3, PID: 0x1001}) {
+		t.Fatalf("pat %+v, want program 3 on pid 0x1001", pat)
+	}
+	if pmt == nil || pmt.TableIDExtension != 3 || pmt.PCRPID != 0x101 {
+		t.Fatalf("pmt %+v, want program 3 with pcr pid 0x101", pmt)
+	}
+	// a PCR every 40ms
+	if len(pcrs) < 20 {
+		t.Fatalf("got %d pcrs in a second, want at least 20", len(pcrs))
+	}
+
+	// the PCRs give the exact bitrate, the capture time roughly the same
+	last := len(pcrs) - 1
+	pcrDelta := float64(pcrs[last]-pcrs[0]) / ts.PCRClock
+	pcrBitrate := float64(pcrPositions[last]-pcrPositions[0]) * 8 / pcrDelta
+	if pcrBitrate < bitrate*0.999 || pcrBitrate > bitrate*1.001 {
+		t.Errorf("pcr bitrate %.0f, want %d", pcrBitrate, bitrate)
+	}
+	wallBitrate := float64(len(data)) * 8 / elapsed.Seconds()
+	if wallBitrate < bitrate*0.75 || wallBitrate > bitrate*1.25 {
+		t.Errorf("bitrate %.0f over %s, want about %d", wallBitrate, elapsed, bitrate)
+	}
+}
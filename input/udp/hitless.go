@@ -0,0 +1,294 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/input"
+	"github.com/EmadHeravi/streamsow/input/udp/udpstats"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/stats"
+	"github.com/rs/zerolog"
+)
+
+const (
+	hitlessLegs = 2
+	// a jump in sequence numbers bigger than this is treated as a
+	// sender restart instead of loss
+	maxSeqJump    = 8192
+	flushInterval = 5 * time.Millisecond
+)
+
+type pending struct {
+	rb      *libristwrapper.RistDataBlock
+	arrival time.Time
+	legs    uint8
+}
+
+// legInput is returned for merge legs, it doesn't implement input.Reader
+// as legs are started by the merger.
+type legInput struct {
+	leg *UdpInput
+}
+
+func (l *legInput) Close() {
+	l.leg.Close()
+}
+
+type delivered struct {
+	t      time.Time
+	legs   uint8
+	output bool
+}
+
+// Merger implements SMPTE 2022-7 style hitless merging of two RTP legs.
+// Packets are de-duplicated by RTP sequence number, a packet missing on
+// both legs is waited for at most maxSkew before it's declared lost.
+type Merger struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	logger     zerolog.Logger
+	identifier string
+	maxSkew    time.Duration
+	legs       []*UdpInput
+	stats      *stats.Stats
+	statsLock  sync.Mutex
+	counters   udpstats.HitlessStats
+
+	started bool
+	next    uint16
+	buffer  map[uint16]*pending
+	history map[uint16]*delivered
+}
+
+// NewMerger creates a merger, legs are added via AddLeg.
+func NewMerger(parentCtx context.Context, identifier string, maxSkew time.Duration, s *stats.Stats) *Merger {
+	ctx, cancel := context.WithCancel(parentCtx)
+	logging.Log.Info().
+		Str("identifier", identifier).
+		Dur("maxskew", maxSkew).
+		Msg("setting up hitless rtp merge")
+	return &Merger{
+		ctx:        ctx,
+		cancel:     cancel,
+		logger:     logging.Log.With().Str("module", "udp-hitless").Str("identifier", identifier).Logger(),
+		identifier: identifier,
+		maxSkew:    maxSkew,
+		stats:      s,
+		buffer:     make(map[uint16]*pending),
+		history:    make(map[uint16]*delivered),
+	}
+}
+
+// AddLeg sets up an rtp:// input as leg of the merge. The returned input
+// is only used to stop the leg, it's started by the merger.
+func (m *Merger) AddLeg(u *url.URL, identifier string) (input.Input, error) {
+	if len(m.legs) == hitlessLegs {
+		return nil, errors.New("hitless merge supports exactly two legs")
+	}
+	if u.Scheme != "rtp" {
+		return nil, errors.New("hitless merge legs must be rtp inputs")
+	}
+	in, err := NewUdpInput(m.ctx, u, identifier, m.stats)
+	if err != nil {
+		return nil, err
+	}
+	leg := in.(*UdpInput)
+	m.legs = append(m.legs, leg)
+	return &legInput{leg}, nil
+}
+
+// StartReader starts both legs and forwards the merged stream into c.
+func (m *Merger) StartReader(c chan<- *libristwrapper.RistDataBlock) error {
+	if len(m.legs) != hitlessLegs {
+		return errors.New("hitless merge needs two rtp inputs")
+	}
+	legChans := make([]chan *libristwrapper.RistDataBlock, hitlessLegs)
+	for i, leg := range m.legs {
+		legChans[i] = make(chan *libristwrapper.RistDataBlock, 256)
+		if err := leg.StartReader(legChans[i]); err != nil {
+			m.cancel()
+			return err
+		}
+	}
+	go m.mergeLoop(legChans, c)
+	return nil
+}
+
+// Stats returns the merge counters.
+func (m *Merger) Stats() udpstats.HitlessStats {
+	m.statsLock.Lock()
+	defer m.statsLock.Unlock()
+	return m.counters
+}
+
+func (m *Merger) Close() {
+	m.cancel()
+	for _, leg := range m.legs {
+		leg.Close()
+	}
+}
+
+func (m *Merger) mergeLoop(legChans []chan *libristwrapper.RistDataBlock, c chan<- *libristwrapper.RistDataBlock) {
+	flushTicker := time.NewTicker(flushInterval)
+	defer flushTicker.Stop()
+	statsTicker := time.NewTicker(time.Duration(stats.StatsIntervalSeconds) * time.Second)
+	defer statsTicker.Stop()
+	var out []*libristwrapper.RistDataBlock
+
+	for {
+		out = out[:0]
+		select {
+		case <-m.ctx.Done():
+			for _, p := range m.buffer {
+				p.rb.Return()
+			}
+			return
+		case rb := <-legChans[0]:
+			out = m.push(0, rb, time.Now(), out)
+		case rb := <-legChans[1]:
+			out = m.push(1, rb, time.Now(), out)
+		case now := <-flushTicker.C:
+			out = m.flush(now, out)
+			m.age(now, false)
+		case <-statsTicker.C:
+			st := m.Stats()
+			go m.stats.HandleStats("", m.identifier, nil, &st)
+		}
+		if len(out) > 0 {
+			m.statsLock.Lock()
+			m.counters.Merged += len(out)
+			m.statsLock.Unlock()
+		}
+		for i, rb := range out {
+			select {
+			case c <- rb:
+			case <-m.ctx.Done():
+				for _, rb := range out[i:] {
+					rb.Return()
+				}
+				return
+			}
+		}
+	}
+}
+
+// push adds a packet received on leg to the merge, appending packets that
+// are ready to out.
+func (m *Merger) push(leg int, rb *libristwrapper.RistDataBlock, now time.Time, out []*libristwrapper.RistDataBlock) []*libristwrapper.RistDataBlock {
+	seq := uint16(rb.SeqNo)
+	m.statsLock.Lock()
+	if leg == 0 {
+		m.counters.Leg1Packets++
+	} else {
+		m.counters.Leg2Packets++
+	}
+	m.statsLock.Unlock()
+
+	if !m.started {
+		m.started = true
+		m.next = seq
+	}
+	if d, ok := m.history[seq]; ok {
+		d.legs |= 1 << leg
+		rb.Return()
+		return out
+	}
+	if p, ok := m.buffer[seq]; ok {
+		p.legs |= 1 << leg
+		rb.Return()
+		return out
+	}
+	diff := int16(seq - m.next)
+	// a packet at most maxMisorder back is late, further back is a sender
+	// restart
+	if diff < 0 && -int(diff) <= maxMisorder {
+		// too late, already declared lost
+		rb.Return()
+		return out
+	}
+	if diff < 0 || int(diff) > maxSeqJump {
+		m.logger.Warn().Msgf("rtp sequence jumped from %d to %d, resyncing", m.next, seq)
+		out = m.drain(now, out)
+		m.age(now, true)
+		m.next = seq
+	}
+	m.buffer[seq] = &pending{rb, now, 1 << leg}
+	return m.flush(now, out)
+}
+
+// flush outputs all in order packets, skipping missing packets once the
+// oldest buffered packet waited longer than maxSkew.
+func (m *Merger) flush(now time.Time, out []*libristwrapper.RistDataBlock) []*libristwrapper.RistDataBlock {
+	for len(m.buffer) > 0 {
+		if p, ok := m.buffer[m.next]; ok {
+			out = append(out, p.rb)
+			m.history[m.next] = &delivered{now, p.legs, true}
+			delete(m.buffer, m.next)
+			m.next++
+			continue
+		}
+		oldest := now
+		for _, p := range m.buffer {
+			if p.arrival.Before(oldest) {
+				oldest = p.arrival
+			}
+		}
+		if now.Sub(oldest) < m.maxSkew {
+			break
+		}
+		m.history[m.next] = &delivered{now, 0, false}
+		m.next++
+	}
+	return out
+}
+
+// drain outputs all buffered packets in order, used on resync.
+func (m *Merger) drain(now time.Time, out []*libristwrapper.RistDataBlock) []*libristwrapper.RistDataBlock {
+	for len(m.buffer) > 0 {
+		if p, ok := m.buffer[m.next]; ok {
+			out = append(out, p.rb)
+			m.history[m.next] = &delivered{now, p.legs, true}
+			delete(m.buffer, m.next)
+		}
+		m.next++
+	}
+	return out
+}
+
+// age finalizes the loss accounting of packets older than twice the
+// skew window, late duplicates are no longer expected for those. With all
+// the whole history is finalized, used on resync as the new sequence
+// numbers may overlap it.
+func (m *Merger) age(now time.Time, all bool) {
+	m.statsLock.Lock()
+	defer m.statsLock.Unlock()
+	for seq, d := range m.history {
+		if !all && now.Sub(d.t) < 2*m.maxSkew {
+			continue
+		}
+		delete(m.history, seq)
+		if d.legs&1 == 0 {
+			m.counters.Leg1Lost++
+		}
+		if d.legs&2 == 0 {
+			m.counters.Leg2Lost++
+		}
+		switch {
+		case !d.output:
+			m.counters.Lost++
+		case d.legs != 3:
+			m.counters.Recovered++
+		}
+	}
+}
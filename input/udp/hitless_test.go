@@ -0,0 +1,224 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/input/udp/udpstats"
+)
+
+const testSkew = 10 * time.Millisecond
+
+// mergeTest feeds a merger directly, without legs and merge loop.
+type mergeTest struct {
+	m     *Merger
+	start time.Time
+	out   []uint16
+}
+
+func newMergeTest() *mergeTest {
+	return &mergeTest{m: NewMerger(context.Background(), "test", testSkew, nil), start: time.Now()}
+}
+
+func (mt *mergeTest) collect(out []*libristwrapper.RistDataBlock) {
+	for _, rb := range out {
+		mt.out = append(mt.out, uint16(rb.SeqNo))
+	}
+}
+
+// push pushes seqs on leg at the given time after the start.
+func (mt *mergeTest) push(leg int, at time.Duration, seqs ...uint16) {
+	for _, seq := range seqs {
+		rb := &libristwrapper.RistDataBlock{Data: []byte{byte(seq)}, SeqNo: uint32(seq)}
+		mt.collect(mt.m.push(leg, rb, mt.start.Add(at), nil))
+	}
+}
+
+// both pushes seqs on both legs, each on leg 1 first.
+func (mt *mergeTest) both(at time.Duration, seqs ...uint16) {
+	for _, seq := range seqs {
+		mt.push(0, at, seq)
+		mt.push(1, at, seq)
+	}
+}
+
+func (mt *mergeTest) flush(at time.Duration) {
+	mt.collect(mt.m.flush(mt.start.Add(at), nil))
+}
+
+// stats finalizes the loss accounting and returns the counters.
+func (mt *mergeTest) stats() udpstats.HitlessStats {
+	mt.m.age(mt.start.Add(time.Hour), false)
+	return mt.m.Stats()
+}
+
+func seqRange(from uint16, n int) []uint16 {
+	seqs := make([]uint16, n)
+	for i := range seqs {
+		seqs[i] = from + uint16(i)
+	}
+	return seqs
+}
+
+func without(seqs []uint16, skip uint16) []uint16 {
+	var out []uint16
+	for _, seq := range seqs {
+		if seq != skip {
+			out = append(out, seq)
+		}
+	}
+	return out
+}
+
+func TestMerger(t *testing.T) {
+	all := seqRange(0, 10)
+	tests := []struct {
+		name  string
+		run   func(mt *mergeTest)
+		out   []uint16
+		stats udpstats.HitlessStats
+	}{
+		{
+			name: "duplicates on both legs",
+			run:  func(mt *mergeTest) { mt.both(0, all...) },
+			out:  all,
+			stats: udpstats.HitlessStats{
+				Leg1Packets: 10, Leg2Packets: 10,
+			},
+		},
+		{
+			name: "loss on one leg",
+			run: func(mt *mergeTest) {
+				for _, seq := range all {
+					if seq != 3 {
+						mt.push(0, 0, seq)
+					}
+					mt.push(1, 0, seq)
+				}
+			},
+			out: all,
+			stats: udpstats.HitlessStats{
+				Recovered:   1,
+				Leg1Packets: 9, Leg1Lost: 1, Leg2Packets: 10,
+			},
+		},
+		{
+			name: "loss on both legs",
+			run: func(mt *mergeTest) {
+				mt.both(0, without(all, 3)...)
+				mt.flush(testSkew)
+			},
+			out: without(all, 3),
+			stats: udpstats.HitlessStats{
+				Lost:        1,
+				Leg1Packets: 9, Leg1Lost: 1, Leg2Packets: 9, Leg2Lost: 1,
+			},
+		},
+		{
+			name: "loss waits for the skew window",
+			run: func(mt *mergeTest) {
+				mt.push(0, 0, without(all, 3)...)
+				mt.flush(testSkew / 2)
+				mt.push(1, testSkew/2, all...)
+			},
+			out: all,
+			stats: udpstats.HitlessStats{
+				Recovered:   1,
+				Leg1Packets: 9, Leg1Lost: 1, Leg2Packets: 10,
+			},
+		},
+		{
+			name: "skew beyond the window",
+			run: func(mt *mergeTest) {
+				mt.push(0, 0, without(all, 3)...)
+				mt.flush(testSkew)
+				// too late, the packet was declared lost
+				mt.push(1, 2*testSkew, all...)
+			},
+			out: without(all, 3),
+			stats: udpstats.HitlessStats{
+				Lost:        1,
+				Leg1Packets: 9, Leg1Lost: 1, Leg2Packets: 10,
+			},
+		},
+		{
+			name: "sequence wrap",
+			run:  func(mt *mergeTest) { mt.both(0, seqRange(65530, 12)...) },
+			out:  seqRange(65530, 12),
+			stats: udpstats.HitlessStats{
+				Leg1Packets: 12, Leg2Packets: 12,
+			},
+		},
+		{
+			name: "loss across the wrap",
+			run: func(mt *mergeTest) {
+				mt.both(0, without(seqRange(65530, 12), 0)...)
+				mt.flush(testSkew)
+			},
+			out: without(seqRange(65530, 12), 0),
+			stats: udpstats.HitlessStats{
+				Lost:        1,
+				Leg1Packets: 11, Leg1Lost: 1, Leg2Packets: 11, Leg2Lost: 1,
+			},
+		},
+		{
+			name: "backward jump resyncs",
+			run: func(mt *mergeTest) {
+				mt.both(0, seqRange(1000, 5)...)
+				mt.both(0, seqRange(500, 5)...)
+			},
+			out: append(seqRange(1000, 5), seqRange(500, 5)...),
+			stats: udpstats.HitlessStats{
+				Leg1Packets: 10, Leg2Packets: 10,
+			},
+		},
+		{
+			name: "forward jump resyncs",
+			run: func(mt *mergeTest) {
+				mt.both(0, seqRange(1000, 5)...)
+				mt.both(0, seqRange(1005+maxSeqJump, 5)...)
+			},
+			out: append(seqRange(1000, 5), seqRange(1005+maxSeqJump, 5)...),
+			stats: udpstats.HitlessStats{
+				Leg1Packets: 10, Leg2Packets: 10,
+			},
+		},
+		{
+			name: "late packet after the history is dropped",
+			run: func(mt *mergeTest) {
+				mt.both(0, seqRange(1000, 5)...)
+				mt.m.age(mt.start.Add(2*testSkew), false)
+				mt.push(1, 2*testSkew, 1002)
+				mt.both(2*testSkew, 1005)
+			},
+			out: seqRange(1000, 6),
+			stats: udpstats.HitlessStats{
+				Leg1Packets: 6, Leg2Packets: 7,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mt := newMergeTest()
+			tt.run(mt)
+			if !reflect.DeepEqual(mt.out, tt.out) {
+				t.Fatalf("output %v, want %v", mt.out, tt.out)
+			}
+			if st := mt.stats(); st != tt.stats {
+				t.Fatalf("stats %+v, want %+v", st, tt.stats)
+			}
+			if len(mt.m.buffer) != 0 || len(mt.m.history) != 0 {
+				t.Fatalf("%d packets buffered and %d in history after the test", len(mt.m.buffer), len(mt.m.history))
+			}
+		})
+	}
+}
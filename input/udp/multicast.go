@@ -0,0 +1,205 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketParams are the url params handled when opening the socket:
+// iface (interface name or address to join multicast groups on), source
+// (sender address for source specific multicast) and rcvbuf (socket
+// receive buffer size in bytes).
+type socketParams struct {
+	iface  string
+	source net.IP
+	rcvbuf int
+}
+
+func parseSocketParams(u *url.URL) (*socketParams, error) {
+	q := u.Query()
+	p := &socketParams{iface: q.Get("iface")}
+	if s := q.Get("source"); s != "" {
+		if p.source = net.ParseIP(s).To4(); p.source == nil {
+			return nil, fmt.Errorf("invalid ssm source address %q", s)
+		}
+	}
+	if b := q.Get("rcvbuf"); b != "" {
+		var err error
+		if p.rcvbuf, err = strconv.Atoi(b); err != nil || p.rcvbuf <= 0 {
+			return nil, fmt.Errorf("invalid rcvbuf %q", b)
+		}
+	}
+	return p, nil
+}
+
+// interfaceAddr returns the interface and IPv4 address iface refers to,
+// either an interface name or one of its addresses.
+func interfaceAddr(iface string) (*net.Interface, net.IP, error) {
+	if ip := net.ParseIP(iface); ip != nil {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range ifaces {
+			addrs, err := ifaces[i].Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+					return &ifaces[i], ip.To4(), nil
+				}
+			}
+		}
+		return nil, nil, fmt.Errorf("no interface with address %s", iface)
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ifi, ipnet.IP.To4(), nil
+		}
+	}
+	return ifi, nil, nil
+}
+
+// joinGroup joins the multicast group on the configured interface, with a
+// source configured an IGMPv3 source specific join is done.
+func joinGroup(sc syscall.RawConn, group net.IP, p *socketParams) error {
+	var (
+		ifi    *net.Interface
+		ifaddr net.IP
+		err    error
+	)
+	if p.iface != "" {
+		if ifi, ifaddr, err = interfaceAddr(p.iface); err != nil {
+			return err
+		}
+	}
+	var joinErr error
+	if group4 := group.To4(); group4 != nil {
+		if p.source != nil && ifi != nil && ifaddr == nil {
+			// ip_mreq_source selects the interface by address only, joining
+			// on 0.0.0.0 would pick the default route's interface
+			return fmt.Errorf("interface %s has no IPv4 address for source specific multicast", ifi.Name)
+		}
+		if ifaddr == nil {
+			ifaddr = net.IPv4zero.To4()
+		}
+		err = sc.Control(func(fd uintptr) {
+			if p.source != nil {
+				// struct ip_mreq_source: group, interface, source
+				mreq := make([]byte, 0, 12)
+				mreq = append(mreq, group4...)
+				mreq = append(mreq, ifaddr...)
+				mreq = append(mreq, p.source...)
+				joinErr = unix.SetsockoptString(int(fd), unix.IPPROTO_IP, unix.IP_ADD_SOURCE_MEMBERSHIP, string(mreq))
+				return
+			}
+			mreq := &unix.IPMreqn{}
+			copy(mreq.Multiaddr[:], group4)
+			copy(mreq.Address[:], ifaddr)
+			if ifi != nil {
+				mreq.Ifindex = int32(ifi.Index)
+			}
+			joinErr = unix.SetsockoptIPMreqn(int(fd), unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, mreq)
+		})
+	} else {
+		if p.source != nil {
+			return errors.New("source specific multicast is only supported for IPv4 groups")
+		}
+		err = sc.Control(func(fd uintptr) {
+			mreq := &unix.IPv6Mreq{}
+			copy(mreq.Multiaddr[:], group.To16())
+			if ifi != nil {
+				mreq.Interface = uint32(ifi.Index)
+			}
+			joinErr = unix.SetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, mreq)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return joinErr
+}
+
+// listen opens the socket of the input with SO_REUSEADDR set, so multiple
+// inputs may receive the same group, and joins the group of multicast
+// addresses.
+func listen(ctx context.Context, addr *net.UDPAddr, p *socketParams) (*net.UDPConn, error) {
+	if p.source != nil && !addr.IP.IsMulticast() {
+		return nil, errors.New("source requires a multicast group address")
+	}
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	pc, err := lc.ListenPacket(ctx, "udp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	conn := pc.(*net.UDPConn)
+	if p.rcvbuf > 0 {
+		if err := conn.SetReadBuffer(p.rcvbuf); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if addr.IP.IsMulticast() {
+		sc, err := conn.SyscallConn()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := joinGroup(sc, addr.IP, p); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("couldn't join multicast group %s: %w", addr.IP, err)
+		}
+	}
+	return conn, nil
+}
+
+// readBuffer returns the receive buffer size of the socket, the kernel
+// limits it to net.core.rmem_max.
+func readBuffer(conn *net.UDPConn) (int, error) {
+	sc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var (
+		size   int
+		optErr error
+	)
+	if err := sc.Control(func(fd uintptr) {
+		size, optErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	}); err != nil {
+		return 0, err
+	}
+	return size, optErr
+}
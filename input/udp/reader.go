@@ -9,14 +9,15 @@ import (
 	"net"
 	"time"
 
-	"github.com/EmadHeravi/streamsow/include_srt/libristwrapper"
+	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/EmadHeravi/streamsow/input/normalizer"
 	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/stats"
 )
 
 // StartReader starts the UDP socket listener and forwards
-// packets into the unified RIST flow using a local RIST sender.
-func (i *UdpInput) StartReader() error {
+// packets into the flow's mainloop input channel.
+func (i *UdpInput) StartReader(c chan<- *libristwrapper.RistDataBlock) error {
 	logger := logging.Log.With().
 		Str("module", "udp-reader").
 		Str("identifier", i.identifier).
@@ -30,26 +31,39 @@ func (i *UdpInput) StartReader() error {
 	}
 
 	// ----------- Listen ----------------------
-	conn, err := net.ListenUDP("udp", udpAddr)
+	conn, err := listen(i.ctx, udpAddr, i.params)
 	if err != nil {
 		logger.Error().Err(err).Msg("failed to open UDP socket")
 		return err
 	}
+	if i.params.rcvbuf > 0 {
+		// linux doubles the requested size for bookkeeping overhead
+		if size, err := readBuffer(conn); err == nil && size/2 < i.params.rcvbuf {
+			logger.Warn().Msgf("UDP receive buffer limited to %d bytes instead of %d, raise net.core.rmem_max", size/2, i.params.rcvbuf)
+		}
+	}
 
+	if udpAddr.IP.IsMulticast() {
+		ev := logger.Info()
+		if i.params.iface != "" {
+			ev = ev.Str("iface", i.params.iface)
+		}
+		if i.params.source != nil {
+			ev = ev.Str("source", i.params.source.String())
+		}
+		ev.Msgf("UDP joined multicast group %s", udpAddr.IP)
+	}
 	logger.Info().Msgf("UDP listening on %s", i.url.Host)
 
-	// ----------- Initialize RIST Sender ------------
-	sender := libristwrapper.InitSender(0) // profile 0 = simple main profile
-	defer sender.Free()
-
-	// Optionally configure the local receiver address if needed
-	// (e.g., "udp://127.0.0.1:9000" where your RIST receiver flow listens)
-	sender.SetOutputIP("udp://127.0.0.1:9000")
+	if i.stats != nil {
+		go i.statsLoop()
+	}
 
 	// ----------- Reader Loop -----------------
 	go func() {
 		defer conn.Close()
 
+		var n normalizer.Normalizer
 		buf := make([]byte, 2048) // MPEG-TS fits in 1316 but 2k safer
 		for {
 			select {
@@ -60,27 +74,42 @@ func (i *UdpInput) StartReader() error {
 			default:
 				// Non-blocking read with timeout
 				conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-				n, _, err := conn.ReadFromUDP(buf)
+				read, src, err := conn.ReadFromUDP(buf)
 				if err != nil {
 					if ne, ok := err.(net.Error); ok && ne.Timeout() {
 						continue // timeout → retry
 					}
+					i.counters.readError()
 					logger.Error().Err(err).Msg("UDP read error")
 					continue
 				}
+				now := time.Now()
+				if i.counters.received(read, src) {
+					logger.Info().Str("source", src.String()).Msgf("UDP source changed to %s", src)
+				}
 
 				// Wrap UDP data into a RIST-compatible block
-				rb := normalizer.WrapToRist(buf[:n])
+				var rb *libristwrapper.RistDataBlock
+				if i.isRtp {
+					payload, seq, err := rtpPayload(buf[:read])
+					if err != nil {
+						logger.Debug().Err(err).Msg("dropping packet")
+						continue
+					}
+					i.counters.rtp(seq, rtpTimestamp(buf[:read]), now)
+					rb = normalizer.WrapRTP(payload, seq)
+				} else {
+					i.counters.udpTiming(now)
+					rb = n.WrapToRist(buf[:read])
+				}
 				if rb == nil {
 					continue
 				}
 
-				// Send to RIST flow (loopback or configured peer)
-				ret := sender.SendData(rb)
-				rb.Return()
-
-				if ret != 0 {
-					logger.Warn().Msg("RIST send returned non-zero (dropped or error)")
+				select {
+				case c <- rb:
+				case <-i.ctx.Done():
+					rb.Return()
 				}
 			}
 		}
@@ -88,3 +117,17 @@ func (i *UdpInput) StartReader() error {
 
 	return nil
 }
+
+// statsLoop reports the input counters until the input is closed.
+func (i *UdpInput) statsLoop() {
+	ticker := time.NewTicker(time.Duration(stats.StatsIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.ctx.Done():
+			return
+		case <-ticker.C:
+			go i.stats.HandleStats("", i.identifier, i.url, i.counters.stats())
+		}
+	}
+}
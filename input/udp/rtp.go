@@ -0,0 +1,44 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const rtpHeaderSize = 12
+
+var errInvalidRTP = errors.New("invalid rtp packet")
+
+// rtpTimestamp returns the timestamp of a packet validated by rtpPayload.
+func rtpTimestamp(b []byte) uint32 {
+	return binary.BigEndian.Uint32(b[4:8])
+}
+
+// rtpPayload returns the payload and sequence number of an RTP packet.
+func rtpPayload(b []byte) ([]byte, uint16, error) {
+	if len(b) < rtpHeaderSize || b[0]>>6 != 2 {
+		return nil, 0, errInvalidRTP
+	}
+	seq := binary.BigEndian.Uint16(b[2:4])
+	offset := rtpHeaderSize + 4*int(b[0]&0x0f)
+	if b[0]&0x10 != 0 {
+		if len(b) < offset+4 {
+			return nil, 0, errInvalidRTP
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(b[offset+2:offset+4]))
+	}
+	end := len(b)
+	if b[0]&0x20 != 0 {
+		end -= int(b[end-1])
+	}
+	if offset > end {
+		return nil, 0, errInvalidRTP
+	}
+	return b[offset:end], seq, nil
+}
@@ -6,5 +6,143 @@
 
 package udp
 
-// In later steps, this file will contain optional UDP input statistics
-// (packets/bytes received, timestamps, etc.) integrated with the stats module.
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/EmadHeravi/streamsow/input/udp/udpstats"
+)
+
+// rtp clock rate of MPEG-TS payloads
+const (
+	rtpClockRate = 90000
+	// packets further back than this are a sender restart instead of
+	// reordering, MAX_MISORDER of RFC 3550 A.1
+	maxMisorder = 100
+)
+
+// inputCounters keeps the stats of a UDP input, updated by the reader and
+// read by the stats loop.
+type inputCounters struct {
+	lock       sync.Mutex
+	counters   udpstats.InputStats
+	source     string
+	seq        uint16
+	haveSeq    bool
+	arrival    time.Time
+	interval   time.Duration
+	rtpTime    uint32
+	haveTiming bool
+	// jitter estimate in seconds
+	jitter float64
+}
+
+// received records a datagram, it returns whether the source address
+// changed.
+func (c *inputCounters) received(n int, src *net.UDPAddr) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.counters.Packets++
+	c.counters.Bytes += int64(n)
+	switch n {
+	case 188:
+		c.counters.Size188++
+	case 376:
+		c.counters.Size376++
+	case 564:
+		c.counters.Size564++
+	case 752:
+		c.counters.Size752++
+	case 940:
+		c.counters.Size940++
+	case 1128:
+		c.counters.Size1128++
+	case 1316:
+		c.counters.Size1316++
+	default:
+		c.counters.SizeOther++
+	}
+	changed := false
+	if source := src.String(); source != c.source {
+		changed = c.source != ""
+		if changed {
+			c.counters.SourceChanges++
+			// sequence numbers and timing of another source are unrelated
+			c.haveSeq = false
+			c.haveTiming = false
+		}
+		c.source = source
+	}
+	return changed
+}
+
+// udpTiming updates the jitter of a plain UDP input from the variation of
+// the inter-arrival time.
+func (c *inputCounters) udpTiming(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.haveTiming {
+		interval := now.Sub(c.arrival)
+		d := math.Abs((interval - c.interval).Seconds())
+		c.jitter += (d - c.jitter) / 16
+		c.interval = interval
+	}
+	c.arrival = now
+	c.haveTiming = true
+}
+
+// rtp updates the sequence counters and the RFC 3550 jitter of an RTP
+// packet.
+func (c *inputCounters) rtp(seq uint16, timestamp uint32, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.haveSeq {
+		diff := int16(seq - (c.seq + 1))
+		if diff == -1 {
+			c.counters.Duplicates++
+			return
+		}
+		if diff < 0 && -int(diff) <= maxMisorder {
+			// the packet was counted as lost when its gap was skipped
+			c.counters.Reordered++
+			if c.counters.SequenceLost > 0 {
+				c.counters.SequenceLost--
+			}
+			return
+		}
+		if diff < 0 {
+			// timing of the restarted sender is unrelated
+			c.haveTiming = false
+		} else if diff > 0 {
+			c.counters.SequenceGaps++
+			c.counters.SequenceLost += int(diff)
+		}
+	}
+	c.seq = seq
+	c.haveSeq = true
+	if c.haveTiming {
+		arrival := now.Sub(c.arrival).Seconds()
+		transit := float64(int32(timestamp-c.rtpTime)) / rtpClockRate
+		d := math.Abs(arrival - transit)
+		c.jitter += (d - c.jitter) / 16
+	}
+	c.arrival = now
+	c.rtpTime = timestamp
+	c.haveTiming = true
+}
+
+func (c *inputCounters) readError() {
+	c.lock.Lock()
+	c.counters.ReadErrors++
+	c.lock.Unlock()
+}
+
+func (c *inputCounters) stats() *udpstats.InputStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	st := c.counters
+	st.JitterMS = c.jitter * 1000
+	return &st
+}
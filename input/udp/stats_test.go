@@ -0,0 +1,42 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTPCounters(t *testing.T) {
+	tests := []struct {
+		name                              string
+		seqs                              []uint16
+		gaps, lost, reordered, duplicates int
+	}{
+		{"in order", []uint16{1, 2, 3, 4}, 0, 0, 0, 0},
+		{"sequence wrap", []uint16{65534, 65535, 0, 1}, 0, 0, 0, 0},
+		{"gap", []uint16{1, 2, 5, 6}, 1, 2, 0, 0},
+		{"reordered", []uint16{1, 3, 2, 4}, 1, 0, 1, 0},
+		{"late after a gap", []uint16{1, 5, 3, 6}, 1, 2, 1, 0},
+		{"duplicate", []uint16{1, 2, 2, 3}, 0, 0, 0, 1},
+		{"restart", []uint16{1000, 1001, 1, 2}, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c inputCounters
+			now := time.Now()
+			for i, seq := range tt.seqs {
+				c.rtp(seq, uint32(i*3000), now.Add(time.Duration(i)*time.Millisecond))
+			}
+			st := c.stats()
+			if st.SequenceGaps != tt.gaps || st.SequenceLost != tt.lost || st.Reordered != tt.reordered || st.Duplicates != tt.duplicates {
+				t.Fatalf("%d gaps, %d lost, %d reordered and %d duplicates, want %d, %d, %d and %d",
+					st.SequenceGaps, st.SequenceLost, st.Reordered, st.Duplicates, tt.gaps, tt.lost, tt.reordered, tt.duplicates)
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/EmadHeravi/streamsow/input"
 	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/stats"
 )
 
 // UdpInput implements input.Input and represents a UDP-based input source.
@@ -21,17 +22,26 @@ type UdpInput struct {
 	cancel     context.CancelFunc
 	url        *url.URL
 	identifier string
+	isRtp      bool
+	params     *socketParams
+	stats      *stats.Stats
+	counters   inputCounters
 }
 
 // NewUdpInput sets up a UDP input object.
 // The packet reader loop will be started separately in reader.go.
-func NewUdpInput(parentCtx context.Context, u *url.URL, identifier string) (input.Input, error) {
+func NewUdpInput(parentCtx context.Context, u *url.URL, identifier string, s *stats.Stats) (input.Input, error) {
 	logger := logging.Log.With().
 		Str("module", "udp-input").
 		Str("identifier", identifier).
 		Str("url", u.String()).
 		Logger()
 
+	params, err := parseSocketParams(u)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(parentCtx)
 
 	logger.Info().Msg("initializing UDP input")
@@ -41,6 +51,9 @@ func NewUdpInput(parentCtx context.Context, u *url.URL, identifier string) (inpu
 		cancel:     cancel,
 		url:        u,
 		identifier: identifier,
+		isRtp:      u.Scheme == "rtp",
+		params:     params,
+		stats:      s,
 	}, nil
 }
 
@@ -0,0 +1,51 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udpstats
+
+// HitlessStats are the counters of a SMPTE 2022-7 merge of two RTP legs,
+// all counters are totals since the merge was started.
+type HitlessStats struct {
+	// packets passed on to the mainloop
+	Merged int
+	// packets missing on one leg that were taken from the other
+	Recovered int
+	// packets missing on both legs
+	Lost        int
+	Leg1Packets int
+	Leg1Lost    int
+	Leg2Packets int
+	Leg2Lost    int
+}
+
+// InputStats are the counters of a UDP or RTP input, all counters are
+// totals since the input was started.
+type InputStats struct {
+	Packets    int
+	Bytes      int64
+	ReadErrors int
+	// datagrams received from another source address than the previous one
+	SourceChanges int
+	// datagram size histogram, by number of 188 byte TS packets
+	Size188   int
+	Size376   int
+	Size564   int
+	Size752   int
+	Size940   int
+	Size1128  int
+	Size1316  int
+	SizeOther int
+	// rtp only, sequence number jumps and the packets skipped by them that
+	// didn't arrive late
+	SequenceGaps int
+	SequenceLost int
+	// rtp only, packets older than the last sequence number
+	Reordered int
+	// rtp only, repeats of the last sequence number
+	Duplicates int
+	// inter-arrival jitter in ms, for rtp inputs as defined in RFC 3550
+	JitterMS float64
+}
@@ -0,0 +1,65 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"time"
+
+	"github.com/EmadHeravi/streamsow/ts"
+)
+
+// ConfigureAnalyzer enables the TR 101 290 analyzer with the given settings,
+// nil disables it. Counters are kept when the settings didn't change.
+func (m *Mainloop) ConfigureAnalyzer(settings *ts.AnalyzerSettings) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	if settings == nil {
+		m.analyzer = nil
+		return
+	}
+	if m.analyzer != nil && m.analyzer.Settings() == *settings {
+		return
+	}
+	m.logger.Info().Msg("enabling transport stream analyzer")
+	m.analyzer = ts.NewAnalyzer(*settings)
+	m.lastTSErrors = 0
+}
+
+func (m *Mainloop) checkAnalyzer(now time.Time) {
+	m.statusLock.Lock()
+	a := m.analyzer
+	m.statusLock.Unlock()
+	if a != nil {
+		a.Check(now)
+	}
+}
+
+func (m *Mainloop) reportAnalyzerStats() {
+	m.statusLock.Lock()
+	a := m.analyzer
+	m.statusLock.Unlock()
+	if a == nil {
+		return
+	}
+	st := a.Stats()
+	go m.stats.HandleStats("", "", nil, &st)
+}
+
+// analyzerStatus fills the analyzer part of s, with reset the errors are
+// counted from this call on. Caller must hold statusLock.
+func (m *Mainloop) analyzerStatus(s *Status, reset bool) {
+	if m.analyzer == nil {
+		return
+	}
+	st := m.analyzer.Stats()
+	s.TR101290 = &st
+	errors := st.P1Errors()
+	s.TSErrorsSince = errors - m.lastTSErrors
+	if reset {
+		m.lastTSErrors = errors
+	}
+}
@@ -0,0 +1,288 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+)
+
+const (
+	// RistInputIdentifier identifies the RIST receiver as input source,
+	// all rist:// inputs of a flow are merged by librist into one source.
+	RistInputIdentifier = "rist"
+
+	failoverCheckInterval = 100 * time.Millisecond
+	bitrateWindow         = time.Second
+	maxSwitchEvents       = 10
+)
+
+// FailoverSettings configures switching between prioritised inputs.
+type FailoverSettings struct {
+	Enabled bool
+	// switch away from an input that didn't deliver for this long
+	SwitchAfter time.Duration
+	// switch away from an input below this bitrate, 0 disables the check
+	MinimalBitrate int
+	// a higher priority input must be healthy this long before switching back
+	RestoreAfter time.Duration
+}
+
+// SwitchEvent describes a change of the active input.
+type SwitchEvent struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// InputStatus is the failover state of a single input.
+type InputStatus struct {
+	Identifier        string `json:"identifier"`
+	Priority          int    `json:"priority"`
+	Active            bool   `json:"active"`
+	Healthy           bool   `json:"healthy"`
+	Bitrate           int    `json:"bitrate"`
+	MsSinceLastPacket int    `json:"mssincelastpacket"`
+}
+
+type inputsource struct {
+	identifier     string
+	priority       int
+	seq            seqstate
+	lastPacketTime time.Time
+	bytesWindow    int
+	windowStart    time.Time
+	bitrate        int
+	healthy        bool
+	healthySince   time.Time
+	cancel         context.CancelFunc
+}
+
+type sourceBlock struct {
+	src *inputsource
+	rb  *libristwrapper.RistDataBlock
+}
+
+// AddInput registers an input source with the given priority (lower is
+// preferred) and returns the channel the input writes its blocks to.
+func (m *Mainloop) AddInput(identifier string, priority int) chan<- *libristwrapper.RistDataBlock {
+	ctx, cancel := context.WithCancel(m.ctx)
+	src := &inputsource{
+		identifier:  identifier,
+		priority:    priority,
+		windowStart: time.Now(),
+		cancel:      cancel,
+	}
+	c := make(chan *libristwrapper.RistDataBlock, 256)
+	m.statusLock.Lock()
+	if old, ok := m.inputs[identifier]; ok {
+		old.cancel()
+	}
+	m.inputs[identifier] = src
+	m.statusLock.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case rb := <-c:
+				select {
+				case m.inputChan <- sourceBlock{src, rb}:
+				case <-ctx.Done():
+					rb.Return()
+					return
+				}
+			}
+		}
+	}()
+	return c
+}
+
+// SetRistInput registers the RIST receiver as input source with the given
+// priority, or removes it when the flow has no rist:// inputs left.
+func (m *Mainloop) SetRistInput(priority int, enabled bool) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	if !enabled {
+		delete(m.inputs, RistInputIdentifier)
+		m.ristSource = nil
+		if m.activeInput != nil && m.activeInput.identifier == RistInputIdentifier {
+			m.activeInput = nil
+		}
+		return
+	}
+	if m.ristSource == nil {
+		m.ristSource = &inputsource{
+			identifier:  RistInputIdentifier,
+			windowStart: time.Now(),
+			cancel:      func() {},
+		}
+		m.inputs[RistInputIdentifier] = m.ristSource
+	}
+	m.ristSource.priority = priority
+}
+
+// RemoveInput unregisters an input source.
+func (m *Mainloop) RemoveInput(identifier string) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	src, ok := m.inputs[identifier]
+	if !ok {
+		return
+	}
+	src.cancel()
+	delete(m.inputs, identifier)
+	if m.activeInput == src {
+		m.activeInput = nil
+	}
+}
+
+// ConfigureFailover updates the failover settings.
+func (m *Mainloop) ConfigureFailover(settings FailoverSettings) {
+	m.statusLock.Lock()
+	m.failover = settings
+	m.statusLock.Unlock()
+}
+
+// handleSourceBlock forwards a block if it was received on the active input.
+func (m *Mainloop) handleSourceBlock(src *inputsource, rb *libristwrapper.RistDataBlock) {
+	if src == nil {
+		m.handleBlock(m.reinsertNulls(RistInputIdentifier, rb), &m.ristSeq)
+		return
+	}
+	rb = m.reinsertNulls(src.identifier, rb)
+	m.statusLock.Lock()
+	src.lastPacketTime = time.Now()
+	src.bytesWindow += len(rb.Data)
+	forward := true
+	if m.failover.Enabled {
+		if m.activeInput == nil {
+			m.switchInput(src, "first input delivering")
+		}
+		forward = src == m.activeInput
+	}
+	m.statusLock.Unlock()
+
+	if !forward {
+		rb.Return()
+		return
+	}
+	m.handleBlock(rb, &src.seq)
+}
+
+// sortedInputs returns the inputs in order of preference, caller must hold
+// statusLock.
+func (m *Mainloop) sortedInputs() []*inputsource {
+	inputs := make([]*inputsource, 0, len(m.inputs))
+	for _, src := range m.inputs {
+		inputs = append(inputs, src)
+	}
+	sort.Slice(inputs, func(i, j int) bool {
+		if inputs[i].priority != inputs[j].priority {
+			return inputs[i].priority < inputs[j].priority
+		}
+		return inputs[i].identifier < inputs[j].identifier
+	})
+	return inputs
+}
+
+// checkInputs updates the health of all inputs and switches the active input
+// when needed.
+func (m *Mainloop) checkInputs(now time.Time) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	if !m.failover.Enabled {
+		return
+	}
+
+	var best *inputsource
+	for _, src := range m.sortedInputs() {
+		if elapsed := now.Sub(src.windowStart); elapsed >= bitrateWindow {
+			src.bitrate = int(int64(src.bytesWindow) * 8 * int64(time.Second) / int64(elapsed))
+			src.bytesWindow = 0
+			src.windowStart = now
+		}
+		healthy := !src.lastPacketTime.IsZero() && now.Sub(src.lastPacketTime) < m.failover.SwitchAfter
+		if m.failover.MinimalBitrate > 0 && src.bitrate < m.failover.MinimalBitrate {
+			healthy = false
+		}
+		if healthy && !src.healthy {
+			src.healthySince = now
+		}
+		src.healthy = healthy
+		if healthy && best == nil {
+			best = src
+		}
+	}
+
+	active := m.activeInput
+	switch {
+	case best == nil || best == active:
+		return
+	case active == nil:
+		m.switchInput(best, "first healthy input")
+	case !active.healthy:
+		m.switchInput(best, "active input unhealthy")
+	case best.priority < active.priority && now.Sub(best.healthySince) >= m.failover.RestoreAfter:
+		m.switchInput(best, "higher priority input restored")
+	}
+}
+
+// switchInput makes src the active input, caller must hold statusLock.
+func (m *Mainloop) switchInput(src *inputsource, reason string) {
+	from := ""
+	if m.activeInput != nil {
+		from = m.activeInput.identifier
+	}
+	event := SwitchEvent{
+		Time:   time.Now(),
+		From:   from,
+		To:     src.identifier,
+		Reason: reason,
+	}
+	m.activeInput = src
+	m.inputSwitches++
+	m.switchEvents = append(m.switchEvents, event)
+	if len(m.switchEvents) > maxSwitchEvents {
+		m.switchEvents = m.switchEvents[1:]
+	}
+	m.logger.Warn().
+		Str("from", from).
+		Str("to", src.identifier).
+		Str("reason", reason).
+		Msgf("switching active input to %s", src.identifier)
+}
+
+// inputStatus fills the failover part of s, caller must hold statusLock.
+func (m *Mainloop) inputStatus(s *Status, now time.Time) {
+	if !m.failover.Enabled {
+		return
+	}
+	if m.activeInput != nil {
+		s.ActiveInput = m.activeInput.identifier
+	}
+	s.InputSwitches = m.inputSwitches
+	s.SwitchEvents = append([]SwitchEvent(nil), m.switchEvents...)
+	for _, src := range m.sortedInputs() {
+		in := InputStatus{
+			Identifier: src.identifier,
+			Priority:   src.priority,
+			Active:     src == m.activeInput,
+			Healthy:    src.healthy,
+			Bitrate:    src.bitrate,
+		}
+		if !src.lastPacketTime.IsZero() {
+			in.MsSinceLastPacket = int(now.Sub(src.lastPacketTime).Milliseconds())
+		}
+		s.Inputs = append(s.Inputs, in)
+	}
+}
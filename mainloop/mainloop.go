@@ -12,14 +12,18 @@ import (
 	"time"
 
 	"code.videolan.org/rist/ristgo"
+	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/EmadHeravi/streamsow/logging"
 	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/stats"
+	"github.com/EmadHeravi/streamsow/ts"
 	"github.com/rs/zerolog"
 )
 
 // InputPacket previously used for UDP/RTP input has been deprecated.
 // All input sources (UDP, SRT, RIST) are now normalized to RIST blocks
-// before entering this mainloop.
+// before entering this mainloop. RIST inputs arrive via the ristgo
+// ReceiverFlow, all other inputs via their own channel (see AddInput).
 
 // inputstatus tracks statistics for the primary input.
 type inputstatus struct {
@@ -30,6 +34,13 @@ type inputstatus struct {
 	lastPacketTime     time.Time
 }
 
+// seqstate tracks sequence continuity for a single block source.
+type seqstate struct {
+	expectedSeq                 uint16
+	lastDiscontinuityMsg        time.Time
+	discontinuitiesSinceLastMsg int
+}
+
 // Mainloop is the central receiver loop that takes RIST blocks
 // from a ristgo.ReceiverFlow and forwards them to registered outputs.
 type Mainloop struct {
@@ -40,10 +51,23 @@ type Mainloop struct {
 	outPutAdd          chan output.Output
 	outPutRemove       chan output.Output
 	outRemoveIdx       chan int
+	inputChan          chan sourceBlock
 	wg                 sync.WaitGroup
 	statusLock         sync.Mutex
 	primaryInputStatus inputstatus
 	lastStatusCall     time.Time
+	inputs             map[string]*inputsource
+	ristSource         *inputsource
+	ristSeq            seqstate
+	activeInput        *inputsource
+	failover           FailoverSettings
+	inputSwitches      int
+	switchEvents       []SwitchEvent
+	outputSettings     map[string]OutputSettings
+	stats              *stats.Stats
+	analyzer           *ts.Analyzer
+	lastTSErrors       int
+	nullInserters      map[string]*ts.NullInserter
 }
 
 // removeOutputByID schedules removal of an output by index.
@@ -101,30 +125,77 @@ func (m *Mainloop) Wait(timeout time.Duration) {
 
 // NewMainloop wires a RIST ReceiverFlow into the main processing loop.
 // All packet sources are normalized to RIST and appear in the same flow.
-func NewMainloop(ctx context.Context, flow ristgo.ReceiverFlow, identifier string) *Mainloop {
+func NewMainloop(ctx context.Context, flow ristgo.ReceiverFlow, identifier string, s *stats.Stats) *Mainloop {
 	m := &Mainloop{
-		ctx:          ctx,
-		flow:         flow,
-		logger:       logging.Log.With().Str("identifier", identifier).Logger(),
-		outputs:      make(map[int]*out),
-		outPutAdd:    make(chan output.Output, 4),
-		outPutRemove: make(chan output.Output, 4),
-		outRemoveIdx: make(chan int, 16),
+		ctx:            ctx,
+		flow:           flow,
+		logger:         logging.Log.With().Str("identifier", identifier).Logger(),
+		outputs:        make(map[int]*out),
+		outPutAdd:      make(chan output.Output, 4),
+		outPutRemove:   make(chan output.Output, 4),
+		outRemoveIdx:   make(chan int, 16),
+		inputChan:      make(chan sourceBlock, 256),
+		inputs:         make(map[string]*inputsource),
+		outputSettings: make(map[string]OutputSettings),
+		nullInserters:  make(map[string]*ts.NullInserter),
+		stats:          s,
 	}
 	go receiveLoop(m)
 	return m
 }
 
+// handleBlock updates input statistics for a block and forwards it.
+func (m *Mainloop) handleBlock(rb *libristwrapper.RistDataBlock, s *seqstate) {
+	discontinuity := false
+	if rb.Discontinuity {
+		discontinuity = true
+	}
+	if rb.SeqNo != uint32(s.expectedSeq) {
+		discontinuity = true
+	}
+	if discontinuity {
+		m.primaryInputStatus.discontinuitycount++
+		s.discontinuitiesSinceLastMsg++
+	}
+
+	if s.discontinuitiesSinceLastMsg > 0 &&
+		time.Since(s.lastDiscontinuityMsg) >= 5*time.Second {
+		m.logger.Error().
+			Int("count", s.discontinuitiesSinceLastMsg).
+			Msg("discontinuity!")
+		s.lastDiscontinuityMsg = time.Now()
+		s.discontinuitiesSinceLastMsg = 0
+	}
+
+	s.expectedSeq = uint16(rb.SeqNo) + 1
+
+	now := time.Now()
+	m.statusLock.Lock()
+	m.primaryInputStatus.packetcount++
+	m.primaryInputStatus.packetcountsince++
+	m.primaryInputStatus.lastPacketTime = now
+	m.primaryInputStatus.bytesSince += len(rb.Data)
+	analyzer := m.analyzer
+	m.statusLock.Unlock()
+
+	if analyzer != nil {
+		analyzer.Analyze(rb.Data, now)
+	}
+
+	m.writeOutputs(rb)
+}
+
 // receiveLoop consumes RIST data blocks from the flow and forwards to outputs.
 func receiveLoop(m *Mainloop) {
 	outputidx := 0
 	m.primaryInputStatus.lastPacketTime = time.Now()
 	m.lastStatusCall = m.primaryInputStatus.lastPacketTime
-	expectedSeq := uint16(0)
+	failoverTicker := time.NewTicker(failoverCheckInterval)
+	defer failoverTicker.Stop()
+	statsTicker := time.NewTicker(time.Duration(stats.StatsIntervalSeconds) * time.Second)
+	defer statsTicker.Stop()
 	m.logger.Info().Msg("receiver mainloop started")
 	m.wg.Add(1)
-	lastDiscontinuityMsg := time.Time{}
-	discontinuitiesSinceLastMsg := 0
 
 main:
 	for {
@@ -137,38 +208,22 @@ main:
 			if !ok {
 				break main
 			}
-
-			discontinuity := false
-			if rb.Discontinuity {
-				discontinuity = true
-			}
-			if rb.SeqNo != uint32(expectedSeq) {
-				discontinuity = true
-			}
-			if discontinuity {
-				m.primaryInputStatus.discontinuitycount++
-				discontinuitiesSinceLastMsg++
-			}
-
-			if discontinuitiesSinceLastMsg > 0 &&
-				time.Since(lastDiscontinuityMsg) >= 5*time.Second {
-				m.logger.Error().
-					Int("count", discontinuitiesSinceLastMsg).
-					Msg("discontinuity!")
-				lastDiscontinuityMsg = time.Now()
-				discontinuitiesSinceLastMsg = 0
-			}
-
-			expectedSeq = uint16(rb.SeqNo) + 1
-
 			m.statusLock.Lock()
-			m.primaryInputStatus.packetcount++
-			m.primaryInputStatus.packetcountsince++
-			m.primaryInputStatus.lastPacketTime = time.Now()
-			m.primaryInputStatus.bytesSince += len(rb.Data)
+			src := m.ristSource
 			m.statusLock.Unlock()
+			m.handleSourceBlock(src, rb)
+
+		// UDP/SRT input path
+		case sb := <-m.inputChan:
+			m.handleSourceBlock(sb.src, sb.rb)
+
+		case now := <-failoverTicker.C:
+			m.checkInputs(now)
+			m.checkAnalyzer(now)
 
-			m.writeOutputs(rb)
+		case <-statsTicker.C:
+			m.reportOutputStats()
+			m.reportAnalyzerStats()
 
 		case o := <-m.outPutAdd:
 			m.statusLock.Lock()
@@ -0,0 +1,51 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package mainloop
+
+import (
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/ts"
+)
+
+// SetNullReinsertion enables reinsertion of the null packets removed by a
+// sender with null deletion for the input with the given identifier, use
+// RistInputIdentifier for the RIST receiver.
+func (m *Mainloop) SetNullReinsertion(identifier string, enabled bool) {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	if !enabled {
+		delete(m.nullInserters, identifier)
+		return
+	}
+	if _, ok := m.nullInserters[identifier]; !ok {
+		m.nullInserters[identifier] = &ts.NullInserter{}
+	}
+}
+
+// reinsertNulls returns rb with the deleted null packets restored when
+// enabled for the input, every block of the input must pass here to keep
+// the position within a deletion group.
+func (m *Mainloop) reinsertNulls(identifier string, rb *libristwrapper.RistDataBlock) *libristwrapper.RistDataBlock {
+	m.statusLock.Lock()
+	inserter := m.nullInserters[identifier]
+	m.statusLock.Unlock()
+	if inserter == nil {
+		return rb
+	}
+	data := inserter.Process(rb.Data)
+	if len(data) == len(rb.Data) {
+		return rb
+	}
+	restored := &libristwrapper.RistDataBlock{
+		Data:          data,
+		SeqNo:         rb.SeqNo,
+		TimeStamp:     rb.TimeStamp,
+		Discontinuity: rb.Discontinuity,
+	}
+	rb.Return()
+	return restored
+}
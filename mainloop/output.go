@@ -8,57 +8,343 @@ package mainloop
 
 import (
 	"context"
+	"errors"
+	"sync/atomic"
+	"time"
 
 	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/mainloop/queuestats"
 	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/ts"
 )
 
+const (
+	defaultQueueDepth = 256
+	// packets per block written by constant bitrate outputs
+	cbrBlockPackets = 7
+	// a constant bitrate output further behind is restarted at the current
+	// time instead of catching up with a burst
+	maxCBRLag = time.Second
+)
+
+// BackpressurePolicy decides what happens when an output's queue is full.
+type BackpressurePolicy string
+
+const (
+	// DropNewest drops the block that doesn't fit in the queue
+	DropNewest BackpressurePolicy = "drop-newest"
+	// DropOldest drops the oldest queued block to make room
+	DropOldest BackpressurePolicy = "drop-oldest"
+	// Disconnect drops like DropNewest, but removes and closes the output
+	// when its queue stays full for DisconnectAfter
+	Disconnect BackpressurePolicy = "disconnect"
+)
+
+// OutputSettings configures the queue between the mainloop and an output.
+type OutputSettings struct {
+	QueueDepth      int
+	Policy          BackpressurePolicy
+	DisconnectAfter time.Duration
+	// TS processing applied to the blocks written to the output, nil
+	// writes the blocks unmodified
+	Processing *ts.ProcessorConfig
+	// constant bitrate in bits/s the output is stuffed with null packets
+	// to and paced at, 0 writes blocks as they arrive
+	CBRBitrate int
+	// max time packets are queued for the constant bitrate
+	CBRMaxDelay time.Duration
+	// remove null packets, signalled for reinsertion by the receiver
+	NullDeletion bool
+}
+
 type out struct {
-	c        context.Context
-	w        output.Output
-	i        int
-	m        *Mainloop
-	dataChan chan *libristwrapper.RistDataBlock
+	c          context.Context
+	w          output.Output
+	i          int
+	m          *Mainloop
+	dataChan   chan *libristwrapper.RistDataBlock
+	identifier string
+	settings   OutputSettings
+	processor  *ts.Processor
+	shaper     *ts.Shaper
+	fullSince  time.Time
+
+	queuedBlocks    int64
+	queuedBytes     int64
+	writtenBlocks   int64
+	writtenBytes    int64
+	droppedBlocks   int64
+	droppedBytes    int64
+	nullPackets     int64
+	deletedNulls    int64
+	overflowPackets int64
+}
+
+// SetOutputSettings sets the queue settings for outputs with the given
+// output identifier, they apply to outputs added afterwards.
+func (m *Mainloop) SetOutputSettings(identifier string, s OutputSettings) {
+	m.statusLock.Lock()
+	m.outputSettings[identifier] = s
+	m.statusLock.Unlock()
+}
+
+// OutputSettings returns the queue settings for outputs with the given
+// output identifier, outputs that fan out to their own clients apply them
+// per client.
+func (m *Mainloop) OutputSettings(identifier string) OutputSettings {
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	return m.settingsFor(identifier)
+}
+
+func (m *Mainloop) settingsFor(identifier string) OutputSettings {
+	settings, ok := m.outputSettings[identifier]
+	if !ok {
+		settings = OutputSettings{Policy: DropNewest}
+	}
+	if settings.QueueDepth <= 0 {
+		settings.QueueDepth = defaultQueueDepth
+	}
+	return settings
 }
 
 func (m *Mainloop) addOutput(w output.Output, i int) {
+	identifier := ""
+	if id, ok := w.(output.Identifier); ok {
+		identifier = id.OutputIdentifier()
+	}
+	settings := m.settingsFor(identifier)
 	o := &out{
-		m.ctx,
-		w,
-		i,
-		m,
-		make(chan *libristwrapper.RistDataBlock, 256),
+		c:          m.ctx,
+		w:          w,
+		i:          i,
+		m:          m,
+		dataChan:   make(chan *libristwrapper.RistDataBlock, settings.QueueDepth),
+		identifier: identifier,
+		settings:   settings,
+	}
+	if settings.Processing != nil {
+		o.processor = ts.NewProcessor(*settings.Processing)
+	}
+	if settings.CBRBitrate > 0 {
+		o.shaper = ts.NewShaper(settings.CBRBitrate, settings.CBRMaxDelay)
 	}
 	go o.loop()
 	m.outputs[i] = o
 }
 
+// processTS runs the output's processor on data, logging PIDs colliding with
+// a remap target.
+func (o *out) processTS(data []byte) []byte {
+	data = o.processor.Process(data)
+	for _, pid := range o.processor.Collisions() {
+		o.m.logger.Warn().
+			Str("output_identifier", o.identifier).
+			Msgf("output %s: pid %d collides with a remap target, dropping its packets", o.w.String(), pid)
+	}
+	return data
+}
+
+// process returns a processed copy of rb, or nil when nothing is left.
+func (o *out) process(rb *libristwrapper.RistDataBlock) *libristwrapper.RistDataBlock {
+	data := rb.Data
+	if o.processor != nil {
+		data = o.processTS(data)
+	}
+	if o.settings.NullDeletion {
+		var deleted int
+		data, deleted = ts.DeleteNulls(data)
+		atomic.AddInt64(&o.deletedNulls, int64(deleted))
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return &libristwrapper.RistDataBlock{
+		Data:          data,
+		SeqNo:         rb.SeqNo,
+		TimeStamp:     rb.TimeStamp,
+		Discontinuity: rb.Discontinuity,
+	}
+}
+
 func (o *out) write(rb *libristwrapper.RistDataBlock) error {
 	defer rb.Return()
+	if o.processor != nil || o.settings.NullDeletion {
+		processed := o.process(rb)
+		if processed == nil {
+			return nil
+		}
+		defer processed.Return()
+		rb = processed
+	}
+	return o.writeBlock(rb)
+}
+
+func (o *out) writeBlock(rb *libristwrapper.RistDataBlock) error {
 	_, err := o.w.Write(rb)
+	if errors.Is(err, output.ErrDropped) {
+		atomic.AddInt64(&o.droppedBlocks, 1)
+		atomic.AddInt64(&o.droppedBytes, int64(len(rb.Data)))
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	atomic.AddInt64(&o.writtenBlocks, 1)
+	atomic.AddInt64(&o.writtenBytes, int64(len(rb.Data)))
 	return nil
 }
 
+// fail removes the output after a write error.
+func (o *out) fail(err error) {
+	logging.Log.Error().Err(err).Msg("error writing to output")
+	o.m.removeOutputByID(o.i)
+	for rb := range o.dataChan {
+		rb.Return()
+	}
+}
+
 func (o *out) loop() {
+	if o.shaper != nil {
+		o.cbrLoop()
+		return
+	}
 	for {
 		select {
 		case <-o.c.Done():
 			return
-		case rb := <-o.dataChan:
+		case rb, ok := <-o.dataChan:
+			if !ok {
+				return
+			}
 			err := o.write(rb)
 			if err != nil {
-				logging.Log.Error().Err(err).Msg("error writing to output")
-				o.m.removeOutputByID(o.i)
-				for rb := range o.dataChan {
-					rb.Return()
-				}
+				o.fail(err)
+				return
+			}
+		}
+	}
+}
+
+// shape queues the packets of rb in the shaper.
+func (o *out) shape(rb *libristwrapper.RistDataBlock) {
+	defer rb.Return()
+	data := rb.Data
+	if o.processor != nil {
+		data = o.processTS(data)
+	}
+	o.shaper.Push(data)
+	atomic.StoreInt64(&o.overflowPackets, int64(o.shaper.OverflowPackets))
+}
+
+// cbrLoop writes the shaped stream paced at the constant bitrate, blocks
+// from the queue are only handed to the shaper.
+func (o *out) cbrLoop() {
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-o.c.Done():
+			return
+		case rb, ok := <-o.dataChan:
+			if !ok {
+				return
+			}
+			o.shape(rb)
+			continue
+		case <-timer.C:
+		}
+		for time.Since(start) >= o.shaper.Elapsed() {
+			if lag := time.Since(start) - o.shaper.Elapsed(); lag > maxCBRLag {
+				o.m.logger.Warn().
+					Str("output_identifier", o.identifier).
+					Msgf("output %s fell %s behind its constant bitrate, restarting pacing", o.w.String(), lag)
+				start = start.Add(lag)
+			}
+			rb := &libristwrapper.RistDataBlock{Data: o.shaper.Next(cbrBlockPackets)}
+			atomic.StoreInt64(&o.nullPackets, int64(o.shaper.NullPackets))
+			if err := o.writeBlock(rb); err != nil {
+				o.fail(err)
 				return
 			}
 		}
+		timer.Reset(time.Until(start.Add(o.shaper.Elapsed())))
+	}
+}
+
+func (o *out) drop(rb *libristwrapper.RistDataBlock) {
+	atomic.AddInt64(&o.droppedBlocks, 1)
+	atomic.AddInt64(&o.droppedBytes, int64(len(rb.Data)))
+	rb.Return()
+}
+
+// enqueue queues rb for the output applying its backpressure policy,
+// returns false when the output should be disconnected.
+func (o *out) enqueue(rb *libristwrapper.RistDataBlock, now time.Time) bool {
+	select {
+	case o.dataChan <- rb:
+		o.fullSince = time.Time{}
+		atomic.AddInt64(&o.queuedBlocks, 1)
+		atomic.AddInt64(&o.queuedBytes, int64(len(rb.Data)))
+		return true
+	default:
+	}
+
+	switch o.settings.Policy {
+	case DropOldest:
+		select {
+		case old := <-o.dataChan:
+			o.drop(old)
+		default:
+		}
+		select {
+		case o.dataChan <- rb:
+			atomic.AddInt64(&o.queuedBlocks, 1)
+			atomic.AddInt64(&o.queuedBytes, int64(len(rb.Data)))
+		default:
+			o.drop(rb)
+		}
+	case Disconnect:
+		o.drop(rb)
+		if o.fullSince.IsZero() {
+			o.fullSince = now
+		} else if now.Sub(o.fullSince) >= o.settings.DisconnectAfter {
+			return false
+		}
+	default:
+		o.drop(rb)
+	}
+	return true
+}
+
+func (o *out) stats() *queuestats.QueueStats {
+	return &queuestats.QueueStats{
+		Output:          o.w.String(),
+		QueueDepth:      cap(o.dataChan),
+		QueueLength:     len(o.dataChan),
+		QueuedBlocks:    int(atomic.LoadInt64(&o.queuedBlocks)),
+		QueuedBytes:     int(atomic.LoadInt64(&o.queuedBytes)),
+		WrittenBlocks:   int(atomic.LoadInt64(&o.writtenBlocks)),
+		WrittenBytes:    int(atomic.LoadInt64(&o.writtenBytes)),
+		DroppedBlocks:   int(atomic.LoadInt64(&o.droppedBlocks)),
+		DroppedBytes:    int(atomic.LoadInt64(&o.droppedBytes)),
+		NullPackets:     int(atomic.LoadInt64(&o.nullPackets)),
+		OverflowPackets: int(atomic.LoadInt64(&o.overflowPackets)),
+		DeletedNulls:    int(atomic.LoadInt64(&o.deletedNulls)),
+	}
+}
+
+// reportOutputStats sends the queue stats of all outputs to the stats sinks.
+func (m *Mainloop) reportOutputStats() {
+	if m.stats == nil {
+		return
+	}
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
+	for _, o := range m.outputs {
+		go m.stats.HandleStats("", o.identifier, nil, o.stats())
 	}
 }
 
@@ -66,18 +352,28 @@ func (m *Mainloop) writeOutputs(rb *libristwrapper.RistDataBlock) {
 	if len(rb.Data) == 0 {
 		return
 	}
+	m.statusLock.Lock()
+	defer m.statusLock.Unlock()
 	if len(m.outputs) == 0 {
 		rb.Return()
 		return
 	}
-	for _, out := range m.outputs {
+	now := time.Now()
+	var disconnect []int
+	for idx, out := range m.outputs {
 		rb.Increment()
-		select {
-		case out.dataChan <- rb:
-			//
-		default:
-			rb.Return()
+		if !out.enqueue(rb, now) {
+			disconnect = append(disconnect, idx)
 		}
 	}
 	rb.Return()
+
+	for _, idx := range disconnect {
+		o := m.outputs[idx]
+		m.logger.Error().
+			Str("output_identifier", o.identifier).
+			Msgf("output %s under sustained backpressure for %s, disconnecting", o.w.String(), o.settings.DisconnectAfter)
+		m.deleteOutput(idx, o.w)
+		go o.w.Close()
+	}
 }
@@ -0,0 +1,27 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package queuestats
+
+// QueueStats are the counters of the queue between the mainloop and a
+// single output, all counters are totals since the output was added.
+type QueueStats struct {
+	Output        string
+	QueueDepth    int
+	QueueLength   int
+	QueuedBlocks  int
+	QueuedBytes   int
+	WrittenBlocks int
+	WrittenBytes  int
+	DroppedBlocks int
+	DroppedBytes  int
+	// constant bitrate outputs only: null packets inserted and packets
+	// dropped because the input exceeded the bitrate
+	NullPackets     int
+	OverflowPackets int
+	// null deletion outputs only: null packets removed
+	DeletedNulls int
+}
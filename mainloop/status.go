@@ -6,35 +6,76 @@
 
 package mainloop
 
-import "time"
+import (
+	"time"
+
+	"github.com/EmadHeravi/streamsow/input/udp/udpstats"
+	"github.com/EmadHeravi/streamsow/mainloop/queuestats"
+	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/ts/tsstats"
+)
 
 type Status struct {
-	OK                bool      `json:"-"`
-	Status            string    `json:"status"`
-	LastPacketTime    time.Time `json:"lastpackettimestamp"`
-	MsSinceLastPacket int       `json:"mssincelastpacket"`
-	PacketCount       int       `json:"packetcount"`
-	PacketsSince      int       `json:"packetssince"`
-	OutputCount       int       `json:"outputcount"`
-	Bitrate           int       `json:"bitrate"`
+	OK                bool                     `json:"-"`
+	Status            string                   `json:"status"`
+	LastPacketTime    time.Time                `json:"lastpackettimestamp"`
+	MsSinceLastPacket int                      `json:"mssincelastpacket"`
+	PacketCount       int                      `json:"packetcount"`
+	PacketsSince      int                      `json:"packetssince"`
+	OutputCount       int                      `json:"outputcount"`
+	Bitrate           int                      `json:"bitrate"`
+	ActiveInput       string                   `json:"activeinput,omitempty"`
+	InputSwitches     int                      `json:"inputswitches,omitempty"`
+	Inputs            []InputStatus            `json:"inputs,omitempty"`
+	SwitchEvents      []SwitchEvent            `json:"switchevents,omitempty"`
+	Hitless           *udpstats.HitlessStats   `json:"hitless,omitempty"`
+	OutputQueues      []*queuestats.QueueStats `json:"outputqueues,omitempty"`
+	TR101290          *tsstats.TR101290Stats   `json:"tr101290,omitempty"`
+	// connected clients per output identifier
+	Clients map[string]int `json:"clients,omitempty"`
+	// configured outputs, filled in by the flow
+	Outputs []output.Status `json:"outputs,omitempty"`
+	// priority 1 errors since the previous status call
+	TSErrorsSince int `json:"tserrorssince,omitempty"`
 }
 
+// Status returns the status since the previous call, the bitrate window,
+// packetssince and tserrorssince are restarted.
 func (m *Mainloop) Status() *Status {
+	return m.status(true)
+}
+
+// StatusSnapshot returns the status without restarting the windows of
+// Status, for readers other than the /status endpoint.
+func (m *Mainloop) StatusSnapshot() *Status {
+	return m.status(false)
+}
+
+func (m *Mainloop) status(reset bool) *Status {
 	m.statusLock.Lock()
 	defer m.statusLock.Unlock()
 	var status Status
 	now := time.Now()
 	us := now.Sub(m.lastStatusCall).Microseconds()
 	status.MsSinceLastPacket = int(now.Sub(m.primaryInputStatus.lastPacketTime).Milliseconds())
-	status.Bitrate = ((m.primaryInputStatus.bytesSince * 8 * 1000000) / int(us))
+	if us > 0 {
+		status.Bitrate = int(int64(m.primaryInputStatus.bytesSince) * 8 * 1000000 / us)
+	}
 	status.PacketCount = m.primaryInputStatus.packetcount
 	status.PacketsSince = m.primaryInputStatus.packetcountsince
 	status.LastPacketTime = m.primaryInputStatus.lastPacketTime
 	status.OutputCount = len(m.outputs)
+	for _, o := range m.outputs {
+		status.OutputQueues = append(status.OutputQueues, o.stats())
+	}
+	m.inputStatus(&status, now)
+	m.analyzerStatus(&status, reset)
 
-	m.primaryInputStatus.bytesSince = 0
-	m.primaryInputStatus.packetcountsince = 0
-	m.lastStatusCall = now
+	if reset {
+		m.primaryInputStatus.bytesSince = 0
+		m.primaryInputStatus.packetcountsince = 0
+		m.lastStatusCall = now
+	}
 
 	status.Status = "OK"
 	status.OK = true
@@ -33,6 +33,7 @@ import (
 )
 
 type dektecasi struct {
+	output.Tracker
 	m                 *mainloop.Mainloop
 	ctx               context.Context
 	cancel            context.CancelFunc
@@ -72,6 +73,10 @@ func (d *dektecasi) Count() int {
 	return 1
 }
 
+func (d *dektecasi) OutputIdentifier() string {
+	return d.output_identifier
+}
+
 func (d *dektecasi) Write(block *libristwrapper.RistDataBlock) (n int, err error) {
 	select {
 	case <-d.ctx.Done():
@@ -80,6 +85,7 @@ func (d *dektecasi) Write(block *libristwrapper.RistDataBlock) (n int, err error
 		//
 	}
 	n_out := C.dektec_asi_write(d.dektecCtx, (*C.char)(unsafe.Pointer(&block.Data[0])), C.size_t(len(block.Data)))
+	d.Written(int(n_out))
 	return int(n_out), nil
 }
 
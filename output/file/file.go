@@ -0,0 +1,228 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/ts"
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultRotate = time.Hour
+	// rotatelogs removes files older than 7 days when neither max age nor
+	// max count is set, recordings are kept unless retention is configured.
+	keepForever = 100 * 365 * 24 * time.Hour
+
+	errorMsgInterval = 5 * time.Second
+)
+
+type fileoutput struct {
+	output.Tracker
+	lock              sync.Mutex
+	w                 *rotatelogs.RotateLogs
+	logger            zerolog.Logger
+	m                 *mainloop.Mainloop
+	name              string
+	output_identifier string
+	closed            bool
+	// partial packet carried over to the next block
+	carry        []byte
+	lastErrorMsg time.Time
+	errors       int
+}
+
+// parseSize parses a byte count with an optional K, M, G or T suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseDuration is time.ParseDuration with support for a d (days) suffix.
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ParseFileOutput sets up a recording output writing to rotating files. The
+// url path is a strftime pattern, e.g.:
+// file:///recordings/channel1-%Y%m%d-%H%M.ts?rotate=15m&maxage=30d
+// Supported params: rotate (interval), maxsize (bytes, K/M/G/T suffix),
+// maxfiles or maxage (retention) and link (symlink to the current file).
+func ParseFileOutput(u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop) (output.Output, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up file output: %s", u.String())
+	pattern := filepath.Join(u.Host, u.Path)
+	if pattern == "" || pattern == "." {
+		return nil, errors.New("file output requires a path")
+	}
+	if err := os.MkdirAll(filepath.Dir(pattern), 0755); err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	options := []rotatelogs.Option{rotatelogs.WithClock(rotatelogs.Local)}
+	rotate := defaultRotate
+	if r := q.Get("rotate"); r != "" {
+		var err error
+		if rotate, err = parseDuration(r); err != nil || rotate <= 0 {
+			return nil, fmt.Errorf("invalid rotate interval %q", r)
+		}
+	}
+	options = append(options, rotatelogs.WithRotationTime(rotate))
+	if s := q.Get("maxsize"); s != "" {
+		size, err := parseSize(s)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, rotatelogs.WithRotationSize(size))
+	}
+	maxFiles, maxAge := q.Get("maxfiles"), q.Get("maxage")
+	switch {
+	case maxFiles != "" && maxAge != "":
+		return nil, errors.New("file output retention can be either maxfiles or maxage, not both")
+	case maxFiles != "":
+		n, err := strconv.ParseUint(maxFiles, 10, 32)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid maxfiles %q", maxFiles)
+		}
+		options = append(options, rotatelogs.WithRotationCount(uint(n)))
+	case maxAge != "":
+		age, err := parseDuration(maxAge)
+		if err != nil || age <= 0 {
+			return nil, fmt.Errorf("invalid maxage %q", maxAge)
+		}
+		options = append(options, rotatelogs.WithMaxAge(age))
+	default:
+		options = append(options, rotatelogs.WithMaxAge(keepForever))
+	}
+	if link := q.Get("link"); link != "" {
+		options = append(options, rotatelogs.WithLinkName(link))
+	}
+
+	w, err := rotatelogs.New(pattern, options...)
+	if err != nil {
+		return nil, err
+	}
+	out := &fileoutput{
+		w:                 w,
+		logger:            logging.Log.With().Str("module", "file-output").Str("identifier", identifier).Str("output_identifier", output_identifier).Logger(),
+		m:                 m,
+		name:              u.String(),
+		output_identifier: output_identifier,
+	}
+	m.AddOutput(out)
+	return out, nil
+}
+
+// aligned returns the whole packets in data, prepended with the carried over
+// partial packet. Bytes before a sync byte are skipped so every write, and
+// thereby every file, starts on a packet boundary.
+func (f *fileoutput) aligned(data []byte) []byte {
+	if len(f.carry) == 0 && len(data)%ts.PacketSize == 0 && len(data) > 0 && data[0] == ts.SyncByte {
+		return data
+	}
+	buf := append(f.carry, data...)
+	skip := 0
+	for skip < len(buf) && buf[skip] != ts.SyncByte {
+		skip++
+	}
+	if skip > 0 {
+		f.logger.Warn().Msgf("skipped %d bytes to find ts sync", skip)
+		buf = buf[skip:]
+	}
+	whole := len(buf) - len(buf)%ts.PacketSize
+	f.carry = append([]byte(nil), buf[whole:]...)
+	return buf[:whole]
+}
+
+// Write never fails, write errors are logged and recording continues with
+// the next block so a temporarily full disk doesn't stop the recording.
+func (f *fileoutput) Write(block *libristwrapper.RistDataBlock) (n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return 0, nil
+	}
+	data := f.aligned(block.Data)
+	if len(data) == 0 {
+		return 0, nil
+	}
+	if n, err = f.w.Write(data); err != nil {
+		f.Failed(err)
+		f.errors++
+		if time.Since(f.lastErrorMsg) >= errorMsgInterval {
+			f.logger.Error().Err(err).Int("count", f.errors).Msgf("error writing to %s", f.w.CurrentFileName())
+			f.lastErrorMsg = time.Now()
+			f.errors = 0
+		}
+		return n, nil
+	}
+	f.Written(n)
+	return n, nil
+}
+
+func (f *fileoutput) Close() error {
+	f.lock.Lock()
+	if f.closed {
+		f.lock.Unlock()
+		return nil
+	}
+	f.closed = true
+	err := f.w.Close()
+	f.lock.Unlock()
+	f.m.RemoveOutput(f)
+	return err
+}
+
+func (f *fileoutput) Count() int {
+	return 1
+}
+
+func (f *fileoutput) OutputIdentifier() string {
+	return f.output_identifier
+}
+
+func (f *fileoutput) String() string {
+	return f.name
+}
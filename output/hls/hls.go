@@ -0,0 +1,406 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/ts"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultName            = "hls"
+	defaultSegmentDuration = 4 * time.Second
+	defaultWindow          = 6
+	// segments kept after they left the playlist, for clients that are
+	// still fetching them
+	keepSegments = 2
+	// a segment is cut without a keyframe once it's this many times the
+	// target duration
+	forceCutFactor = 3
+
+	playlistName = "index.m3u8"
+)
+
+type segment struct {
+	seq      int
+	duration float64
+	// nil when the segment is written to disk
+	data []byte
+}
+
+type hlsoutput struct {
+	output.Tracker
+	lock              sync.Mutex
+	logger            zerolog.Logger
+	m                 *mainloop.Mainloop
+	output_identifier string
+	name              string
+	path              string
+	dir               string
+	target            time.Duration
+	window            int
+	closed            bool
+
+	segments  []*segment
+	seq       int
+	cur       []byte
+	started   bool
+	startTime time.Time
+	startPTS  int64
+	hasPTS    bool
+
+	patAsm  ts.SectionAssembler
+	pmtAsm  ts.SectionAssembler
+	pmtPID  int
+	cutPID  int
+	cutType uint8
+	// packets of the current PAT/PMT section, and the last complete ones
+	// that every segment starts with
+	patBuf []byte
+	pmtBuf []byte
+	pat    []byte
+	pmt    []byte
+}
+
+// ParseHLSOutput sets up an HLS segmenter. Segments and the playlist are
+// served by the application's http server, hls:///live is served as
+// /flows/FLOWID/live/index.m3u8. Supported params: segment (target duration
+// in seconds), window (segments in the playlist) and dir, which writes
+// segments and playlist to that directory instead of keeping them in memory.
+func ParseHLSOutput(u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop) (output.Output, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up hls output: %s", u.String())
+	name := strings.Trim(u.Path, "/")
+	if name == "" {
+		name = u.Host
+	}
+	if name == "" {
+		name = defaultName
+	}
+	out := &hlsoutput{
+		logger:            logging.Log.With().Str("module", "hls-output").Str("identifier", identifier).Str("output_identifier", output_identifier).Logger(),
+		m:                 m,
+		output_identifier: output_identifier,
+		name:              u.String(),
+		path:              path.Join(identifier, name) + "/",
+		target:            defaultSegmentDuration,
+		window:            defaultWindow,
+		pmtPID:            -1,
+		cutPID:            -1,
+	}
+	q := u.Query()
+	if s := q.Get("segment"); s != "" {
+		seconds, err := strconv.ParseFloat(s, 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid hls segment duration %q", s)
+		}
+		out.target = time.Duration(seconds * float64(time.Second))
+	}
+	if w := q.Get("window"); w != "" {
+		var err error
+		if out.window, err = strconv.Atoi(w); err != nil || out.window <= 0 {
+			return nil, fmt.Errorf("invalid hls window %q", w)
+		}
+	}
+	if dir := q.Get("dir"); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		out.dir = dir
+	}
+	if err := output.RegisterHTTP(out.path, http.HandlerFunc(out.serveHTTP)); err != nil {
+		return nil, err
+	}
+	m.AddOutput(out)
+	return out, nil
+}
+
+func (h *hlsoutput) Write(block *libristwrapper.RistDataBlock) (int, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return 0, nil
+	}
+	now := time.Now()
+	data := block.Data
+	for offset := 0; offset+ts.PacketSize <= len(data); offset += ts.PacketSize {
+		h.packet(data[offset:offset+ts.PacketSize], now)
+	}
+	h.Written(len(data))
+	return len(data), nil
+}
+
+func (h *hlsoutput) packet(p []byte, now time.Time) {
+	if p[0] != ts.SyncByte || ts.TransportError(p) {
+		return
+	}
+	pid := ts.PID(p)
+	switch {
+	case pid == ts.PATPID:
+		h.handlePAT(p)
+	case int(pid) == h.pmtPID:
+		h.handlePMT(p)
+	case int(pid) == h.cutPID && ts.PayloadUnitStart(p):
+		h.cutPoint(p, now)
+	}
+	if !h.started {
+		return
+	}
+	if now.Sub(h.startTime) >= forceCutFactor*h.target {
+		h.logger.Warn().Msgf("no keyframe within %s, cutting hls segment %d without one", forceCutFactor*h.target, h.seq)
+		h.finishSegment(now.Sub(h.startTime).Seconds(), now)
+		h.hasPTS = false
+	}
+	h.cur = append(h.cur, p...)
+}
+
+// collect appends p to the packets of the section being received.
+func collect(buf []byte, p []byte) []byte {
+	if ts.PayloadUnitStart(p) {
+		buf = buf[:0]
+	}
+	return append(buf, p...)
+}
+
+func (h *hlsoutput) handlePAT(p []byte) {
+	h.patBuf = collect(h.patBuf, p)
+	for _, section := range h.patAsm.Push(p) {
+		pat, err := ts.ParsePAT(section)
+		if err != nil {
+			continue
+		}
+		h.pat = append(h.pat[:0], h.patBuf...)
+		for _, prog := range pat.Programs {
+			if prog.Number != 0 {
+				if int(prog.PID) != h.pmtPID {
+					h.pmtPID = int(prog.PID)
+					h.pmtAsm.Reset()
+					h.pmt = h.pmt[:0]
+				}
+				break
+			}
+		}
+	}
+}
+
+func (h *hlsoutput) handlePMT(p []byte) {
+	h.pmtBuf = collect(h.pmtBuf, p)
+	for _, section := range h.pmtAsm.Push(p) {
+		pmt, err := ts.ParsePMT(section)
+		if err != nil || len(pmt.Streams) == 0 {
+			continue
+		}
+		h.pmt = append(h.pmt[:0], h.pmtBuf...)
+		cut := pmt.Streams[0]
+		for _, es := range pmt.Streams {
+			if ts.IsVideo(es.Type) {
+				cut = es
+				break
+			}
+		}
+		h.cutPID = int(cut.PID)
+		h.cutType = cut.Type
+	}
+}
+
+// cutPoint is called for every PES start on the PID segments are cut on,
+// segments start at a keyframe once the target duration was reached.
+func (h *hlsoutput) cutPoint(p []byte, now time.Time) {
+	if len(h.pat) == 0 || len(h.pmt) == 0 {
+		return
+	}
+	payload := ts.Payload(p)
+	if ts.IsVideo(h.cutType) && !ts.RandomAccess(p) && !ts.ContainsKeyframe(h.cutType, payload) {
+		return
+	}
+	pts, hasPTS := ts.PESPTS(payload)
+	if !h.started {
+		h.startSegment(now, pts, hasPTS)
+		return
+	}
+	duration := now.Sub(h.startTime).Seconds()
+	if hasPTS && h.hasPTS {
+		ptsDuration := float64((pts-h.startPTS+ts.PTSWrap)%ts.PTSWrap) / 90000
+		// a jump in PTS is a discontinuity, fall back to the wall clock
+		if ptsDuration < forceCutFactor*h.target.Seconds() {
+			duration = ptsDuration
+		}
+	}
+	if duration < h.target.Seconds() {
+		return
+	}
+	h.finishSegment(duration, now)
+	h.startPTS, h.hasPTS = pts, hasPTS
+}
+
+func (h *hlsoutput) startSegment(now time.Time, pts int64, hasPTS bool) {
+	h.started = true
+	h.startTime = now
+	h.startPTS, h.hasPTS = pts, hasPTS
+	h.cur = append(append(h.cur[:0], h.pat...), h.pmt...)
+}
+
+func (h *hlsoutput) finishSegment(duration float64, now time.Time) {
+	seg := &segment{seq: h.seq, duration: duration}
+	h.seq++
+	if h.dir != "" {
+		if err := writeFileAtomic(filepath.Join(h.dir, segmentName(seg.seq)), h.cur); err != nil {
+			h.Failed(err)
+			h.logger.Error().Err(err).Msgf("error writing hls segment %d", seg.seq)
+		}
+	} else {
+		seg.data = h.cur
+	}
+	h.segments = append(h.segments, seg)
+	if len(h.segments) > h.window+keepSegments {
+		old := h.segments[0]
+		h.segments = h.segments[1:]
+		if h.dir != "" {
+			os.Remove(filepath.Join(h.dir, segmentName(old.seq)))
+		}
+	}
+	if h.dir != "" {
+		if err := writeFileAtomic(filepath.Join(h.dir, playlistName), h.playlist()); err != nil {
+			h.Failed(err)
+			h.logger.Error().Err(err).Msg("error writing hls playlist")
+		}
+	}
+
+	next := make([]byte, 0, cap(h.cur))
+	h.cur = append(append(next, h.pat...), h.pmt...)
+	h.startTime = now
+}
+
+func segmentName(seq int) string {
+	return strconv.Itoa(seq) + ".ts"
+}
+
+// playlist returns the media playlist of the last window segments, caller
+// must hold lock.
+func (h *hlsoutput) playlist() []byte {
+	segments := h.segments
+	if len(segments) > h.window {
+		segments = segments[len(segments)-h.window:]
+	}
+	target := math.Ceil(h.target.Seconds())
+	for _, s := range segments {
+		target = math.Max(target, math.Ceil(s.duration))
+	}
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(target))
+	if len(segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].seq)
+	}
+	for _, s := range segments {
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration, segmentName(s.seq))
+	}
+	return b.Bytes()
+}
+
+func writeFileAtomic(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (h *hlsoutput) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	file := path.Base(r.URL.Path)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	if file == playlistName {
+		h.lock.Lock()
+		playlist := h.playlist()
+		h.lock.Unlock()
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(playlist)
+		return
+	}
+
+	seq, err := strconv.Atoi(strings.TrimSuffix(file, ".ts"))
+	if err != nil || !strings.HasSuffix(file, ".ts") {
+		http.NotFound(w, r)
+		return
+	}
+	var seg *segment
+	h.lock.Lock()
+	for _, s := range h.segments {
+		if s.seq == seq {
+			seg = s
+			break
+		}
+	}
+	h.lock.Unlock()
+	if seg == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "video/mp2t")
+	if seg.data == nil {
+		http.ServeFile(w, r, filepath.Join(h.dir, file))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(seg.data)))
+	_, _ = w.Write(seg.data)
+}
+
+func (h *hlsoutput) Close() error {
+	output.UnregisterHTTP(h.path)
+	h.lock.Lock()
+	h.closed = true
+	h.lock.Unlock()
+	h.m.RemoveOutput(h)
+	return nil
+}
+
+func (h *hlsoutput) Count() int {
+	return 1
+}
+
+func (h *hlsoutput) OutputIdentifier() string {
+	return h.output_identifier
+}
+
+func (h *hlsoutput) String() string {
+	return h.name
+}
@@ -0,0 +1,78 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package output
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPPathPrefix is the path outputs serving over the application's http
+// server are registered under.
+const HTTPPathPrefix = "/flows/"
+
+var (
+	httpLock     sync.Mutex
+	httpHandlers = make(map[string]http.Handler)
+)
+
+type connContextKey struct{}
+
+// HTTPConnContext should be set as ConnContext of the http server, it
+// makes the connection available to outputs via HTTPConn.
+func HTTPConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// HTTPConn returns the connection of a request, nil when the server doesn't
+// use HTTPConnContext.
+func HTTPConn(r *http.Request) net.Conn {
+	c, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	return c
+}
+
+// RegisterHTTP registers h for HTTPPathPrefix + path, a path ending in /
+// also serves all paths below it.
+func RegisterHTTP(path string, h http.Handler) error {
+	httpLock.Lock()
+	defer httpLock.Unlock()
+	if _, ok := httpHandlers[path]; ok {
+		return fmt.Errorf("http path %s%s already in use", HTTPPathPrefix, path)
+	}
+	httpHandlers[path] = h
+	return nil
+}
+
+// UnregisterHTTP removes the handler registered for path.
+func UnregisterHTTP(path string) {
+	httpLock.Lock()
+	delete(httpHandlers, path)
+	httpLock.Unlock()
+}
+
+// HTTPHandler serves all registered outputs, it should be registered for
+// HTTPPathPrefix.
+func HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, HTTPPathPrefix)
+		httpLock.Lock()
+		h, ok := httpHandlers[path]
+		for i := strings.LastIndex(path, "/"); !ok && i >= 0; i = strings.LastIndex(path[:i], "/") {
+			h, ok = httpHandlers[path[:i+1]]
+		}
+		httpLock.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
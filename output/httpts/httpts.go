@@ -0,0 +1,261 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package httpts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultName = "stream.ts"
+	// a client not accepting a block within this time is disconnected
+	writeTimeout = 5 * time.Second
+)
+
+var errClientGone = errors.New("http client disconnected")
+
+// httpoutput is the configured output, every connected client is added to
+// the mainloop as a separate output with its own queue.
+type httpoutput struct {
+	output.Tracker
+	ctx               context.Context
+	cancel            context.CancelFunc
+	logger            zerolog.Logger
+	m                 *mainloop.Mainloop
+	identifier        string
+	output_identifier string
+	name              string
+	path              string
+	maxClients        int
+	clientsLock       sync.Mutex
+	clients           map[*httpclient]bool
+}
+
+type httpclient struct {
+	parent  *httpoutput
+	ctx     context.Context
+	cancel  context.CancelFunc
+	lock    sync.Mutex
+	closed  bool
+	w       http.ResponseWriter
+	flusher http.Flusher
+	conn    net.Conn
+	remote  string
+	since   time.Time
+}
+
+// ParseHTTPOutput sets up an output served by the application's http server,
+// http:///stream.ts is served as /flows/FLOWID/stream.ts. The maxclients url
+// param limits the number of concurrent clients.
+func ParseHTTPOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop) (output.Output, error) {
+	logging.Log.Info().Str("identifier", identifier).Msgf("setting up http output: %s", u.String())
+	name := strings.Trim(u.Path, "/")
+	if name == "" {
+		name = u.Host
+	}
+	if name == "" {
+		name = defaultName
+	}
+	out := &httpoutput{
+		logger:            logging.Log.With().Str("module", "http-output").Str("identifier", identifier).Str("output_identifier", output_identifier).Logger(),
+		m:                 m,
+		identifier:        identifier,
+		output_identifier: output_identifier,
+		name:              u.String(),
+		path:              path.Join(identifier, name),
+		clients:           make(map[*httpclient]bool),
+	}
+	if mc := u.Query().Get("maxclients"); mc != "" {
+		var err error
+		if out.maxClients, err = strconv.Atoi(mc); err != nil || out.maxClients < 0 {
+			return nil, fmt.Errorf("invalid maxclients %q", mc)
+		}
+	}
+	out.ctx, out.cancel = context.WithCancel(ctx)
+	if err := output.RegisterHTTP(out.path, http.HandlerFunc(out.serveHTTP)); err != nil {
+		out.cancel()
+		return nil, err
+	}
+	return out, nil
+}
+
+func (h *httpoutput) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	c := &httpclient{
+		parent:  h,
+		w:       w,
+		flusher: flusher,
+		conn:    output.HTTPConn(r),
+		remote:  r.RemoteAddr,
+		since:   time.Now(),
+	}
+	c.ctx, c.cancel = context.WithCancel(h.ctx)
+	defer c.Close()
+	if !h.addClient(c) {
+		http.Error(w, "too many clients", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	h.logger.Info().Str("client", c.remote).Msgf("http client %s connected", c.remote)
+	h.m.AddOutput(c)
+	select {
+	case <-r.Context().Done():
+		h.m.RemoveOutput(c)
+	case <-c.ctx.Done():
+		// closed by the mainloop or the parent, which removed it
+	}
+	h.logger.Info().Str("client", c.remote).Msgf("http client %s disconnected", c.remote)
+}
+
+func (h *httpoutput) addClient(c *httpclient) bool {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	if h.ctx.Err() != nil || (h.maxClients > 0 && len(h.clients) >= h.maxClients) {
+		return false
+	}
+	h.clients[c] = true
+	return true
+}
+
+func (h *httpoutput) removeClient(c *httpclient) {
+	h.clientsLock.Lock()
+	delete(h.clients, c)
+	h.clientsLock.Unlock()
+}
+
+// Write is never called, the parent isn't added to the mainloop.
+func (h *httpoutput) Write(block *libristwrapper.RistDataBlock) (int, error) {
+	return 0, nil
+}
+
+func (h *httpoutput) Close() error {
+	output.UnregisterHTTP(h.path)
+	h.cancel()
+
+	// the client handlers return and close their clients
+	h.clientsLock.Lock()
+	clients := make([]*httpclient, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.clientsLock.Unlock()
+	for _, c := range clients {
+		h.m.RemoveOutput(c)
+	}
+	return nil
+}
+
+func (h *httpoutput) Count() int {
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	return len(h.clients)
+}
+
+// Status adds the connected clients to the tracked status.
+func (h *httpoutput) Status() output.Status {
+	status := h.Tracker.Status()
+	h.clientsLock.Lock()
+	defer h.clientsLock.Unlock()
+	for c := range h.clients {
+		status.Clients = append(status.Clients, output.ClientStatus{Remote: c.remote, ConnectedSince: c.since})
+	}
+	return status
+}
+
+func (h *httpoutput) OutputIdentifier() string {
+	return h.output_identifier
+}
+
+func (h *httpoutput) String() string {
+	return h.name
+}
+
+// Write sends the block to the client, once closed it returns an error so
+// the mainloop removes the client.
+func (c *httpclient) Write(block *libristwrapper.RistDataBlock) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return 0, errClientGone
+	}
+	// a stalled client must not block the write, and Close waiting for it
+	if c.conn != nil {
+		c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	}
+	n, err := c.w.Write(block.Data)
+	if err != nil {
+		// a client going away doesn't fail the output, the mainloop
+		// removes the client and the handler closes it
+		c.parent.logger.Info().Err(err).Str("client", c.remote).Msgf("write to http client %s failed", c.remote)
+		c.cancel()
+		return n, err
+	}
+	c.flusher.Flush()
+	c.parent.Written(n)
+	return n, nil
+}
+
+// Close is called when the client disconnects and by the mainloop on
+// sustained backpressure. It waits for a running Write, as the response
+// can't be written to once the handler returned.
+func (c *httpclient) Close() error {
+	c.cancel()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	c.parent.removeClient(c)
+	return nil
+}
+
+func (c *httpclient) Count() int {
+	return 1
+}
+
+func (c *httpclient) OutputIdentifier() string {
+	return c.parent.output_identifier
+}
+
+func (c *httpclient) String() string {
+	return c.parent.name + " client " + c.remote
+}
@@ -6,7 +6,16 @@
 
 package output
 
-import "code.videolan.org/rist/ristgo/libristwrapper"
+import (
+	"errors"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+)
+
+// ErrDropped is returned by outputs queueing blocks per client when every
+// client dropped the block, the mainloop counts it as dropped instead of
+// written.
+var ErrDropped = errors.New("block dropped by all clients")
 
 type Output interface {
 	Close() error
@@ -14,3 +23,9 @@ type Output interface {
 	String() string
 	Count() int
 }
+
+// Identifier is implemented by outputs that know the identifier they're
+// configured with, the mainloop uses it to apply per output settings.
+type Identifier interface {
+	OutputIdentifier() string
+}
@@ -0,0 +1,86 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package output
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DefaultReconnectPolicy is used for outputs without reconnect settings, it
+// retries forever.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     10 * time.Second,
+	Jitter:       0.2,
+}
+
+// ReconnectPolicy configures how outputs retry a lost connection. The delay
+// starts at InitialDelay and doubles after every failed attempt up to
+// MaxDelay, Jitter randomises each delay by that fraction. A MaxAttempts of 0
+// retries until the output is closed.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Jitter       float64
+	MaxAttempts  int
+}
+
+// Delay returns the delay before the given attempt, starting at 1.
+func (p ReconnectPolicy) Delay(attempt int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// Reconnect calls connect until it succeeds, waiting between attempts as
+// configured by the policy. It returns nil once connected, the context's
+// error when it's cancelled, or the last error when giving up, in which case
+// the output is marked failed.
+func Reconnect(ctx context.Context, p ReconnectPolicy, t *Tracker, logger zerolog.Logger, connect func() error) error {
+	delay := p.Delay(1)
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		err := connect()
+		if err == nil {
+			if attempt > 1 {
+				logger.Info().Msgf("reconnected after %d attempts", attempt)
+			}
+			return nil
+		}
+		t.Failed(err)
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			t.SetState(StateFailed)
+			logger.Error().Err(err).Msgf("giving up reconnecting after %d attempts", attempt)
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+		delay = p.Delay(attempt + 1)
+		if attempt == 1 {
+			logger.Info().Err(err).Msgf("reconnect failed, retrying in %s", delay.Round(time.Millisecond))
+		} else {
+			logger.Debug().Err(err).Int("attempt", attempt).Msgf("reconnect failed, retrying in %s", delay.Round(time.Millisecond))
+		}
+	}
+}
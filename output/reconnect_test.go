@@ -0,0 +1,116 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package output
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	p := ReconnectPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.Delay(tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: delay %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestReconnectPolicyJitter(t *testing.T) {
+	p := ReconnectPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.2}
+	for _, attempt := range []int{1, 3, 10} {
+		base := ReconnectPolicy{InitialDelay: p.InitialDelay, MaxDelay: p.MaxDelay}.Delay(attempt)
+		min, max := base-base/5, base+base/5
+		varied := false
+		for i := 0; i < 100; i++ {
+			d := p.Delay(attempt)
+			if d < min || d > max {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", attempt, d, min, max)
+			}
+			varied = varied || d != base
+		}
+		if !varied {
+			t.Fatalf("attempt %d: no jitter", attempt)
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	errConnect := errors.New("connect failed")
+	policy := ReconnectPolicy{InitialDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond}
+	tests := []struct {
+		name        string
+		maxAttempts int
+		// attempts failing before connect succeeds, -1 always fails
+		failures int
+		attempts int
+		err      bool
+		state    string
+	}{
+		{"first attempt", 0, 0, 1, false, StateConnecting},
+		{"after failures", 0, 5, 6, false, StateConnecting},
+		{"within the limit", 3, 2, 3, false, StateConnecting},
+		{"give up", 3, -1, 3, true, StateFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := policy
+			p.MaxAttempts = tt.maxAttempts
+			var tr Tracker
+			tr.SetState(StateConnecting)
+			attempts := 0
+			err := Reconnect(context.Background(), p, &tr, zerolog.Nop(), func() error {
+				attempts++
+				if tt.failures < 0 || attempts <= tt.failures {
+					return errConnect
+				}
+				return nil
+			})
+			if attempts != tt.attempts {
+				t.Fatalf("%d attempts, want %d", attempts, tt.attempts)
+			}
+			if (err != nil) != tt.err || (err != nil && !errors.Is(err, errConnect)) {
+				t.Fatalf("error %v, want error %v", err, tt.err)
+			}
+			if st := tr.Status(); st.State != tt.state {
+				t.Fatalf("state %s, want %s", st.State, tt.state)
+			}
+		})
+	}
+}
+
+func TestReconnectCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := ReconnectPolicy{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	var tr Tracker
+	attempts := 0
+	err := Reconnect(ctx, p, &tr, zerolog.Nop(), func() error {
+		attempts++
+		if attempts == 3 {
+			cancel()
+		}
+		return errors.New("connect failed")
+	})
+	if err != context.Canceled || attempts != 3 {
+		t.Fatalf("%d attempts, %v, want 3, %v", attempts, err, context.Canceled)
+	}
+}
@@ -8,33 +8,232 @@ package rist
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 
 	"code.videolan.org/rist/ristgo"
 	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/logging"
 	"github.com/EmadHeravi/streamsow/mainloop"
 	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/sanitise"
 	"github.com/EmadHeravi/streamsow/stats"
 )
 
+// defaultBufferSize is the sender recovery buffer size in ms used when the
+// url doesn't specify one.
+const defaultBufferSize = 1000
+
 type ristoutput struct {
-	sender    ristgo.Sender
-	clientUrl string
+	output.Tracker
+	ctx               context.Context
+	cancel            context.CancelFunc
+	identifier        string
+	output_identifier string
+	clientUrl         string
+	url               *url.URL
+	m                 *mainloop.Mainloop
+	config            ristgo.SenderConfig
+	policy            output.ReconnectPolicy
+	lock              sync.Mutex
+	sender            ristgo.Sender
+	peers             []int
+}
+
+func createStatsCB(s *stats.Stats, output_identifier string, u *url.URL) libristwrapper.StatsCallbackFunc {
+	return func(stats *libristwrapper.StatsContainer) {
+		if stats.SenderStats != nil {
+			s.HandleStats("", output_identifier, u, stats.SenderStats)
+		}
+	}
+}
+
+func createLogCB(identifier, output_identifier string) libristwrapper.LogCallbackFunc {
+	logger := logging.Log.With().Str("module", "rist-output").Str("identifier", identifier).Str("output_identifier", output_identifier).Logger()
+	return func(loglevel libristwrapper.RistLogLevel, logmessage string) {
+		logmessage = strings.TrimSuffix(logmessage, "\n")
+		switch loglevel {
+		case libristwrapper.LogLevelError:
+			logger.Error().Msg(logmessage)
+		case libristwrapper.LogLevelWarn:
+			logger.Warn().Msg(logmessage)
+		case libristwrapper.LogLevelNotice:
+			logger.Info().Msg(logmessage)
+		case libristwrapper.LogLevelInfo:
+			logger.Info().Msg(logmessage)
+		case libristwrapper.LogLevelDebug:
+			logger.Debug().Msg(logmessage)
+		}
+	}
+}
+
+func parseProfile(p string) (libristwrapper.RistProfile, error) {
+	switch strings.ToLower(p) {
+	case "", "0", "simple":
+		return libristwrapper.RistProfileSimple, nil
+	case "1", "main":
+		return libristwrapper.RistProfileMain, nil
+	}
+	return 0, fmt.Errorf("unsupported rist profile: %s", p)
+}
+
+// peerURLs returns the url for every peer of the output. Additional peers
+// are given as peer=host:port url params and share all other params with
+// the primary peer.
+func peerURLs(u *url.URL) []*url.URL {
+	q := u.Query()
+	extra := q["peer"]
+	q.Del("peer")
+	q.Del("profile")
+	q.Del("identifier")
+
+	primary := *u
+	primary.RawQuery = q.Encode()
+	peers := []*url.URL{&primary}
+	for _, host := range extra {
+		p := primary
+		p.Host = host
+		peers = append(peers, &p)
+	}
+	return peers
 }
 
-func ParseRistOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, stats *stats.Stats, wait *sync.WaitGroup) (output.Output, error) {
+// ParseRistOutput sets up a RIST sender output, sending to one or more
+// peers. The rist profile is selected via the profile url param (simple or
+// main), encryption (secret, aes-type) is only supported with main profile.
+func ParseRistOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, s *stats.Stats, wait *sync.WaitGroup, policy output.ReconnectPolicy) (output.Output, error) {
+	sanitised := sanitise.URL(u)
+	logging.Log.Info().
+		Str("identifier", identifier).
+		Msgf("setting up rist output: %s", sanitised)
 
-	return &ristoutput{}, nil
+	profile, err := parseProfile(u.Query().Get("profile"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Query().Get("secret") != "" && profile == libristwrapper.RistProfileSimple {
+		return nil, errors.New("rist encryption requires main profile")
+	}
+	bufferSize := defaultBufferSize
+	if b := u.Query().Get("buffer"); b != "" {
+		if bufferSize, err = strconv.Atoi(b); err != nil {
+			return nil, fmt.Errorf("invalid rist buffer size %q: %w", b, err)
+		}
+	}
+
+	out := &ristoutput{
+		identifier:        identifier,
+		output_identifier: output_identifier,
+		clientUrl:         sanitised.String(),
+		url:               u,
+		m:                 m,
+		policy:            policy,
+		config: ristgo.SenderConfig{
+			RistProfile:             profile,
+			LoggingCallbackFunction: createLogCB(identifier, output_identifier),
+			StatsCallbackFunction:   createStatsCB(s, output_identifier, sanitised),
+			StatsInterval:           stats.StatsIntervalSeconds * 1000,
+			RecoveryBufferSize:      bufferSize,
+		},
+	}
+	out.ctx, out.cancel = context.WithCancel(ctx)
+	if err := out.start(); err != nil {
+		out.cancel()
+		return nil, err
+	}
+
+	wait.Add(1)
+	go func() {
+		<-out.ctx.Done()
+		out.lock.Lock()
+		if out.sender != nil {
+			out.sender.Close()
+			out.sender = nil
+		}
+		out.lock.Unlock()
+		wait.Done()
+	}()
+
+	m.AddOutput(out)
+	return out, nil
+}
+
+// start creates the sender and adds the peers.
+func (r *ristoutput) start() error {
+	sender, err := ristgo.SenderCreate(r.ctx, &r.config)
+	if err != nil {
+		return err
+	}
+	urls := peerURLs(r.url)
+	peers := make([]int, 0, len(urls))
+	for _, p := range urls {
+		peerConfig, err := ristgo.ParseRistURL(p)
+		if err != nil {
+			sender.Close()
+			return err
+		}
+		id, err := sender.AddPeer(peerConfig)
+		if err != nil {
+			sender.Close()
+			return fmt.Errorf("couldn't add rist peer %s: %w", p.Host, err)
+		}
+		peers = append(peers, id)
+	}
+	if err := sender.Start(); err != nil {
+		sender.Close()
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.ctx.Err() != nil {
+		sender.Close()
+		return r.ctx.Err()
+	}
+	r.sender = sender
+	r.peers = peers
+	return nil
+}
+
+// reconnect recreates the sender with backoff after a write error, the
+// output is added back to the mainloop once it's running.
+func (r *ristoutput) reconnect() {
+	r.lock.Lock()
+	if r.sender != nil {
+		r.sender.Close()
+		r.sender = nil
+	}
+	r.lock.Unlock()
+	r.SetState(output.StateConnecting)
+	logger := logging.Log.With().
+		Str("identifier", r.identifier).
+		Str("output_identifier", r.output_identifier).
+		Str("rist-url", r.clientUrl).
+		Logger()
+	if err := output.Reconnect(r.ctx, r.policy, &r.Tracker, logger, r.start); err != nil {
+		return
+	}
+	logger.Info().Msgf("rist output %s running again", r.clientUrl)
+	r.SetState(output.StateActive)
+	r.m.AddOutput(r)
 }
 
 func (r *ristoutput) Close() error {
-	r.sender.Close()
+	r.cancel()
 	return nil
 }
 
 func (r *ristoutput) Count() int {
-	return 1
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.peers)
+}
+
+func (r *ristoutput) OutputIdentifier() string {
+	return r.output_identifier
 }
 
 func (r *ristoutput) String() string {
@@ -42,5 +241,29 @@ func (r *ristoutput) String() string {
 }
 
 func (r *ristoutput) Write(block *libristwrapper.RistDataBlock) (n int, e error) {
-	return r.sender.Write(block.Data)
+	select {
+	case <-r.ctx.Done():
+		return 0, errors.New("output stopped")
+	default:
+		//
+	}
+	r.lock.Lock()
+	sender := r.sender
+	r.lock.Unlock()
+	if sender == nil {
+		return 0, errors.New("output stopped")
+	}
+	n, e = sender.Write(block.Data)
+	if e != nil {
+		r.Failed(e)
+		logging.Log.Error().
+			Str("identifier", r.identifier).
+			Str("output_identifier", r.output_identifier).
+			Err(e).
+			Msgf("error sending data to rist output %s", r.clientUrl)
+		go r.reconnect()
+		return
+	}
+	r.Written(n)
+	return
 }
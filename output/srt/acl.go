@@ -0,0 +1,135 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srt
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// url params configuring the acl, they're not passed to SRT
+var aclParams = []string{"allow", "deny", "allowstreamid", "streamidregex", "maxclients"}
+
+type rejectReason string
+
+const (
+	rejectDenied     rejectReason = "source address denied"
+	rejectNotAllowed rejectReason = "source address not allowed"
+	rejectStreamID   rejectReason = "stream id not allowed"
+	rejectMaxClients rejectReason = "max clients reached"
+)
+
+// acl decides which callers a listener output accepts. Denied networks take
+// precedence over allowed ones, an empty allow list allows all addresses and
+// stream ids are only checked when a list or regex is configured.
+type acl struct {
+	allow         []*net.IPNet
+	deny          []*net.IPNet
+	streamIDs     map[string]bool
+	streamIDRegex *regexp.Regexp
+	maxClients    int
+}
+
+// values returns the values of a repeatable, comma separated url param.
+func values(q url.Values, key string) []string {
+	var out []string
+	for _, v := range q[key] {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// parseNetworks parses CIDRs, a plain address is a single host network.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", s)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func parseACL(q url.Values) (*acl, error) {
+	a := &acl{}
+	var err error
+	if a.allow, err = parseNetworks(values(q, "allow")); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseNetworks(values(q, "deny")); err != nil {
+		return nil, err
+	}
+	if ids := values(q, "allowstreamid"); len(ids) > 0 {
+		a.streamIDs = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			a.streamIDs[id] = true
+		}
+	}
+	if re := q.Get("streamidregex"); re != "" {
+		if a.streamIDRegex, err = regexp.Compile(re); err != nil {
+			return nil, fmt.Errorf("invalid streamidregex: %w", err)
+		}
+	}
+	if mc := q.Get("maxclients"); mc != "" {
+		if a.maxClients, err = strconv.Atoi(mc); err != nil || a.maxClients < 0 {
+			return nil, fmt.Errorf("invalid maxclients %q", mc)
+		}
+	}
+	return a, nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns why a caller is rejected, or an empty reason when it's
+// accepted.
+func (a *acl) check(ip net.IP, streamid string, clients int) rejectReason {
+	if contains(a.deny, ip) {
+		return rejectDenied
+	}
+	if len(a.allow) > 0 && !contains(a.allow, ip) {
+		return rejectNotAllowed
+	}
+	if a.streamIDs != nil || a.streamIDRegex != nil {
+		if !a.streamIDs[streamid] && (a.streamIDRegex == nil || !a.streamIDRegex.MatchString(streamid)) {
+			return rejectStreamID
+		}
+	}
+	if a.maxClients > 0 && clients >= a.maxClients {
+		return rejectMaxClients
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srt
+
+import (
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		err   bool
+	}{
+		{"empty", "", false},
+		{"networks", "allow=10.0.0.0/8,192.168.1.1&allow=::1&deny=10.1.0.0/16", false},
+		{"stream ids", "allowstreamid=a,b&streamidregex=^live/", false},
+		{"max clients", "maxclients=5", false},
+		{"invalid address", "allow=10.0.0.256", true},
+		{"invalid network", "deny=10.0.0.0/33", true},
+		{"invalid regex", "streamidregex=(", true},
+		{"invalid max clients", "maxclients=five", true},
+		{"negative max clients", "maxclients=-1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parseACL(q); (err != nil) != tt.err {
+				t.Fatalf("error %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestACLCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		ip       string
+		streamid string
+		clients  int
+		want     rejectReason
+	}{
+		{"empty acl", "", "192.0.2.1", "", 100, ""},
+		{"allowed network", "allow=10.0.0.0/8", "10.1.2.3", "", 0, ""},
+		{"not allowed network", "allow=10.0.0.0/8", "192.0.2.1", "", 0, rejectNotAllowed},
+		{"allowed host", "allow=192.0.2.1", "192.0.2.1", "", 0, ""},
+		{"other host", "allow=192.0.2.1", "192.0.2.2", "", 0, rejectNotAllowed},
+		{"denied network", "deny=10.1.0.0/16", "10.1.2.3", "", 0, rejectDenied},
+		{"deny takes precedence", "allow=10.0.0.0/8&deny=10.1.0.0/16", "10.1.2.3", "", 0, rejectDenied},
+		{"allowed next to denied", "allow=10.0.0.0/8&deny=10.1.0.0/16", "10.2.0.1", "", 0, ""},
+		{"ipv6", "allow=2001:db8::/32", "2001:db8::1", "", 0, ""},
+		{"ipv4 mapped", "allow=192.0.2.0/24", "::ffff:192.0.2.1", "", 0, ""},
+		{"stream id list", "allowstreamid=a,b", "192.0.2.1", "b", 0, ""},
+		{"stream id not listed", "allowstreamid=a,b", "192.0.2.1", "c", 0, rejectStreamID},
+		{"empty stream id", "allowstreamid=a", "192.0.2.1", "", 0, rejectStreamID},
+		{"stream id regex", "streamidregex=^live/", "192.0.2.1", "live/1", 0, ""},
+		{"stream id regex mismatch", "streamidregex=^live/", "192.0.2.1", "vod/1", 0, rejectStreamID},
+		{"stream id list or regex", "allowstreamid=a&streamidregex=^live/", "192.0.2.1", "a", 0, ""},
+		{"below max clients", "maxclients=2", "192.0.2.1", "", 1, ""},
+		{"max clients", "maxclients=2", "192.0.2.1", "", 2, rejectMaxClients},
+		{"unlimited clients", "maxclients=0", "192.0.2.1", "", 1000, ""},
+		{"address before max clients", "allow=10.0.0.0/8&maxclients=1", "192.0.2.1", "", 1, rejectNotAllowed},
+		{"stream id before max clients", "allowstreamid=a&maxclients=1", "192.0.2.1", "b", 1, rejectStreamID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, err := parseACL(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := a.check(net.ParseIP(tt.ip), tt.streamid, tt.clients); got != tt.want {
+				t.Fatalf("rejected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/output/srt/srtstats"
+	srtwrap "github.com/EmadHeravi/streamsow/srt"
+	"github.com/EmadHeravi/streamsow/stats"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultWorkers = 4
+	epollTimeout   = 100 * time.Millisecond
+	epollEvents    = 256
+	workQueue      = 1024
+	// how long a caller let through by the acl counts as a client before
+	// it's added to the pool
+	pendingTimeout = 5 * time.Second
+)
+
+// srtclient is a client of a listener output. Blocks are queued per client
+// and sent by the pool's workers without blocking, a client with a full send
+// buffer waits for epoll to report it writable.
+type srtclient struct {
+	pool      *clientpool
+	srt       *srtwrap.Socket
+	id        srtwrap.SocketID
+	host      string
+	remote    string
+	since     time.Time
+	lock      sync.Mutex
+	queue     []*libristwrapper.RistDataBlock
+	scheduled bool
+	waiting   bool
+	closed    bool
+	fullSince time.Time
+	// dropped since the last stats interval, and totals
+	dropped       int
+	droppedBlocks int64
+	droppedBytes  int64
+}
+
+// clientpool serves all clients of a listener output from a fixed number of
+// workers, instead of a mainloop output and stats goroutine per client.
+type clientpool struct {
+	// dropped by all clients, updated atomically
+	droppedBlocks int64
+	droppedBytes  int64
+
+	ctx      context.Context
+	parent   *srtoutput
+	logger   zerolog.Logger
+	eid      srtwrap.EpollID
+	settings mainloop.OutputSettings
+	acl      *acl
+	work     chan *srtclient
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+	clients  map[srtwrap.SocketID]*srtclient
+	// callers let through by the acl that aren't added yet, by remote
+	pending  map[string]time.Time
+	counters srtstats.ListenerStats
+}
+
+// newClientPool starts the pool's goroutines, they stop when s.ctx is
+// cancelled.
+func newClientPool(s *srtoutput, workers int) (*clientpool, error) {
+	eid, err := srtwrap.EpollCreate()
+	if err != nil {
+		return nil, err
+	}
+	p := &clientpool{
+		ctx:    s.ctx,
+		parent: s,
+		logger: logger.With().
+			Str("identifier", s.identifier).
+			Str("output_identifier", s.output_identifier).
+			Str("srt-url", s.SanitisedURL.String()).
+			Logger(),
+		eid:      eid,
+		settings: s.m.OutputSettings(s.output_identifier),
+		acl:      s.acl,
+		work:     make(chan *srtclient, workQueue),
+		clients:  make(map[srtwrap.SocketID]*srtclient),
+		pending:  make(map[string]time.Time),
+	}
+	p.wg.Add(workers + 2)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	go p.epollLoop()
+	go p.statsLoop()
+	return p, nil
+}
+
+func (p *clientpool) add(s *srtwrap.Socket, addr *net.UDPAddr) error {
+	c := &srtclient{
+		pool:   p,
+		srt:    s,
+		id:     s.ID(),
+		host:   addr.IP.String(),
+		remote: addr.String(),
+		since:  time.Now(),
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := srtwrap.EpollAdd(p.eid, s, srtwrap.EpollErr); err != nil {
+		return err
+	}
+	p.clients[c.id] = c
+	delete(p.pending, c.remote)
+	p.counters.Accepted++
+	return nil
+}
+
+// accept is the listen callback, it applies the acl to a caller.
+func (p *clientpool) accept(addr *net.UDPAddr, streamid string) bool {
+	p.lock.Lock()
+	reason := p.acl.check(addr.IP, streamid, p.usedLocked(time.Now()))
+	countRejection(&p.counters, reason)
+	if reason == "" {
+		p.pending[addr.String()] = time.Now()
+	}
+	p.lock.Unlock()
+	if reason != "" {
+		logRejection(p.logger, addr, streamid, reason)
+		return false
+	}
+	return true
+}
+
+// usedLocked returns the clients plus the pending callers, maxclients
+// applies to both. Caller must hold p.lock.
+func (p *clientpool) usedLocked(now time.Time) int {
+	for remote, since := range p.pending {
+		if now.Sub(since) > pendingTimeout {
+			delete(p.pending, remote)
+		}
+	}
+	return len(p.clients) + len(p.pending)
+}
+
+// used returns the clients plus the pending callers.
+func (p *clientpool) used() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.usedLocked(time.Now())
+}
+
+// reserve counts a caller let through by the global listener's acl as a
+// client until it's added.
+func (p *clientpool) reserve(addr *net.UDPAddr) {
+	p.lock.Lock()
+	p.pending[addr.String()] = time.Now()
+	p.lock.Unlock()
+}
+
+// reject counts a caller rejected by the global listener's acl.
+func (p *clientpool) reject(reason rejectReason) {
+	p.lock.Lock()
+	countRejection(&p.counters, reason)
+	p.lock.Unlock()
+}
+
+// countRejection counts a rejection by the acl in st.
+func countRejection(st *srtstats.ListenerStats, reason rejectReason) {
+	if reason == "" {
+		return
+	}
+	st.Rejected++
+	switch reason {
+	case rejectDenied:
+		st.RejectedDenied++
+	case rejectNotAllowed:
+		st.RejectedNotAllowed++
+	case rejectStreamID:
+		st.RejectedStreamID++
+	case rejectMaxClients:
+		st.RejectedMaxClients++
+	}
+}
+
+func logRejection(logger zerolog.Logger, addr *net.UDPAddr, streamid string, reason rejectReason) {
+	logger.Warn().
+		Str("client", addr.String()).
+		Str("streamid", streamid).
+		Str("reason", string(reason)).
+		Msgf("rejected SRT caller %s: %s", addr.String(), reason)
+}
+
+func (p *clientpool) listenerStats() *srtstats.ListenerStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	st := p.counters
+	st.Clients = len(p.clients)
+	st.DroppedBlocks = atomic.LoadInt64(&p.droppedBlocks)
+	st.DroppedBytes = atomic.LoadInt64(&p.droppedBytes)
+	return &st
+}
+
+func (p *clientpool) count() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.clients)
+}
+
+func (p *clientpool) clientStatus() []output.ClientStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	clients := make([]output.ClientStatus, 0, len(p.clients))
+	for _, c := range p.clients {
+		blocks, bytes := c.droppedTotals()
+		clients = append(clients, output.ClientStatus{
+			Remote:         c.remote,
+			ConnectedSince: c.since,
+			DroppedBlocks:  blocks,
+			DroppedBytes:   bytes,
+		})
+	}
+	return clients
+}
+
+func (p *clientpool) snapshot() []*srtclient {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	clients := make([]*srtclient, 0, len(p.clients))
+	for _, c := range p.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// write queues the block for every client, it returns false when there are
+// clients and all of them dropped the block.
+func (p *clientpool) write(block *libristwrapper.RistDataBlock) bool {
+	now := time.Now()
+	clients := p.snapshot()
+	queued := len(clients) == 0
+	for _, c := range clients {
+		ok, schedule, disconnect := c.enqueue(block, now)
+		queued = queued || ok
+		if disconnect {
+			p.logger.Error().
+				Str("client", c.host).
+				Msgf("SRT client %s under sustained backpressure for %s, disconnecting", c.host, p.settings.DisconnectAfter)
+			p.remove(c)
+			continue
+		}
+		if schedule {
+			p.schedule(c)
+		}
+	}
+	if !queued {
+		atomic.AddInt64(&p.droppedBlocks, 1)
+		atomic.AddInt64(&p.droppedBytes, int64(len(block.Data)))
+	}
+	return queued
+}
+
+func (p *clientpool) schedule(c *srtclient) {
+	select {
+	case p.work <- c:
+	case <-p.ctx.Done():
+	}
+}
+
+// remove closes the client and removes it from the pool.
+func (p *clientpool) remove(c *srtclient) {
+	p.lock.Lock()
+	delete(p.clients, c.id)
+	p.lock.Unlock()
+	if c.close() {
+		p.logger.Info().Str("client", c.host).Msgf("SRT client %s disconnected", c.host)
+	}
+}
+
+func (p *clientpool) worker() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case c := <-p.work:
+			if err := c.flush(); err != nil {
+				p.logger.Info().Str("client", c.host).Err(err).Msgf("error sending data to SRT client %s", c.host)
+				p.remove(c)
+			}
+		}
+	}
+}
+
+// epollLoop waits for clients with a full send buffer to become writable and
+// removes clients as soon as SRT reports their connection broken.
+func (p *clientpool) epollLoop() {
+	defer p.wg.Done()
+	ready := make([]srtwrap.EpollReady, epollEvents)
+	for p.ctx.Err() == nil {
+		n, err := srtwrap.EpollWait(p.eid, ready, epollTimeout)
+		if err != nil {
+			p.logger.Error().Err(err).Msg("error in srt epoll wait")
+			time.Sleep(epollTimeout)
+			continue
+		}
+		for _, ev := range ready[:n] {
+			p.lock.Lock()
+			c, ok := p.clients[ev.ID]
+			p.lock.Unlock()
+			if !ok {
+				continue
+			}
+			if ev.Events&srtwrap.EpollErr != 0 {
+				p.remove(c)
+				continue
+			}
+			if ev.Events&srtwrap.EpollOut != 0 && c.writable() {
+				p.schedule(c)
+			}
+		}
+	}
+}
+
+func (p *clientpool) statsLoop() {
+	defer p.wg.Done()
+	s := p.parent
+	ticker := time.NewTicker(time.Duration(stats.StatsIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, c := range p.snapshot() {
+			statsVal, err := c.srt.Stats()
+			if err != nil {
+				if !errors.Is(err, srtwrap.SRTErrno(srtwrap.ErrNoConn)) && !errors.Is(err, srtwrap.SRTErrno(srtwrap.ErrInvSock)) {
+					p.logger.Error().Str("client", c.host).Err(err).Msg("error in srt statsloop")
+				}
+				p.remove(c)
+				continue
+			}
+			if dropped := c.takeDropped(); dropped > 0 {
+				p.logger.Warn().Str("client", c.host).Msgf("dropped %d blocks for SRT client %s", dropped, c.host)
+			}
+			go s.stats.HandleStats(c.host, s.output_identifier, s.SanitisedURL, statsVal)
+		}
+		go s.stats.HandleStats("", s.output_identifier, s.SanitisedURL, p.listenerStats())
+	}
+}
+
+// close closes all clients once the pool's goroutines stopped, the context
+// must be cancelled first.
+func (p *clientpool) close() {
+	p.wg.Wait()
+	for _, c := range p.snapshot() {
+		p.remove(c)
+	}
+	srtwrap.EpollRelease(p.eid)
+}
+
+// enqueue queues block applying the output's backpressure policy, it returns
+// whether the block was queued, whether the client should be scheduled and
+// whether it should be disconnected.
+func (c *srtclient) enqueue(block *libristwrapper.RistDataBlock, now time.Time) (queued, schedule, disconnect bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false, false, false
+	}
+	if len(c.queue) >= c.pool.settings.QueueDepth {
+		switch c.pool.settings.Policy {
+		case mainloop.DropOldest:
+			c.drop(c.queue[0])
+			c.queue[0].Return()
+			c.queue = c.queue[1:]
+		case mainloop.Disconnect:
+			c.drop(block)
+			if c.fullSince.IsZero() {
+				c.fullSince = now
+			}
+			return false, false, now.Sub(c.fullSince) >= c.pool.settings.DisconnectAfter
+		default:
+			c.drop(block)
+			return false, false, false
+		}
+	} else {
+		c.fullSince = time.Time{}
+	}
+	block.Increment()
+	c.queue = append(c.queue, block)
+	if c.scheduled || c.waiting {
+		return true, false, false
+	}
+	c.scheduled = true
+	return true, true, false
+}
+
+// drop counts a block dropped for the client. Caller must hold c.lock.
+func (c *srtclient) drop(block *libristwrapper.RistDataBlock) {
+	c.dropped++
+	c.droppedBlocks++
+	c.droppedBytes += int64(len(block.Data))
+}
+
+// flush sends the queued blocks until the send buffer is full.
+func (c *srtclient) flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.scheduled = false
+	if c.closed {
+		return nil
+	}
+	for len(c.queue) > 0 {
+		block := c.queue[0]
+		_, err := c.srt.TrySend(block.Data)
+		if errors.Is(err, srtwrap.ErrAsyncSend) {
+			c.waiting = true
+			return srtwrap.EpollUpdate(c.pool.eid, c.srt, srtwrap.EpollOut|srtwrap.EpollErr)
+		}
+		if err != nil {
+			return err
+		}
+		block.Return()
+		c.queue[0] = nil
+		c.queue = c.queue[1:]
+	}
+	return nil
+}
+
+// writable is called when epoll reports the client writable, it returns
+// whether the client should be scheduled.
+func (c *srtclient) writable() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed || !c.waiting {
+		return false
+	}
+	c.waiting = false
+	srtwrap.EpollUpdate(c.pool.eid, c.srt, srtwrap.EpollErr)
+	if c.scheduled || len(c.queue) == 0 {
+		return false
+	}
+	c.scheduled = true
+	return true
+}
+
+// droppedTotals returns the blocks and bytes dropped for the client.
+func (c *srtclient) droppedTotals() (blocks, bytes int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.droppedBlocks, c.droppedBytes
+}
+
+func (c *srtclient) takeDropped() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	n := c.dropped
+	c.dropped = 0
+	return n
+}
+
+// close returns the queued blocks and closes the socket, it returns false
+// when the client was already closed.
+func (c *srtclient) close() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	for _, block := range c.queue {
+		block.Return()
+	}
+	c.queue = nil
+	c.srt.Close()
+	return true
+}
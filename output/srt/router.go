@@ -0,0 +1,343 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EmadHeravi/streamsow/mainloop"
+	"github.com/EmadHeravi/streamsow/output/srt/srtstats"
+	"github.com/EmadHeravi/streamsow/sanitise"
+	srtwrap "github.com/EmadHeravi/streamsow/srt"
+	"github.com/EmadHeravi/streamsow/stats"
+	"github.com/rs/zerolog"
+)
+
+// ListenerOutputIdentifier is the output identifier callers of the global
+// listener are added to their flow with.
+const ListenerOutputIdentifier = "srtlistener"
+
+// prefix of the SRT access control stream id syntax, #!::r=FLOWID,u=user
+const streamIDPrefix = "#!::"
+
+// routedflow is a flow reachable through the global listener, its output
+// is created when the first caller connects.
+type routedflow struct {
+	ctx   context.Context
+	m     *mainloop.Mainloop
+	stats *stats.Stats
+	out   *srtoutput
+}
+
+// listener is the global SRT listener, it routes callers to flows by the
+// resource name in their stream id.
+type listener struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	logger       zerolog.Logger
+	url          *url.URL
+	sanitisedURL *url.URL
+	srt          *srtwrap.Socket
+	timeout      int
+	workers      int
+	acl          *acl
+	stats        *stats.Stats
+	rejectedFlow int
+	// rejections by the acl of callers of flows without clients
+	rejected srtstats.ListenerStats
+	done     chan struct{}
+}
+
+var (
+	routerLock sync.Mutex
+	routes     = make(map[string]*routedflow)
+	global     *listener
+)
+
+// FlowFromStreamID returns the flow a stream id refers to, the r key of the
+// SRT access control syntax or else the complete stream id.
+func FlowFromStreamID(streamid string) string {
+	if !strings.HasPrefix(streamid, streamIDPrefix) {
+		return streamid
+	}
+	for _, kv := range strings.Split(strings.TrimPrefix(streamid, streamIDPrefix), ",") {
+		if strings.HasPrefix(kv, "r=") {
+			return strings.TrimPrefix(kv, "r=")
+		}
+	}
+	return ""
+}
+
+// RegisterFlow makes a flow reachable through the global listener.
+func RegisterFlow(ctx context.Context, identifier string, m *mainloop.Mainloop, st *stats.Stats) {
+	routerLock.Lock()
+	defer routerLock.Unlock()
+	routes[identifier] = &routedflow{ctx: ctx, m: m, stats: st}
+}
+
+// UnregisterFlow disconnects the flow's callers and rejects new ones.
+func UnregisterFlow(identifier string) {
+	routerLock.Lock()
+	var out *srtoutput
+	if rf, ok := routes[identifier]; ok {
+		out = rf.out
+		delete(routes, identifier)
+	}
+	routerLock.Unlock()
+	if out != nil {
+		out.Close()
+	}
+}
+
+// unroute detaches a closed output from its flow, the next caller sets up a
+// new one.
+func unroute(s *srtoutput) {
+	routerLock.Lock()
+	if rf, ok := routes[s.identifier]; ok && rf.out == s {
+		rf.out = nil
+	}
+	routerLock.Unlock()
+}
+
+// StartListener starts the global listener, replacing a running one.
+func StartListener(ctx context.Context, u *url.URL) error {
+	StopListener()
+
+	if q := u.Query(); q.Get("mode") == "" {
+		q.Set("mode", "listener")
+		listen := *u
+		listen.RawQuery = q.Encode()
+		u = &listen
+	}
+	l := &listener{
+		url:          u,
+		sanitisedURL: sanitise.URL(u),
+		workers:      defaultWorkers,
+		done:         make(chan struct{}),
+	}
+	l.logger = logger.With().Str("srt-url", l.sanitisedURL.String()).Logger()
+	l.logger.Info().Msgf("setting up global srt listener: %s", l.sanitisedURL)
+	q := u.Query()
+	l.timeout, _ = strconv.Atoi(q.Get("timeout"))
+	if w := q.Get("workers"); w != "" {
+		var err error
+		if l.workers, err = strconv.Atoi(w); err != nil || l.workers <= 0 {
+			return fmt.Errorf("invalid workers %q", w)
+		}
+	}
+	var err error
+	if l.acl, err = parseACL(q); err != nil {
+		return err
+	}
+	if l.stats, err = stats.SetupStats(false, ListenerOutputIdentifier, ""); err != nil {
+		return err
+	}
+	socket, _, err := newSocket(u, l.timeout)
+	if err != nil {
+		return err
+	}
+	if socket.Mode() != srtwrap.ModeListener {
+		socket.Close()
+		return errors.New("global srt listener url must be in listener mode")
+	}
+	l.srt = socket
+	socket.SetListenCallback(l.accept)
+	if err := socket.Listen(5); err != nil {
+		socket.Close()
+		return err
+	}
+	l.ctx, l.cancel = context.WithCancel(ctx)
+	routerLock.Lock()
+	global = l
+	routerLock.Unlock()
+	go l.acceptLoop()
+	go l.statsLoop()
+	return nil
+}
+
+// StopListener stops the global listener and disconnects its callers.
+func StopListener() {
+	routerLock.Lock()
+	l := global
+	global = nil
+	var outputs []*srtoutput
+	for _, rf := range routes {
+		if rf.out != nil {
+			outputs = append(outputs, rf.out)
+			rf.out = nil
+		}
+	}
+	routerLock.Unlock()
+	if l == nil {
+		return
+	}
+	l.cancel()
+	l.srt.Close()
+	<-l.done
+	for _, out := range outputs {
+		out.Close()
+	}
+}
+
+// output returns the flow's output, creating it when needed. Caller must
+// hold routerLock.
+func (l *listener) output(identifier string, rf *routedflow) (*srtoutput, error) {
+	if rf.out != nil {
+		return rf.out, nil
+	}
+	s := &srtoutput{
+		Url:               l.url,
+		SanitisedURL:      l.sanitisedURL,
+		timeout:           l.timeout,
+		identifier:        identifier,
+		output_identifier: ListenerOutputIdentifier,
+		m:                 rf.m,
+		stats:             rf.stats,
+		workers:           l.workers,
+		acl:               l.acl,
+		routed:            true,
+	}
+	s.ctx, s.cancel = context.WithCancel(rf.ctx)
+	pool, err := newClientPool(s, s.workers)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.pool = pool
+	rf.out = s
+	rf.m.AddOutput(s)
+	return s, nil
+}
+
+// accept is the listen callback, it rejects callers without a flow and
+// applies the listener's acl. The acl is checked before the flow's output is
+// created, so rejected callers don't set one up, and counts the callers
+// accepted but not yet added as clients.
+func (l *listener) accept(addr *net.UDPAddr, streamid string) bool {
+	identifier := FlowFromStreamID(streamid)
+	routerLock.Lock()
+	rf, ok := routes[identifier]
+	if !ok || global != l {
+		l.rejectedFlow++
+		routerLock.Unlock()
+		l.logger.Warn().
+			Str("client", addr.String()).
+			Str("streamid", streamid).
+			Str("reason", "no such flow").
+			Msgf("rejected SRT caller %s: no flow for stream id %q", addr.String(), streamid)
+		return false
+	}
+	clients := 0
+	if rf.out != nil {
+		clients = rf.out.pool.used()
+	}
+	if reason := l.acl.check(addr.IP, streamid, clients); reason != "" {
+		if rf.out != nil {
+			rf.out.pool.reject(reason)
+		} else {
+			// without an output the flow has no clients yet
+			countRejection(&l.rejected, reason)
+		}
+		routerLock.Unlock()
+		logRejection(l.logger, addr, streamid, reason)
+		return false
+	}
+	out, err := l.output(identifier, rf)
+	if err != nil {
+		routerLock.Unlock()
+		l.logger.Error().Str("identifier", identifier).Err(err).Msg("error setting up srt listener output")
+		return false
+	}
+	out.pool.reserve(addr)
+	routerLock.Unlock()
+	return true
+}
+
+func (l *listener) acceptLoop() {
+	defer close(l.done)
+	for {
+		socket, addr, err := l.srt.Accept()
+		if err != nil {
+			if l.ctx.Err() == nil {
+				l.logger.Error().Err(err).Msg("error in global srt listener")
+			}
+			return
+		}
+		host := addr.IP.String()
+		streamid, err := socket.StreamID()
+		if err != nil {
+			l.logger.Error().Str("client", host).Err(err).Msg("couldn't get stream id of SRT caller")
+			socket.Close()
+			continue
+		}
+		identifier := FlowFromStreamID(streamid)
+		routerLock.Lock()
+		var out *srtoutput
+		if rf, ok := routes[identifier]; ok {
+			out = rf.out
+		}
+		routerLock.Unlock()
+		if out == nil {
+			// the flow was removed after the caller was accepted
+			socket.Close()
+			continue
+		}
+		if err := out.pool.add(socket, addr); err != nil {
+			l.logger.Error().Str("identifier", identifier).Str("client", host).Err(err).Msgf("error adding SRT client %s", host)
+			socket.Close()
+			continue
+		}
+		l.logger.Info().
+			Str("identifier", identifier).
+			Str("client", host).
+			Str("streamid", streamid).
+			Msgf("SRT client %s connected to flow %s", host, identifier)
+	}
+}
+
+// statsLoop reports the totals of all flows, the flows report their own
+// listener stats.
+func (l *listener) statsLoop() {
+	ticker := time.NewTicker(time.Duration(stats.StatsIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		routerLock.Lock()
+		total := l.rejected
+		total.RejectedFlow = l.rejectedFlow
+		for _, rf := range routes {
+			if rf.out == nil {
+				continue
+			}
+			st := rf.out.pool.listenerStats()
+			total.Clients += st.Clients
+			total.Accepted += st.Accepted
+			total.Rejected += st.Rejected
+			total.RejectedDenied += st.RejectedDenied
+			total.RejectedNotAllowed += st.RejectedNotAllowed
+			total.RejectedStreamID += st.RejectedStreamID
+			total.RejectedMaxClients += st.RejectedMaxClients
+			total.DroppedBlocks += st.DroppedBlocks
+			total.DroppedBytes += st.DroppedBytes
+		}
+		routerLock.Unlock()
+		total.Rejected += total.RejectedFlow
+		go l.stats.HandleStats("", ListenerOutputIdentifier, l.sanitisedURL, &total)
+	}
+}
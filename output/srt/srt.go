@@ -9,6 +9,7 @@ package srt
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
@@ -19,6 +20,7 @@ import (
 	"github.com/EmadHeravi/streamsow/logging"
 	"github.com/EmadHeravi/streamsow/mainloop"
 	"github.com/EmadHeravi/streamsow/output"
+	"github.com/EmadHeravi/streamsow/sanitise"
 	srtwrap "github.com/EmadHeravi/streamsow/srt"
 	"github.com/EmadHeravi/streamsow/stats"
 	"github.com/rs/zerolog"
@@ -36,8 +38,10 @@ func init() {
 }
 
 type srtoutput struct {
+	output.Tracker
 	ctx               context.Context
 	cancel            context.CancelFunc
+	srtLock           sync.Mutex // guards srt, reconnect replaces it
 	srt               *srtwrap.Socket
 	host              string
 	timeout           int
@@ -48,10 +52,13 @@ type srtoutput struct {
 	m                 *mainloop.Mainloop
 	stats             *stats.Stats
 	wg                *sync.WaitGroup
-	parent            *srtoutput
-	index             int
-	clientsLock       *sync.Mutex
-	clients           map[int]*srtoutput
+	workers           int
+	acl               *acl
+	pool              *clientpool
+	closeOnce         sync.Once
+	// callers of the global listener, there's no socket of its own
+	routed bool
+	policy output.ReconnectPolicy
 }
 
 func (s *srtoutput) String() string {
@@ -59,26 +66,48 @@ func (s *srtoutput) String() string {
 }
 
 func (s *srtoutput) Count() int {
-	if s.srt.Mode() == srtwrap.ModeCaller {
+	if s.pool == nil {
 		return 1
 	}
-	return len(s.clients)
+	return s.pool.count()
+}
+
+// Status adds the connected clients of a listener to the tracked status.
+func (s *srtoutput) Status() output.Status {
+	status := s.Tracker.Status()
+	if s.pool != nil {
+		status.Clients = s.pool.clientStatus()
+	}
+	return status
+}
+
+func (s *srtoutput) OutputIdentifier() string {
+	return s.output_identifier
+}
+
+// socket returns the output's socket.
+func (s *srtoutput) socket() *srtwrap.Socket {
+	s.srtLock.Lock()
+	defer s.srtLock.Unlock()
+	return s.srt
 }
 
 func (s *srtoutput) Write(block *libristwrapper.RistDataBlock) (n int, e error) {
-	n, e = s.srt.Write(block.Data)
-	if e != nil {
-		if s.srt.Mode() == srtwrap.ModeFailure {
-			logger.Info().
-				Str("identifier", s.identifier).
-				Str("output_identifier", s.output_identifier).
-				Str("srt-url", s.SanitisedURL.String()).
-				Str("client", s.host).
-				Msgf("SRT client %s disconnected", s.host)
-			s.parent.clientsLock.Lock()
-			delete(s.parent.clients, s.index)
-			s.parent.clientsLock.Unlock()
-		} else if s.srt.Mode() == srtwrap.ModeCaller {
+	if s.pool != nil {
+		if !s.pool.write(block) {
+			return 0, output.ErrDropped
+		}
+		s.Written(len(block.Data))
+		return len(block.Data), nil
+	}
+	srtSocket := s.socket()
+	n, e = srtSocket.Write(block.Data)
+	if e == nil {
+		s.Written(n)
+	} else {
+		s.Failed(e)
+		if srtSocket.Mode() == srtwrap.ModeCaller {
+			s.SetState(output.StateConnecting)
 			logger.Error().
 				Str("identifier", s.identifier).
 				Str("output_identifier", s.output_identifier).
@@ -92,18 +121,29 @@ func (s *srtoutput) Write(block *libristwrapper.RistDataBlock) (n int, e error)
 	return
 }
 
+// Close may be called by both the mainloop and the flow, only the first call
+// closes the output.
 func (s *srtoutput) Close() error {
-	s.cancel()
-	if s.srt != nil {
-		s.srt.Close()
-	}
+	s.closeOnce.Do(func() {
+		// reconnect doesn't publish a socket once cancelled
+		s.cancel()
+		if srtSocket := s.socket(); srtSocket != nil {
+			srtSocket.Close()
+		}
+		if s.routed {
+			unroute(s)
+			s.pool.close()
+		}
+		if s.pool != nil {
+			s.m.RemoveOutput(s)
+		}
+	})
 	return nil
 }
 
+// listenAccept adds accepted clients to the pool until the listener is
+// closed.
 func (s *srtoutput) listenAccept() {
-	s.clients = make(map[int]*srtoutput, 5)
-	s.clientsLock = new(sync.Mutex)
-	clientIndex := 0
 	for {
 		srtSocket, u, err := s.srt.Accept()
 		if err != nil {
@@ -113,33 +153,41 @@ func (s *srtoutput) listenAccept() {
 				Str("srt-url", s.SanitisedURL.String()).
 				Err(err).
 				Msg("error in srtsocket listen")
+			if s.ctx.Err() == nil {
+				s.Failed(err)
+				s.SetState(output.StateFailed)
+			}
 			break
 		}
-		srtoutput := *s
-		srtoutput.srt = srtSocket
-		srtoutput.parent = s
-		srtoutput.host = u.IP.String()
-		srtoutput.index = clientIndex
-		s.clientsLock.Lock()
-		s.clients[clientIndex] = &srtoutput
-		s.clientsLock.Unlock()
-		clientIndex++
-		s.m.AddOutput(&srtoutput)
-		go srtoutput.statsLoop()
+		host := u.IP.String()
+		if err := s.pool.add(srtSocket, u); err != nil {
+			logger.Error().
+				Str("identifier", s.identifier).
+				Str("output_identifier", s.output_identifier).
+				Str("srt-url", s.SanitisedURL.String()).
+				Str("client", host).
+				Err(err).
+				Msgf("error adding SRT client %s", host)
+			srtSocket.Close()
+			continue
+		}
+		logger.Info().
+			Str("identifier", s.identifier).
+			Str("output_identifier", s.output_identifier).
+			Str("srt-url", s.SanitisedURL.String()).
+			Str("client", host).
+			Msgf("SRT client %s connected", host)
 	}
 
-	s.clientsLock.Lock()
-	for _, o := range s.clients {
-		o.Close()
-	}
-	s.clientsLock.Unlock()
+	s.cancel()
+	s.pool.close()
 	s.wg.Done()
 }
 
 func (s *srtoutput) statsLoop() {
 	for {
 		time.Sleep(time.Duration(stats.StatsIntervalSeconds) * time.Second)
-		statsVal, err := s.srt.Stats()
+		statsVal, err := s.socket().Stats()
 		if err != nil {
 			if errors.Is(err, srtwrap.SRTErrno(srtwrap.ErrNoConn)) || errors.Is(err, srtwrap.SRTErrno(srtwrap.ErrInvSock)) {
 				break
@@ -152,89 +200,145 @@ func (s *srtoutput) statsLoop() {
 				Msg("error in srt statsloop")
 			break
 		}
-		go s.stats.HandleStats(s.host, s.output_identifier, s.Url, statsVal)
+		go s.stats.HandleStats(s.host, s.output_identifier, s.SanitisedURL, statsVal)
 	}
 }
 
+// reconnect replaces the caller socket with backoff, the output is added
+// back to the mainloop once connected.
 func (s *srtoutput) reconnect() {
-	select {
-	case <-s.ctx.Done():
-		return
-	default:
-		//
+	if srtSocket := s.socket(); srtSocket != nil {
+		srtSocket.Close()
 	}
-	if err := setupSrtSocket(s); err != nil {
-		logging.Log.Error().Err(err).Msg("this should be impossible in reconnect loop")
+	s.SetState(output.StateConnecting)
+	log := logger.With().
+		Str("identifier", s.identifier).
+		Str("output_identifier", s.output_identifier).
+		Str("srt-url", s.SanitisedURL.String()).
+		Logger()
+	err := output.Reconnect(s.ctx, s.policy, &s.Tracker, log, func() error {
+		srtSocket, _, err := newSocket(s.Url, s.timeout)
+		if err != nil {
+			return err
+		}
+		if err := srtSocket.Connect(); err != nil {
+			srtSocket.Close()
+			return err
+		}
+		s.srtLock.Lock()
+		defer s.srtLock.Unlock()
+		if err := s.ctx.Err(); err != nil {
+			// closed while connecting
+			srtSocket.Close()
+			return err
+		}
+		s.srt = srtSocket
+		return nil
+	})
+	if err != nil {
+		return
 	}
+	s.connected()
 }
 
-func setupSrtSocket(s *srtoutput) error {
-	host := s.Url.Hostname()
+// connected marks a caller active and adds it to the mainloop.
+func (s *srtoutput) connected() {
+	logger.Info().
+		Str("output_identifier", s.identifier).
+		Str("srt-url", s.SanitisedURL.String()).
+		Str("client", s.host).
+		Msgf("SRT Connected to: %s", s.host)
+	s.SetState(output.StateActive)
+	go s.statsLoop()
+	s.m.AddOutput(s)
+}
+
+// newSocket creates a non-blocking socket for the url, url params are passed
+// as SRT options except for the ones handled by streamzeug.
+func newSocket(u *url.URL, timeout int) (*srtwrap.Socket, string, error) {
+	host := u.Hostname()
 	if host == "" {
 		host = "0.0.0.0"
 	}
-	s.host = host
-	port, err := strconv.Atoi(s.Url.Port())
+	port, err := strconv.Atoi(u.Port())
 	if err != nil {
-		return err
+		return nil, host, err
 	}
 	options := make(map[string]string)
-	for key := range s.Url.Query() {
-		options[key] = s.Url.Query().Get(key)
+	for key := range u.Query() {
+		options[key] = u.Query().Get(key)
 	}
 	delete(options, "identifier")
+	delete(options, "workers")
+	for _, key := range aclParams {
+		delete(options, key)
+	}
 	options["blocking"] = "0"
-	options["latency"] = strconv.Itoa(s.timeout)
+	options["latency"] = strconv.Itoa(timeout)
 	// write timeout in ms
-	options["sndtimeo"] = strconv.Itoa(s.timeout * 1000)
-	options["rcvtimeo"] = strconv.Itoa(s.timeout * 1000)
+	options["sndtimeo"] = strconv.Itoa(timeout * 1000)
+	options["rcvtimeo"] = strconv.Itoa(timeout * 1000)
 
-	srtSocket, err := srtwrap.NewSocket(host, uint16(port), options)
+	// listeners are owned by srtwrap, their callers are served with epoll
+	create := srtwrap.NewSocket
+	if srtwrap.ModeOf(host, options) == srtwrap.ModeListener {
+		create = srtwrap.NewListener
+	}
+	srtSocket, err := create(host, uint16(port), options)
 	if err != nil {
-		return err
+		return nil, host, err
 	}
 	if srtSocket == nil {
-		return errors.New("got nil srtSocket")
+		return nil, host, errors.New("got nil srtSocket")
+	}
+	return srtSocket, host, nil
+}
+
+func setupSrtSocket(s *srtoutput) error {
+	srtSocket, host, err := newSocket(s.Url, s.timeout)
+	s.host = host
+	if err != nil {
+		return err
 	}
 	s.srt = srtSocket
 	if srtSocket.Mode() == srtwrap.ModeListener {
+		pool, err := newClientPool(s, s.workers)
+		if err != nil {
+			srtSocket.Close()
+			return err
+		}
+		srtSocket.SetListenCallback(pool.accept)
 		if err := srtSocket.Listen(5); err != nil {
+			s.cancel()
+			pool.close()
 			return err
 		}
+		s.pool = pool
 		s.wg.Add(1)
 		go s.listenAccept()
+		s.m.AddOutput(s)
 	} else {
+		s.SetState(output.StateConnecting)
 		if err := srtSocket.Connect(); err != nil {
+			s.Failed(err)
 			if _, ok := err.(*srtwrap.SocketClosed); ok {
 				srtSocket.Close()
+				s.srt = nil
 				go s.reconnect()
 				return nil
 			}
 			return err
 		}
-		logger.Info().
-			Str("output_identifier", s.identifier).
-			Str("srt-url", s.Url.String()).
-			Str("client", s.host).
-			Msgf("SRT Connected to: %s", s.host)
-		go s.statsLoop()
-		s.m.AddOutput(s)
+		s.connected()
 	}
 	return nil
 }
 
-func ParseSrtOutput(ctx context.Context, u *url.URL, identifier string, output_identifier string, m *mainloop.Mainloop, stats *stats.Stats, wait *sync.WaitGroup) (output.Output, error) {
+func ParseSrtOutput(ctx context.Context, u *url.URL, identifier string, output_identifier string, m *mainloop.Mainloop, stats *stats.Stats, wait *sync.WaitGroup, policy output.ReconnectPolicy) (output.Output, error) {
 	context, cancel := context.WithCancel(ctx)
 	var srtout srtoutput
 	srtout.Url = u
-	srtout.SanitisedURL = u
-	if u.Query().Get("passphrase") != "" {
-		sanitised, _ := url.Parse(u.String())
-		q := sanitised.Query()
-		q.Set("passphrase", "REDACTED")
-		sanitised.RawQuery = q.Encode()
-		srtout.SanitisedURL = sanitised
-	}
+	srtout.SanitisedURL = sanitise.URL(u)
 	logging.Log.Info().
 		Str("identifier", identifier).
 		Msgf("setting up srt output: %s", srtout.SanitisedURL)
@@ -243,7 +347,7 @@ func ParseSrtOutput(ctx context.Context, u *url.URL, identifier string, output_i
 	srtout.ctx = context
 	srtout.cancel = cancel
 	srtout.m = m
-	wait.Add(1)
+	srtout.policy = policy
 	srtout.wg = wait
 
 	srtout.timeout, _ = strconv.Atoi(u.Query().Get("timeout"))
@@ -255,12 +359,26 @@ func ParseSrtOutput(ctx context.Context, u *url.URL, identifier string, output_i
 			Msg("timeout set to 0, this will cause problems, set it to something sane")
 	}
 	srtout.stats = stats
+	srtout.workers = defaultWorkers
+	var err error
+	if srtout.acl, err = parseACL(u.Query()); err != nil {
+		cancel()
+		return nil, err
+	}
+	if w := u.Query().Get("workers"); w != "" {
+		if srtout.workers, err = strconv.Atoi(w); err != nil || srtout.workers <= 0 {
+			cancel()
+			return nil, fmt.Errorf("invalid workers %q", w)
+		}
+	}
 
-	err := setupSrtSocket(&srtout)
+	err = setupSrtSocket(&srtout)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
+	// added once setup can't fail anymore, failed outputs aren't waited for
+	wait.Add(1)
 	return &srtout, nil
 }
 
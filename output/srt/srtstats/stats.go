@@ -0,0 +1,24 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srtstats
+
+// ListenerStats are the connection counters of an SRT listener output, all
+// counters are totals since the output was set up.
+type ListenerStats struct {
+	Clients            int
+	Accepted           int
+	Rejected           int
+	RejectedDenied     int
+	RejectedNotAllowed int
+	RejectedStreamID   int
+	RejectedMaxClients int
+	// stream id not matching a flow, global listener only
+	RejectedFlow int
+	// blocks dropped by clients with a full queue
+	DroppedBlocks int64
+	DroppedBytes  int64
+}
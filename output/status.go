@@ -0,0 +1,110 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package output
+
+import (
+	"sync"
+	"time"
+)
+
+// Output states reported in the flow status.
+const (
+	StateConnecting       = "connecting"
+	StateActive           = "active"
+	StateFloatingInactive = "floating-inactive"
+	StateFailed           = "failed"
+)
+
+// ClientStatus is a client connected to an output.
+type ClientStatus struct {
+	Remote         string    `json:"remote"`
+	ConnectedSince time.Time `json:"connectedsince"`
+	DroppedBlocks  int64     `json:"droppedblocks,omitempty"`
+	DroppedBytes   int64     `json:"droppedbytes,omitempty"`
+}
+
+// Status is the state of a configured output.
+type Status struct {
+	Identifier    string         `json:"identifier"`
+	URL           string         `json:"url"`
+	Type          string         `json:"type"`
+	State         string         `json:"state"`
+	Clients       []ClientStatus `json:"clients,omitempty"`
+	WrittenBytes  int64          `json:"writtenbytes"`
+	WrittenBlocks int64          `json:"writtenblocks"`
+	LastError     string         `json:"lasterror,omitempty"`
+	LastErrorTime *time.Time     `json:"lasterrortime,omitempty"`
+	LastWrite     *time.Time     `json:"lastwrite,omitempty"`
+}
+
+// StatusReporter is implemented by outputs that report their state.
+type StatusReporter interface {
+	Status() Status
+}
+
+// Tracker keeps the state and write counters of an output, outputs embed it
+// to implement StatusReporter.
+type Tracker struct {
+	statusLock    sync.Mutex
+	state         string
+	writtenBytes  int64
+	writtenBlocks int64
+	lastError     string
+	lastErrorTime time.Time
+	lastWrite     time.Time
+}
+
+// SetState sets the state reported for the output.
+func (t *Tracker) SetState(state string) {
+	t.statusLock.Lock()
+	t.state = state
+	t.statusLock.Unlock()
+}
+
+// Written records a successful write of n bytes.
+func (t *Tracker) Written(n int) {
+	t.statusLock.Lock()
+	t.writtenBytes += int64(n)
+	t.writtenBlocks++
+	t.lastWrite = time.Now()
+	t.statusLock.Unlock()
+}
+
+// Failed records a write or connection error.
+func (t *Tracker) Failed(err error) {
+	if err == nil {
+		return
+	}
+	t.statusLock.Lock()
+	t.lastError = err.Error()
+	t.lastErrorTime = time.Now()
+	t.statusLock.Unlock()
+}
+
+// Status returns the state and counters, outputs fill in their clients.
+func (t *Tracker) Status() Status {
+	t.statusLock.Lock()
+	defer t.statusLock.Unlock()
+	s := Status{
+		State:         t.state,
+		WrittenBytes:  t.writtenBytes,
+		WrittenBlocks: t.writtenBlocks,
+		LastError:     t.lastError,
+	}
+	if s.State == "" {
+		s.State = StateActive
+	}
+	if !t.lastErrorTime.IsZero() {
+		lastError := t.lastErrorTime
+		s.LastErrorTime = &lastError
+	}
+	if !t.lastWrite.IsZero() {
+		lastWrite := t.lastWrite
+		s.LastWrite = &lastWrite
+	}
+	return s
+}
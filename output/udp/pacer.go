@@ -0,0 +1,266 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/ts"
+	"github.com/rs/zerolog"
+)
+
+const (
+	pacingPCR     = "pcr"
+	pacingBitrate = "bitrate"
+
+	defaultPlayoutDelay = 100 * time.Millisecond
+	datagramPackets     = 7
+	// min time between resync log messages
+	resyncLogInterval = 5 * time.Second
+)
+
+type datagram struct {
+	data      []byte
+	timestamp uint64
+	at        time.Time
+}
+
+// pacer delays datagrams by the playout delay and sends them at the time
+// given by the PCRs of the stream or the position at a fixed bitrate,
+// smoothing the jitter of the input. write is called from the output's
+// goroutine and owns the schedule, sending happens in run.
+type pacer struct {
+	ctx    context.Context
+	logger zerolog.Logger
+	send   func(*libristwrapper.RistDataBlock) (int, error)
+	mode   string
+	delay  time.Duration
+	// bitrate mode: fixed, pcr mode: rate of the previous PCR interval
+	packetTime time.Duration
+	// pcr mode: the PCR PID, -1 uses the first PID carrying PCRs
+	pcrPID int
+
+	// schedule, in pcr mode anchored at the send time of anchorPCR
+	anchored   bool
+	anchorTime time.Time
+	anchorPCR  uint64
+	lastTime   time.Time
+	lastPos    uint64
+	pos        uint64
+	pending    datagram
+	resyncs    int
+	lastResync time.Time
+
+	lock  sync.Mutex
+	queue []datagram
+	wake  chan struct{}
+	err   error
+}
+
+// parsePacing parses the pacing url params, it returns nil when pacing
+// isn't enabled.
+func parsePacing(ctx context.Context, u *url.URL, logger zerolog.Logger, send func(*libristwrapper.RistDataBlock) (int, error)) (*pacer, error) {
+	q := u.Query()
+	mode := q.Get("pacing")
+	if mode == "" {
+		return nil, nil
+	}
+	p := &pacer{
+		ctx:    ctx,
+		logger: logger,
+		send:   send,
+		mode:   mode,
+		delay:  defaultPlayoutDelay,
+		pcrPID: -1,
+		wake:   make(chan struct{}, 1),
+	}
+	switch mode {
+	case pacingPCR:
+		if v := q.Get("pcrpid"); v != "" {
+			pid, err := strconv.ParseUint(v, 0, 16)
+			if err != nil || pid >= uint64(ts.NullPID) {
+				return nil, fmt.Errorf("invalid pcrpid %q", v)
+			}
+			p.pcrPID = int(pid)
+		}
+	case pacingBitrate:
+		bitrate, err := strconv.Atoi(q.Get("bitrate"))
+		if err != nil || bitrate <= 0 {
+			return nil, fmt.Errorf("invalid bitrate %q, required for bitrate pacing", q.Get("bitrate"))
+		}
+		p.packetTime = time.Duration(ts.PacketSize * 8 * float64(time.Second) / float64(bitrate))
+	default:
+		return nil, fmt.Errorf("invalid pacing %q, must be pcr or bitrate", mode)
+	}
+	if v := q.Get("playoutdelay"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid playoutdelay %q", v)
+		}
+		p.delay = time.Duration(ms) * time.Millisecond
+	}
+	return p, nil
+}
+
+// pcr updates the schedule with the PCR of a packet of the PCR PID.
+func (p *pacer) pcr(packet []byte, arrival time.Time) {
+	pcr, ok := ts.PCR(packet)
+	if !ok {
+		return
+	}
+	if p.pcrPID < 0 {
+		p.pcrPID = int(ts.PID(packet))
+		p.logger.Debug().Msgf("pacing by pcr pid %d", p.pcrPID)
+	}
+	if ts.PID(packet) != uint16(p.pcrPID) {
+		return
+	}
+	if p.anchored && !ts.Discontinuity(packet) {
+		delta := (pcr + ts.PCRWrap - p.anchorPCR) % ts.PCRWrap
+		t := p.anchorTime.Add(time.Duration(delta * 1000 / (ts.PCRClock / 1000000)))
+		if p.inDelay(t, arrival) {
+			if p.pos > p.lastPos {
+				p.packetTime = t.Sub(p.lastTime) / time.Duration(p.pos-p.lastPos)
+			}
+			p.lastTime, p.lastPos = t, p.pos
+			return
+		}
+		// a PCR jump, the rate of the previous interval is kept
+		p.resync()
+	}
+	p.anchored = true
+	p.anchorTime, p.anchorPCR = arrival.Add(p.delay), pcr
+	p.lastTime, p.lastPos = p.anchorTime, p.pos
+}
+
+// inDelay returns true when at is within the playout delay of arrival,
+// allowing the input to run ahead by as much.
+func (p *pacer) inDelay(at, arrival time.Time) bool {
+	return !at.Before(arrival) && !at.After(arrival.Add(2*p.delay))
+}
+
+// resync drops the anchor of the schedule.
+func (p *pacer) resync() {
+	p.resyncs++
+	if time.Since(p.lastResync) >= resyncLogInterval {
+		p.logger.Warn().Int("count", p.resyncs).Msgf("%s pacing out of the playout delay, resyncing", p.mode)
+		p.lastResync = time.Now()
+		p.resyncs = 0
+	}
+	p.anchored = false
+}
+
+// schedule returns the send time of the current packet.
+func (p *pacer) schedule(arrival time.Time) time.Time {
+	if !p.anchored {
+		p.anchored = p.mode == pacingBitrate
+		p.lastTime, p.lastPos = arrival.Add(p.delay), p.pos
+	}
+	at := p.lastTime.Add(time.Duration(p.pos-p.lastPos) * p.packetTime)
+	if p.inDelay(at, arrival) {
+		return at
+	}
+	// the input ran ahead or fell behind by more than the playout delay
+	p.resync()
+	return p.schedule(arrival)
+}
+
+// write queues the packets of block. An error of sending is returned on the
+// next write, which drops the block and restarts the schedule.
+func (p *pacer) write(block *libristwrapper.RistDataBlock) (int, error) {
+	p.lock.Lock()
+	err := p.err
+	p.err = nil
+	p.lock.Unlock()
+	if err != nil {
+		p.anchored = false
+		p.pending = datagram{}
+		return 0, err
+	}
+	arrival := time.Now()
+	var full []datagram
+	data := block.Data
+	for len(data) >= ts.PacketSize {
+		packet := data[:ts.PacketSize]
+		data = data[ts.PacketSize:]
+		if p.mode == pacingPCR && packet[0] == ts.SyncByte {
+			p.pcr(packet, arrival)
+		}
+		if len(p.pending.data) == 0 {
+			p.pending.data = make([]byte, 0, datagramPackets*ts.PacketSize)
+			p.pending.at = p.schedule(arrival)
+			p.pending.timestamp = block.TimeStamp
+		}
+		p.pending.data = append(p.pending.data, packet...)
+		p.pos++
+		if len(p.pending.data) == datagramPackets*ts.PacketSize {
+			full = append(full, p.pending)
+			p.pending = datagram{}
+		}
+	}
+	if len(full) > 0 {
+		p.lock.Lock()
+		p.queue = append(p.queue, full...)
+		p.lock.Unlock()
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(block.Data), nil
+}
+
+// run sends the queued datagrams at their time until the context is done.
+func (p *pacer) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		p.lock.Lock()
+		if len(p.queue) == 0 || p.err != nil {
+			p.lock.Unlock()
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-p.wake:
+			}
+			continue
+		}
+		d := p.queue[0]
+		if wait := time.Until(d.at); wait > 0 {
+			p.lock.Unlock()
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+		p.queue[0] = datagram{}
+		p.queue = p.queue[1:]
+		p.lock.Unlock()
+		_, err := p.send(&libristwrapper.RistDataBlock{Data: d.data, TimeStamp: d.timestamp})
+		if err != nil {
+			p.lock.Lock()
+			p.err = err
+			p.queue = nil
+			p.lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package udp
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/ts"
+	"github.com/rs/zerolog"
+)
+
+func testPacer(t *testing.T, rawurl string) *pacer {
+	t.Helper()
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(*libristwrapper.RistDataBlock) (int, error) { return 0, nil }
+	p, err := parsePacing(context.Background(), u, zerolog.Nop(), send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func block(packets ...[]byte) *libristwrapper.RistDataBlock {
+	var data []byte
+	for _, p := range packets {
+		data = append(data, p...)
+	}
+	return &libristwrapper.RistDataBlock{Data: data}
+}
+
+func nulls(n int) [][]byte {
+	packets := make([][]byte, n)
+	for i := range packets {
+		packets[i] = ts.NullPacket()
+	}
+	return packets
+}
+
+// offsets returns the send times of the queued datagrams relative to the
+// first one.
+func offsets(p *pacer) []time.Duration {
+	var out []time.Duration
+	for _, d := range p.queue {
+		out = append(out, d.at.Sub(p.queue[0].at))
+	}
+	return out
+}
+
+// checkDelayed checks queued datagram i is sent the playout delay after
+// its arrival between before and now.
+func checkDelayed(t *testing.T, p *pacer, i int, before time.Time) {
+	t.Helper()
+	at := p.queue[i].at
+	if at.Before(before.Add(p.delay)) || at.After(time.Now().Add(p.delay)) {
+		t.Fatalf("datagram at %s after the arrival, want %s", at.Sub(before), p.delay)
+	}
+}
+
+func TestParsePacing(t *testing.T) {
+	tests := []struct {
+		query string
+		err   bool
+	}{
+		{"", false},
+		{"pacing=pcr", false},
+		{"pacing=pcr&pcrpid=0x100&playoutdelay=50", false},
+		{"pacing=bitrate&bitrate=1000000", false},
+		{"pacing=bitrate", true},
+		{"pacing=pcr&pcrpid=8191", true},
+		{"pacing=pcr&playoutdelay=0", true},
+		{"pacing=fast", true},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse("udp://127.0.0.1:1234?" + tt.query)
+		if _, err := parsePacing(context.Background(), u, zerolog.Nop(), nil); (err != nil) != tt.err {
+			t.Errorf("%q: error %v, want error %v", tt.query, err, tt.err)
+		}
+	}
+}
+
+func TestPacerBitrate(t *testing.T) {
+	// 1ms per packet
+	p := testPacer(t, "udp://127.0.0.1:1234?pacing=bitrate&bitrate=1504000&playoutdelay=20")
+	before := time.Now()
+	p.write(block(nulls(14)...))
+	checkDelayed(t, p, 0, before)
+	if got := offsets(p); len(got) != 2 || got[1] != 7*time.Millisecond {
+		t.Fatalf("datagrams at %v, want [0s 7ms]", got)
+	}
+	// partial datagrams are held until they're full
+	p.write(block(nulls(10)...))
+	if got := offsets(p); len(got) != 3 || got[2] != 14*time.Millisecond {
+		t.Fatalf("datagrams at %v, want [0s 7ms 14ms]", got)
+	}
+
+	// the input fell behind by more than the playout delay
+	time.Sleep(5 * p.delay)
+	before = time.Now()
+	p.write(block(nulls(11)...))
+	checkDelayed(t, p, len(p.queue)-1, before)
+}
+
+func TestPacerPCR(t *testing.T) {
+	p := testPacer(t, "udp://127.0.0.1:1234?pacing=pcr")
+	// 10 packets in 10ms, the rate applies from the second PCR on
+	packets := append([][]byte{ts.PCRPacket(0x100, 0, 0)}, nulls(9)...)
+	packets = append(packets, ts.PCRPacket(0x100, 270000, 0))
+	packets = append(packets, nulls(17)...)
+	before := time.Now()
+	p.write(block(packets...))
+	if p.pcrPID != 0x100 {
+		t.Fatalf("pacing by pid %d, want %d", p.pcrPID, 0x100)
+	}
+	if p.packetTime != time.Millisecond {
+		t.Fatalf("packet time %s, want 1ms", p.packetTime)
+	}
+	if got := offsets(p); len(got) != 4 || got[1] != 0 || got[2] != 14*time.Millisecond || got[3] != 21*time.Millisecond {
+		t.Fatalf("datagrams at %v, want [0s 0s 14ms 21ms]", got)
+	}
+	// PCRs of other PIDs are ignored
+	p.write(block(append([][]byte{ts.PCRPacket(0x200, 10*ts.PCRClock, 0)}, nulls(6)...)...))
+	if got := offsets(p); len(got) != 5 || got[4] != 28*time.Millisecond {
+		t.Fatalf("datagrams at %v, want 28ms last", got)
+	}
+
+	// a jump beyond the playout delay anchors the schedule again
+	before = time.Now()
+	p.write(block(append([][]byte{ts.PCRPacket(0x100, 10*ts.PCRClock, 0)}, nulls(6)...)...))
+	checkDelayed(t, p, len(p.queue)-1, before)
+	p.write(block(append([][]byte{ts.PCRPacket(0x100, 10*ts.PCRClock+270000, 0)}, nulls(6)...)...))
+	if got := offsets(p); len(got) != 7 || got[6]-got[5] != 10*time.Millisecond {
+		t.Fatalf("datagrams at %v, want the last 10ms apart", got)
+	}
+
+	// a discontinuity anchors the schedule without resync
+	disc := ts.PCRPacket(0x100, 0, 0)
+	disc[5] |= 0x80
+	before = time.Now()
+	p.write(block(append([][]byte{disc}, nulls(6)...)...))
+	checkDelayed(t, p, len(p.queue)-1, before)
+	if p.anchorPCR != 0 {
+		t.Fatalf("anchored at pcr %d, want 0", p.anchorPCR)
+	}
+}
+
+func TestPacerError(t *testing.T) {
+	p := testPacer(t, "udp://127.0.0.1:1234?pacing=bitrate&bitrate=1504000")
+	p.write(block(nulls(10)...))
+	sendErr := errors.New("send failed")
+	p.err, p.queue = sendErr, nil
+	// the error is returned once, the block is dropped
+	if n, err := p.write(block(nulls(7)...)); n != 0 || err != sendErr {
+		t.Fatalf("write returned %d, %v, want 0, %v", n, err, sendErr)
+	}
+	if p.anchored || len(p.pending.data) != 0 || len(p.queue) != 0 {
+		t.Fatal("schedule not restarted after an error")
+	}
+	before := time.Now()
+	if _, err := p.write(block(nulls(7)...)); err != nil {
+		t.Fatal(err)
+	}
+	checkDelayed(t, p, len(p.queue)-1, before)
+}
@@ -15,7 +15,6 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-	"time"
 
 	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/EmadHeravi/streamsow/logging"
@@ -28,21 +27,25 @@ import (
 type socketOptFunc func(sc syscall.RawConn) error
 
 type udpoutput struct {
-	c          *net.UDPConn
-	m          *mainloop.Mainloop
-	ctx        context.Context
-	cancel     context.CancelFunc
-	float      bool
-	identifier string
-	source     *net.UDPAddr
-	target     *net.UDPAddr
-	name       string
-	isRtp      bool
-	rtpSeq     uint16
-	rtpSSRC    uint32
-	rtpHeader  []byte
-	sc         syscall.RawConn
-	ss         []socketOptFunc
+	output.Tracker
+	c                 *net.UDPConn
+	m                 *mainloop.Mainloop
+	ctx               context.Context
+	cancel            context.CancelFunc
+	float             bool
+	identifier        string
+	output_identifier string
+	source            *net.UDPAddr
+	target            *net.UDPAddr
+	name              string
+	isRtp             bool
+	rtpSeq            uint16
+	rtpSSRC           uint32
+	rtpHeader         []byte
+	sc                syscall.RawConn
+	ss                []socketOptFunc
+	policy            output.ReconnectPolicy
+	pacer             *pacer
 }
 
 func (u *udpoutput) String() string {
@@ -53,6 +56,10 @@ func (u *udpoutput) Count() int {
 	return 1
 }
 
+func (u *udpoutput) OutputIdentifier() string {
+	return u.output_identifier
+}
+
 func (u *udpoutput) writeRTP(block *libristwrapper.RistDataBlock) (int, error) {
 	rtptime := (block.TimeStamp * 90000) >> 32
 	u.rtpHeader[0] = 0x80
@@ -75,26 +82,47 @@ func (u *udpoutput) writeRTP(block *libristwrapper.RistDataBlock) (int, error) {
 }
 
 func (u *udpoutput) Write(block *libristwrapper.RistDataBlock) (n int, err error) {
+	if u.pacer != nil {
+		n, err = u.pacer.write(block)
+	} else {
+		n, err = u.send(block)
+	}
+	if err != nil {
+		err = u.failed(err)
+	}
+	return
+}
+
+func (u *udpoutput) send(block *libristwrapper.RistDataBlock) (n int, err error) {
 	if !u.isRtp {
 		n, err = u.c.Write(block.Data)
 	} else {
 		n, err = u.writeRTP(block)
 	}
-	if err != nil {
-		if errors.Is(err, error(syscall.EPERM)) || errors.Is(err, error(syscall.ECONNREFUSED)) {
-			err = nil
-			return
-		}
-		if u.float {
-			logging.Log.Info().Str("identifier", u.identifier).Msgf("floating udp output: %s entered inactive state", u.name)
-			go func() {
-				go u.connectloop()
-			}()
-		}
+	if err == nil {
+		u.Written(n)
 	}
 	return
 }
 
+// failed handles a send error, it returns the error when the output is to be
+// removed from the mainloop. Floating outputs are added back by connectloop
+// once they're removed.
+func (u *udpoutput) failed(err error) error {
+	u.Failed(err)
+	if errors.Is(err, error(syscall.EPERM)) || errors.Is(err, error(syscall.ECONNREFUSED)) {
+		return nil
+	}
+	if u.float {
+		logging.Log.Info().Str("identifier", u.identifier).Msgf("floating udp output: %s entered inactive state", u.name)
+		u.SetState(output.StateFloatingInactive)
+		go u.connectloop()
+	} else {
+		u.SetState(output.StateFailed)
+	}
+	return err
+}
+
 func (u *udpoutput) Close() error {
 	u.cancel()
 	if u.c != nil {
@@ -103,23 +131,27 @@ func (u *udpoutput) Close() error {
 	return nil
 }
 
+// connectloop reconnects a floating output with backoff and adds it back to
+// the mainloop once connected.
 func (u *udpoutput) connectloop() {
-	for {
-		select {
-		case <-u.ctx.Done():
-			return
-		default:
-			//
+	logger := logging.Log.With().
+		Str("identifier", u.identifier).
+		Str("output_identifier", u.output_identifier).
+		Str("udp-url", u.name).
+		Logger()
+	err := output.Reconnect(u.ctx, u.policy, &u.Tracker, logger, func() error {
+		if u.c != nil {
+			u.c.Close()
+			u.c = nil
 		}
-		err := u.connect()
-		if err != nil {
-			time.Sleep(50 * time.Millisecond)
-			continue
-		}
-		logging.Log.Info().Str("identifier", u.identifier).Msgf("floating udp output: %s entered active state", u.name)
-		u.m.AddOutput(u)
+		return u.connect()
+	})
+	if err != nil {
 		return
 	}
+	logging.Log.Info().Str("identifier", u.identifier).Msgf("floating udp output: %s entered active state", u.name)
+	u.SetState(output.StateActive)
+	u.m.AddOutput(u)
 }
 
 func (u *udpoutput) connect() (err error) {
@@ -140,13 +172,15 @@ func (u *udpoutput) connect() (err error) {
 	return
 }
 
-func ParseUdpOutput(ctx context.Context, u *url.URL, identifier string, m *mainloop.Mainloop) (output.Output, error) {
+func ParseUdpOutput(ctx context.Context, u *url.URL, identifier, output_identifier string, m *mainloop.Mainloop, policy output.ReconnectPolicy) (output.Output, error) {
 	logging.Log.Info().Str("identifier", identifier).Msgf("setting up udp output: %s", u.String())
 	var out udpoutput
 	out.name = u.String()
 	out.identifier = identifier
+	out.output_identifier = output_identifier
 	out.ctx, out.cancel = context.WithCancel(ctx)
 	out.m = m
+	out.policy = policy
 	out.float = false
 	out.ss = make([]socketOptFunc, 0)
 	mcastIface := u.Query().Get("iface")
@@ -154,6 +188,21 @@ func ParseUdpOutput(ctx context.Context, u *url.URL, identifier string, m *mainl
 	if float != "" {
 		out.float = true
 	}
+	logger := logging.Log.With().
+		Str("identifier", identifier).
+		Str("output_identifier", output_identifier).
+		Str("udp-url", out.name).
+		Logger()
+	pacer, err := parsePacing(out.ctx, u, logger, out.send)
+	if err != nil {
+		out.cancel()
+		return nil, err
+	}
+	if pacer != nil {
+		out.pacer = pacer
+		go pacer.run()
+		logger.Info().Msgf("pacing udp output by %s with %s playout delay", pacer.mode, pacer.delay)
+	}
 	if u.Scheme == "rtp" {
 		out.isRtp = true
 		out.rtpSSRC = rand.Uint32()
@@ -213,6 +262,8 @@ func ParseUdpOutput(ctx context.Context, u *url.URL, identifier string, m *mainl
 	err = out.connect()
 	if err != nil {
 		if out.float && (errors.Is(err, error(unix.EADDRNOTAVAIL)) || errors.Is(err, error(unix.ENETUNREACH))) {
+			out.Failed(err)
+			out.SetState(output.StateFloatingInactive)
 			go out.connectloop()
 			return &out, nil
 		}
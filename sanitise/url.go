@@ -0,0 +1,103 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+// Package sanitise hides secrets in urls before they are logged or reported.
+package sanitise
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Redacted replaces secrets in sanitised urls.
+const Redacted = "REDACTED"
+
+// url params holding secrets, redacted in logs and status
+var secretParams = []string{"passphrase", "secret"}
+
+// URL returns the url with secrets redacted, both the secret url params and
+// the password in the userinfo.
+func URL(u *url.URL) *url.URL {
+	q := u.Query()
+	redactQuery := false
+	for _, key := range secretParams {
+		if q.Get(key) != "" {
+			q.Set(key, Redacted)
+			redactQuery = true
+		}
+	}
+	_, redactUser := u.User.Password()
+	if !redactQuery && !redactUser {
+		return u
+	}
+	sanitised := *u
+	if redactQuery {
+		sanitised.RawQuery = q.Encode()
+	}
+	if redactUser {
+		sanitised.User = url.UserPassword(u.User.Username(), Redacted)
+	}
+	return &sanitised
+}
+
+// String returns the url in s with secrets redacted. A url that fails to
+// parse is replaced by Redacted as a whole, its secrets can't be told apart.
+func String(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return Redacted
+	}
+	return URL(u).String()
+}
+
+// Restore puts the secrets of original, the url s was sanitised from, back
+// into s. It fails when s holds a redacted secret original doesn't have.
+func Restore(s, original string) (string, error) {
+	if s == Redacted {
+		if original == "" {
+			return "", errors.New("url is redacted")
+		}
+		return original, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		// left to url validation
+		return s, nil
+	}
+	o, err := url.Parse(original)
+	if err != nil {
+		o = &url.URL{}
+	}
+	restored := false
+	q := u.Query()
+	oq := o.Query()
+	for _, key := range secretParams {
+		if q.Get(key) != Redacted {
+			continue
+		}
+		if oq.Get(key) == "" {
+			return "", fmt.Errorf("%s is redacted", key)
+		}
+		q.Set(key, oq.Get(key))
+		restored = true
+	}
+	if restored {
+		u.RawQuery = q.Encode()
+	}
+	if p, ok := u.User.Password(); ok && p == Redacted {
+		op, ok := o.User.Password()
+		if !ok {
+			return "", errors.New("password is redacted")
+		}
+		u.User = url.UserPassword(u.User.Username(), op)
+		restored = true
+	}
+	if !restored {
+		return s, nil
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,33 @@
+package srt
+
+// The listen callback is exported in a file of its own, cgo doesn't allow C
+// definitions in the preamble of files exporting functions.
+
+import "C"
+
+import (
+	"net"
+	"unsafe"
+)
+
+// goListenCallback is called by SRT for every caller of a listener owned by
+// this package, it returns 0 to accept the caller and -1 to reject it.
+//
+//export goListenCallback
+func goListenCallback(lsn C.int, ip *C.uchar, iplen C.int, port C.int, streamid *C.char) C.int {
+	callbackLock.Lock()
+	cb, ok := callbacks[SocketID(lsn)]
+	callbackLock.Unlock()
+	if !ok {
+		return 0
+	}
+	addr := &net.UDPAddr{IP: net.IP(C.GoBytes(unsafe.Pointer(ip), iplen)), Port: int(port)}
+	var sid string
+	if streamid != nil {
+		sid = C.GoString(streamid)
+	}
+	if cb(addr, sid) {
+		return 0
+	}
+	return -1
+}
@@ -1,7 +1,13 @@
 package srt
 
-// Epoll functionality is not yet wrapped. This file provides
-// placeholders so the API surface is ready when needed.
+// #include <srt/srt.h>
+import "C"
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
 
 // EpollID represents an epoll instance identifier.
 type EpollID int
@@ -10,21 +16,110 @@ type EpollID int
 type EpollEvent int
 
 const (
-	EpollIn  EpollEvent = 1 << 0
-	EpollOut EpollEvent = 1 << 1
+	EpollIn  EpollEvent = C.SRT_EPOLL_IN
+	EpollOut EpollEvent = C.SRT_EPOLL_OUT
+	EpollErr EpollEvent = C.SRT_EPOLL_ERR
 )
 
-// EpollCreate is a stub for future epoll integration.
+// ErrAsyncSend is returned by TrySend when the send buffer is full.
+const ErrAsyncSend SRTErrno = C.SRT_EASYNCSND
+
+// EpollReady is a socket reported ready by EpollWait.
+type EpollReady struct {
+	ID     SocketID
+	Events EpollEvent
+}
+
+func lastError() error {
+	return SRTErrno(C.srt_getlasterror(nil))
+}
+
+// EpollCreate creates an epoll instance, waiting on an instance without
+// sockets is allowed.
 func EpollCreate() (EpollID, error) {
-	return 0, nil
+	eid := C.srt_epoll_create()
+	if eid < 0 {
+		return 0, lastError()
+	}
+	C.srt_epoll_set(eid, C.SRT_EPOLL_ENABLE_EMPTY)
+	return EpollID(eid), nil
+}
+
+// EpollRelease destroys the epoll instance.
+func EpollRelease(eid EpollID) error {
+	if C.srt_epoll_release(C.int(eid)) < 0 {
+		return lastError()
+	}
+	return nil
 }
 
-// EpollAdd is a stub for future epoll integration.
-func EpollAdd(_ EpollID, _ *Socket, _ EpollEvent) error {
+// EpollAdd adds a socket to the epoll instance, watching events.
+func EpollAdd(eid EpollID, s *Socket, events EpollEvent) error {
+	ev := C.int(events)
+	if C.srt_epoll_add_usock(C.int(eid), C.SRTSOCKET(s.ID()), &ev) < 0 {
+		return lastError()
+	}
 	return nil
 }
 
-// EpollWait is a stub for future epoll integration.
-func EpollWait(_ EpollID, _ int) ([]*Socket, error) {
-	return nil, nil
+// EpollUpdate replaces the events watched for a socket.
+func EpollUpdate(eid EpollID, s *Socket, events EpollEvent) error {
+	ev := C.int(events)
+	if C.srt_epoll_update_usock(C.int(eid), C.SRTSOCKET(s.ID()), &ev) < 0 {
+		return lastError()
+	}
+	return nil
+}
+
+// EpollRemove removes a socket from the epoll instance, closed sockets are
+// removed by SRT.
+func EpollRemove(eid EpollID, s *Socket) error {
+	if C.srt_epoll_remove_usock(C.int(eid), C.SRTSOCKET(s.ID())) < 0 {
+		return lastError()
+	}
+	return nil
+}
+
+// EpollWait waits up to timeout for sockets to become ready and stores them
+// in ready, it returns the number of sockets stored. A negative timeout waits
+// indefinitely.
+func EpollWait(eid EpollID, ready []EpollReady, timeout time.Duration) (int, error) {
+	if len(ready) == 0 {
+		return 0, nil
+	}
+	ms := C.int64_t(-1)
+	if timeout >= 0 {
+		ms = C.int64_t(timeout / time.Millisecond)
+	}
+	events := make([]C.SRT_EPOLL_EVENT, len(ready))
+	n := int(C.srt_epoll_uwait(C.int(eid), &events[0], C.int(len(events)), ms))
+	if n < 0 {
+		return 0, lastError()
+	}
+	// the number of ready sockets may exceed the array, the rest is reported
+	// on the next call
+	if n > len(ready) {
+		n = len(ready)
+	}
+	for i := 0; i < n; i++ {
+		ready[i] = EpollReady{ID: SocketID(events[i].fd), Events: EpollEvent(events[i].events)}
+	}
+	return n, nil
+}
+
+// TrySend sends b without blocking, it returns ErrAsyncSend when the send
+// buffer is full. The socket must be owned by this package and in
+// non-blocking mode.
+func (s *Socket) TrySend(b []byte) (int, error) {
+	if s == nil || !s.owned {
+		return 0, errors.New("srt: TrySend on socket not owned by this package")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n := C.srt_sendmsg2(C.SRTSOCKET(s.ID()), (*C.char)(unsafe.Pointer(&b[0])), C.int(len(b)), nil)
+	if n < 0 {
+		return 0, lastError()
+	}
+	return int(n), nil
 }
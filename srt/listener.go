@@ -0,0 +1,253 @@
+package srt
+
+/*
+#include <stdint.h>
+#include <string.h>
+#include <arpa/inet.h>
+#include <srt/srt.h>
+
+extern int goListenCallback(int lsn, unsigned char *ip, int iplen, int port, char *streamid);
+
+// sockaddr_ip copies the address of sa to ip and returns its length, 0 for
+// families other than IPv4 and IPv6.
+static int sockaddr_ip(const struct sockaddr *sa, unsigned char *ip, int *port) {
+	if (sa == NULL) {
+		return 0;
+	}
+	if (sa->sa_family == AF_INET) {
+		const struct sockaddr_in *in = (const struct sockaddr_in *)sa;
+		memcpy(ip, &in->sin_addr, 4);
+		*port = ntohs(in->sin_port);
+		return 4;
+	}
+	if (sa->sa_family == AF_INET6) {
+		const struct sockaddr_in6 *in6 = (const struct sockaddr_in6 *)sa;
+		memcpy(ip, &in6->sin6_addr, 16);
+		*port = ntohs(in6->sin6_port);
+		return 16;
+	}
+	return 0;
+}
+
+static int listen_hook(void *opaq, SRTSOCKET ns, int hsversion, const struct sockaddr *peeraddr, const char *streamid) {
+	unsigned char ip[16];
+	int port = 0;
+	int iplen = sockaddr_ip(peeraddr, ip, &port);
+	return goListenCallback((int)(intptr_t)opaq, ip, iplen, port, (char *)streamid);
+}
+
+static int set_listen_callback(SRTSOCKET lsn) {
+	return srt_listen_callback(lsn, listen_hook, (void *)(intptr_t)lsn);
+}
+
+static int bind_ip(SRTSOCKET u, const unsigned char *ip, int iplen, int port) {
+	if (iplen == 16) {
+		struct sockaddr_in6 in6;
+		memset(&in6, 0, sizeof(in6));
+		in6.sin6_family = AF_INET6;
+		in6.sin6_port = htons(port);
+		memcpy(&in6.sin6_addr, ip, 16);
+		return srt_bind(u, (struct sockaddr *)&in6, sizeof(in6));
+	}
+	struct sockaddr_in in;
+	memset(&in, 0, sizeof(in));
+	in.sin_family = AF_INET;
+	in.sin_port = htons(port);
+	memcpy(&in.sin_addr, ip, 4);
+	return srt_bind(u, (struct sockaddr *)&in, sizeof(in));
+}
+
+static SRTSOCKET accept_ip(SRTSOCKET u, unsigned char *ip, int *iplen, int *port) {
+	struct sockaddr_storage sa;
+	int len = sizeof(sa);
+	SRTSOCKET ns = srt_accept(u, (struct sockaddr *)&sa, &len);
+	if (ns >= 0) {
+		*iplen = sockaddr_ip((struct sockaddr *)&sa, ip, port);
+	}
+	return ns;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// how long Accept waits for a caller before checking whether the listener
+// was closed
+const acceptPoll = 100 * time.Millisecond
+
+const errAsyncRecv SRTErrno = C.SRT_EASYNCRCV
+
+var (
+	callbackLock sync.Mutex
+	// listen callbacks by listener handle
+	callbacks = make(map[SocketID]ListenCallbackFunc)
+)
+
+// NewListener creates a listener socket with the SRT API instead of srtgo,
+// the listener and the callers it accepts are owned by this package. The
+// options are those of NewSocket, except mode.
+func NewListener(host string, port uint16, options map[string]string) (*Socket, error) {
+	id := C.srt_create_socket()
+	if id < 0 {
+		return nil, lastError()
+	}
+	s := &Socket{owned: true, id: SocketID(id), mode: ModeListener, host: host, port: port, eid: -1}
+	if err := setOptions(s.id, options); err != nil {
+		C.srt_close(id)
+		return nil, err
+	}
+	return s, nil
+}
+
+// listen binds the socket to its host and port and starts listening.
+func (s *Socket) listen(backlog int) error {
+	ip := net.IPv4zero.To4()
+	if s.host != "" {
+		addr, err := net.ResolveIPAddr("ip", s.host)
+		if err != nil {
+			return err
+		}
+		ip = addr.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+	}
+	if C.bind_ip(C.SRTSOCKET(s.id), (*C.uchar)(unsafe.Pointer(&ip[0])), C.int(len(ip)), C.int(s.port)) < 0 {
+		return lastError()
+	}
+	eid, err := EpollCreate()
+	if err != nil {
+		return err
+	}
+	s.eid = eid
+	ev := C.int(EpollIn | EpollErr)
+	if C.srt_epoll_add_usock(C.int(eid), C.SRTSOCKET(s.id), &ev) < 0 {
+		return lastError()
+	}
+	if C.srt_listen(C.SRTSOCKET(s.id), C.int(backlog)) < 0 {
+		return lastError()
+	}
+	return nil
+}
+
+func (s *Socket) setListenCallback(cb ListenCallbackFunc) {
+	callbackLock.Lock()
+	callbacks[s.id] = cb
+	callbackLock.Unlock()
+	C.set_listen_callback(C.SRTSOCKET(s.id))
+}
+
+// accept waits for a caller, the listener is non-blocking so it waits for
+// its epoll instance to report it readable.
+func (s *Socket) accept() (*Socket, *net.UDPAddr, error) {
+	ready := make([]EpollReady, 1)
+	for {
+		if atomic.LoadInt32(&s.closed) != 0 {
+			return nil, nil, ErrClosed
+		}
+		var ip [16]C.uchar
+		var iplen, port C.int
+		ns := C.accept_ip(C.SRTSOCKET(s.id), &ip[0], &iplen, &port)
+		if ns >= 0 {
+			addr := &net.UDPAddr{IP: net.IP(C.GoBytes(unsafe.Pointer(&ip[0]), iplen)), Port: int(port)}
+			// accepted sockets are connected like callers
+			return &Socket{owned: true, id: SocketID(ns), mode: ModeCaller, eid: -1}, addr, nil
+		}
+		err := lastError()
+		if atomic.LoadInt32(&s.closed) != 0 {
+			return nil, nil, ErrClosed
+		}
+		if !errors.Is(err, errAsyncRecv) {
+			return nil, nil, err
+		}
+		if _, err := EpollWait(s.eid, ready, acceptPoll); err != nil && atomic.LoadInt32(&s.closed) == 0 {
+			return nil, nil, err
+		}
+	}
+}
+
+func (s *Socket) close() {
+	callbackLock.Lock()
+	delete(callbacks, s.id)
+	callbackLock.Unlock()
+	C.srt_close(C.SRTSOCKET(s.id))
+	if s.eid >= 0 {
+		EpollRelease(s.eid)
+	}
+}
+
+func (s *Socket) recv(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n := C.srt_recvmsg2(C.SRTSOCKET(s.id), (*C.char)(unsafe.Pointer(&b[0])), C.int(len(b)), nil)
+	if n < 0 {
+		return 0, lastError()
+	}
+	return int(n), nil
+}
+
+// bstats returns the socket's statistics, clearing the interval values. The
+// fields of srtgo's stats are those of SRT_TRACEBSTATS starting with a
+// capital, they're copied by name.
+func (s *Socket) bstats() (*Stats, error) {
+	var perf C.SRT_TRACEBSTATS
+	if C.srt_bstats(C.SRTSOCKET(s.id), &perf, 1) < 0 {
+		return nil, lastError()
+	}
+	st := new(Stats)
+	src := reflect.ValueOf(perf)
+	dst := reflect.ValueOf(st).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		name := dst.Type().Field(i).Name
+		f := src.FieldByName(strings.ToLower(name[:1]) + name[1:])
+		if !f.IsValid() {
+			continue
+		}
+		if err := setNumber(dst.Field(i), f); err != nil {
+			return nil, fmt.Errorf("srt: stats field %s: %w", name, err)
+		}
+	}
+	return st, nil
+}
+
+// setNumber sets the numeric field dst to the value of src.
+func setNumber(dst, src reflect.Value) error {
+	var i int64
+	var u uint64
+	var f float64
+	switch src.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i = src.Int()
+		u, f = uint64(i), float64(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u = src.Uint()
+		i, f = int64(u), float64(u)
+	case reflect.Float32, reflect.Float64:
+		f = src.Float()
+		i, u = int64(f), uint64(f)
+	default:
+		return fmt.Errorf("unsupported kind %s", src.Kind())
+	}
+	switch dst.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		dst.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported kind %s", dst.Kind())
+	}
+	return nil
+}
@@ -3,45 +3,112 @@ package srt
 import (
 	"errors"
 	"net"
+	"sync/atomic"
 
 	"github.com/haivision/srtgo"
 )
 
-// Socket is a wrapper around srtgo.SrtSocket.
+// Socket is a wrapper around srtgo.SrtSocket. Listeners created by
+// NewListener and the callers they accept are owned by this package instead,
+// their SRT socket handle is known so they can be used with epoll.
 type Socket struct {
 	inner *srtgo.SrtSocket
+	// owned sockets only
+	owned  bool
+	id     SocketID
+	mode   Mode
+	host   string
+	port   uint16
+	eid    EpollID
+	closed int32
 }
 
+// SocketID is the SRT socket handle.
+type SocketID int32
+
+// ErrClosed is returned by Accept when the listener was closed.
+var ErrClosed = errors.New("srt: socket closed")
+
 // NewSocket creates a new SRT socket with the given host, port and options.
 func NewSocket(host string, port uint16, options map[string]string) (*Socket, error) {
 	s := srtgo.NewSrtSocket(host, port, options)
 	if s == nil {
 		return nil, errors.New("srt: NewSocket returned nil underlying socket")
 	}
-	return &Socket{inner: s}, nil
+	return &Socket{inner: s, id: -1}, nil
+}
+
+// ModeOf returns the mode a socket for host and options is created in, like
+// srtgo a socket without mode option listens when host is empty or the
+// unspecified address.
+func ModeOf(host string, options map[string]string) Mode {
+	switch options["mode"] {
+	case "caller", "client":
+		return ModeCaller
+	case "listener", "server":
+		return ModeListener
+	case "":
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			return ModeListener
+		}
+		return ModeCaller
+	}
+	return ModeFailure
+}
+
+func (s *Socket) valid() bool {
+	return s != nil && (s.owned || s.inner != nil)
 }
 
 // Mode returns the mode of the socket.
 func (s *Socket) Mode() Mode {
-	if s == nil || s.inner == nil {
+	if !s.valid() {
 		return ModeFailure
 	}
+	if s.owned {
+		return s.mode
+	}
 	return Mode(s.inner.Mode())
 }
 
 // Listen puts the socket into listening mode.
 func (s *Socket) Listen(backlog int) error {
-	if s == nil || s.inner == nil {
+	if !s.valid() {
 		return errors.New("srt: Listen on nil socket")
 	}
+	if s.owned {
+		return s.listen(backlog)
+	}
 	return s.inner.Listen(backlog)
 }
 
+// ListenCallbackFunc decides whether a caller is accepted, it's called
+// before the connection is established.
+type ListenCallbackFunc func(addr *net.UDPAddr, streamid string) bool
+
+// SetListenCallback sets the callback deciding which callers a listener
+// accepts, it must be set before Listen.
+func (s *Socket) SetListenCallback(cb ListenCallbackFunc) {
+	if !s.valid() || cb == nil {
+		return
+	}
+	if s.owned {
+		s.setListenCallback(cb)
+		return
+	}
+	s.inner.SetListenCallback(func(_ *srtgo.SrtSocket, _ int, addr *net.UDPAddr, streamid string) bool {
+		return cb(addr, streamid)
+	})
+}
+
 // Accept waits for an incoming connection and returns a new Socket.
 func (s *Socket) Accept() (*Socket, *net.UDPAddr, error) {
-	if s == nil || s.inner == nil {
+	if !s.valid() {
 		return nil, nil, errors.New("srt: Accept on nil socket")
 	}
+	if s.owned {
+		return s.accept()
+	}
 	newS, addr, err := s.inner.Accept()
 	if err != nil {
 		return nil, addr, err
@@ -49,20 +116,26 @@ func (s *Socket) Accept() (*Socket, *net.UDPAddr, error) {
 	if newS == nil {
 		return nil, addr, errors.New("srt: Accept returned nil underlying socket")
 	}
-	return &Socket{inner: newS}, addr, nil
+	return &Socket{inner: newS, id: -1}, addr, nil
 }
 
 // Connect connects the socket in caller mode.
 func (s *Socket) Connect() error {
-	if s == nil || s.inner == nil {
-		return errors.New("srt: Connect on nil socket")
+	if !s.valid() || s.owned {
+		return errors.New("srt: Connect on nil or listener socket")
 	}
 	return s.inner.Connect()
 }
 
 // Close closes the socket.
 func (s *Socket) Close() {
-	if s == nil || s.inner == nil {
+	if !s.valid() {
+		return
+	}
+	if s.owned {
+		if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+			s.close()
+		}
 		return
 	}
 	s.inner.Close()
@@ -70,17 +143,34 @@ func (s *Socket) Close() {
 
 // Write writes data to the socket.
 func (s *Socket) Write(b []byte) (int, error) {
-	if s == nil || s.inner == nil {
+	if !s.valid() {
 		return 0, errors.New("srt: Write on nil socket")
 	}
+	if s.owned {
+		return s.TrySend(b)
+	}
 	return s.inner.Write(b)
 }
 
+// Read reads data from the socket.
+func (s *Socket) Read(b []byte) (int, error) {
+	if !s.valid() {
+		return 0, errors.New("srt: Read on nil socket")
+	}
+	if s.owned {
+		return s.recv(b)
+	}
+	return s.inner.Read(b)
+}
+
 // Stats retrieves the socket statistics.
 func (s *Socket) Stats() (*Stats, error) {
-	if s == nil || s.inner == nil {
+	if !s.valid() {
 		return nil, errors.New("srt: Stats on nil socket")
 	}
+	if s.owned {
+		return s.bstats()
+	}
 	st, err := s.inner.Stats()
 	if err != nil {
 		return nil, err
@@ -88,7 +178,17 @@ func (s *Socket) Stats() (*Stats, error) {
 	return (*Stats)(st), nil
 }
 
-// Underlying exposes the underlying srtgo.SrtSocket for internal use.
+// ID returns the SRT socket handle of sockets owned by this package, srtgo
+// doesn't export the handle of its sockets so they return -1.
+func (s *Socket) ID() SocketID {
+	if s == nil || !s.owned {
+		return -1
+	}
+	return s.id
+}
+
+// Underlying exposes the underlying srtgo.SrtSocket for internal use, it's
+// nil for sockets owned by this package.
 func (s *Socket) Underlying() *srtgo.SrtSocket {
 	if s == nil {
 		return nil
@@ -0,0 +1,79 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package srt
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestModeOf(t *testing.T) {
+	tests := []struct {
+		host    string
+		options map[string]string
+		want    Mode
+	}{
+		{"", nil, ModeListener},
+		{"0.0.0.0", nil, ModeListener},
+		{"::", nil, ModeListener},
+		{"10.0.0.1", nil, ModeCaller},
+		{"example.com", nil, ModeCaller},
+		{"10.0.0.1", map[string]string{"mode": "listener"}, ModeListener},
+		{"0.0.0.0", map[string]string{"mode": "caller"}, ModeCaller},
+		{"0.0.0.0", map[string]string{"mode": "server"}, ModeListener},
+		{"0.0.0.0", map[string]string{"mode": "client"}, ModeCaller},
+		{"0.0.0.0", map[string]string{"mode": "rendezvous"}, ModeFailure},
+	}
+	for _, tt := range tests {
+		if got := ModeOf(tt.host, tt.options); got != tt.want {
+			t.Errorf("ModeOf(%q, %v) = %d, want %d", tt.host, tt.options, got, tt.want)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	Init()
+	s, err := NewListener("127.0.0.1", 0, map[string]string{"latency": "100", "passphrase": "0123456789"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !socketExists(s.ID()) {
+		t.Fatalf("socket handle %d doesn't exist", s.ID())
+	}
+	if s.Mode() != ModeListener {
+		t.Fatalf("mode %d, want listener", s.Mode())
+	}
+	if err := s.Listen(1); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, _, err := s.Accept()
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.Close()
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatalf("accept after close: %v", err)
+	}
+	if socketExists(s.ID()) {
+		t.Fatalf("socket handle %d exists after close", s.ID())
+	}
+	// closing twice is allowed
+	s.Close()
+}
+
+func TestListenerOptions(t *testing.T) {
+	Init()
+	if _, err := NewListener("", 0, map[string]string{"latency": "abc"}); err == nil {
+		t.Fatal("invalid latency accepted")
+	}
+	if _, err := NewListener("", 0, map[string]string{"transtype": "bulk"}); err == nil {
+		t.Fatal("invalid transtype accepted")
+	}
+}
@@ -0,0 +1,182 @@
+package srt
+
+/*
+#include <stdlib.h>
+#include <sys/socket.h>
+#include <srt/srt.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"strconv"
+	"unsafe"
+)
+
+// max length of an SRT stream id
+const maxStreamIDLen = 512
+
+type optionType int
+
+const (
+	optInt optionType = iota
+	optInt64
+	optBool
+	optString
+	optTransType
+	optLinger
+)
+
+type socketOption struct {
+	opt C.SRT_SOCKOPT
+	typ optionType
+}
+
+// socketOptions are the SRT options by the url parameter names srtgo uses.
+var socketOptions = map[string]socketOption{
+	"transtype":          {C.SRTO_TRANSTYPE, optTransType},
+	"maxbw":              {C.SRTO_MAXBW, optInt64},
+	"pbkeylen":           {C.SRTO_PBKEYLEN, optInt},
+	"passphrase":         {C.SRTO_PASSPHRASE, optString},
+	"mss":                {C.SRTO_MSS, optInt},
+	"fc":                 {C.SRTO_FC, optInt},
+	"sndbuf":             {C.SRTO_SNDBUF, optInt},
+	"rcvbuf":             {C.SRTO_RCVBUF, optInt},
+	"ipttl":              {C.SRTO_IPTTL, optInt},
+	"iptos":              {C.SRTO_IPTOS, optInt},
+	"inputbw":            {C.SRTO_INPUTBW, optInt64},
+	"mininputbw":         {C.SRTO_MININPUTBW, optInt64},
+	"oheadbw":            {C.SRTO_OHEADBW, optInt},
+	"latency":            {C.SRTO_LATENCY, optInt},
+	"rcvlatency":         {C.SRTO_RCVLATENCY, optInt},
+	"peerlatency":        {C.SRTO_PEERLATENCY, optInt},
+	"tsbpdmode":          {C.SRTO_TSBPDMODE, optBool},
+	"tlpktdrop":          {C.SRTO_TLPKTDROP, optBool},
+	"snddropdelay":       {C.SRTO_SNDDROPDELAY, optInt},
+	"nakreport":          {C.SRTO_NAKREPORT, optBool},
+	"conntimeo":          {C.SRTO_CONNTIMEO, optInt},
+	"drifttracer":        {C.SRTO_DRIFTTRACER, optBool},
+	"lossmaxttl":         {C.SRTO_LOSSMAXTTL, optInt},
+	"minversion":         {C.SRTO_MINVERSION, optInt},
+	"streamid":           {C.SRTO_STREAMID, optString},
+	"congestion":         {C.SRTO_CONGESTION, optString},
+	"messageapi":         {C.SRTO_MESSAGEAPI, optBool},
+	"payloadsize":        {C.SRTO_PAYLOADSIZE, optInt},
+	"kmrefreshrate":      {C.SRTO_KMREFRESHRATE, optInt},
+	"kmpreannounce":      {C.SRTO_KMPREANNOUNCE, optInt},
+	"enforcedencryption": {C.SRTO_ENFORCEDENCRYPTION, optBool},
+	"ipv6only":           {C.SRTO_IPV6ONLY, optInt},
+	"peeridletimeo":      {C.SRTO_PEERIDLETIMEO, optInt},
+	"packetfilter":       {C.SRTO_PACKETFILTER, optString},
+	"retransmitalgo":     {C.SRTO_RETRANSMITALGO, optInt},
+	"sndtimeo":           {C.SRTO_SNDTIMEO, optInt},
+	"rcvtimeo":           {C.SRTO_RCVTIMEO, optInt},
+	"linger":             {C.SRTO_LINGER, optLinger},
+}
+
+// setOptions sets the options of a socket owned by this package, options
+// SRT doesn't know are ignored like srtgo does. The socket is non-blocking
+// unless blocking is 1.
+func setOptions(id SocketID, options map[string]string) error {
+	// the transmission type resets the other options to its defaults
+	if t, ok := options["transtype"]; ok {
+		if err := setOption(id, socketOptions["transtype"], t); err != nil {
+			return fmt.Errorf("srt option transtype: %w", err)
+		}
+	}
+	blocking := "0"
+	if options["blocking"] == "1" {
+		blocking = "1"
+	}
+	for _, opt := range []C.SRT_SOCKOPT{C.SRTO_SNDSYN, C.SRTO_RCVSYN} {
+		if err := setOption(id, socketOption{opt, optBool}, blocking); err != nil {
+			return fmt.Errorf("srt option blocking: %w", err)
+		}
+	}
+	for name, val := range options {
+		o, ok := socketOptions[name]
+		if !ok || name == "transtype" {
+			continue
+		}
+		if err := setOption(id, o, val); err != nil {
+			return fmt.Errorf("srt option %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setOption(id SocketID, o socketOption, val string) error {
+	var optval unsafe.Pointer
+	var optlen C.int
+	switch o.typ {
+	case optString:
+		cs := C.CString(val)
+		defer C.free(unsafe.Pointer(cs))
+		optval, optlen = unsafe.Pointer(cs), C.int(len(val))
+	case optInt64:
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		cv := C.int64_t(v)
+		optval, optlen = unsafe.Pointer(&cv), C.int(unsafe.Sizeof(cv))
+	case optLinger:
+		v, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		l := C.struct_linger{l_linger: C.int(v)}
+		if v > 0 {
+			l.l_onoff = 1
+		}
+		optval, optlen = unsafe.Pointer(&l), C.int(unsafe.Sizeof(l))
+	default:
+		v, err := intOption(o.typ, val)
+		if err != nil {
+			return err
+		}
+		cv := C.int(v)
+		optval, optlen = unsafe.Pointer(&cv), C.int(unsafe.Sizeof(cv))
+	}
+	if C.srt_setsockflag(C.SRTSOCKET(id), o.opt, optval, optlen) < 0 {
+		return lastError()
+	}
+	return nil
+}
+
+// intOption parses the value of an option SRT takes as int.
+func intOption(typ optionType, val string) (int, error) {
+	switch typ {
+	case optBool:
+		b, err := strconv.ParseBool(val)
+		if b {
+			return 1, err
+		}
+		return 0, err
+	case optTransType:
+		switch val {
+		case "live":
+			return C.SRTT_LIVE, nil
+		case "file":
+			return C.SRTT_FILE, nil
+		}
+		return 0, fmt.Errorf("invalid transmission type %q", val)
+	}
+	v, err := strconv.ParseInt(val, 10, 32)
+	return int(v), err
+}
+
+// StreamID returns the stream id the caller connected with.
+func (s *Socket) StreamID() (string, error) {
+	buf := make([]byte, maxStreamIDLen+1)
+	size := C.int(len(buf))
+	if C.srt_getsockflag(C.SRTSOCKET(s.ID()), C.SRTO_STREAMID, unsafe.Pointer(&buf[0]), &size) < 0 {
+		return "", lastError()
+	}
+	return string(buf[:size]), nil
+}
+
+// socketExists returns true when id is the handle of an SRT socket.
+func socketExists(id SocketID) bool {
+	return C.srt_getsockstate(C.SRTSOCKET(id)) != C.SRTS_NONEXIST
+}
@@ -16,8 +16,12 @@ import (
 
 	"code.videolan.org/rist/ristgo/libristwrapper"
 	"github.com/EmadHeravi/streamsow/config"
+	"github.com/EmadHeravi/streamsow/input/udp/udpstats"
 	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/mainloop/queuestats"
 	"github.com/EmadHeravi/streamsow/output/dektecasi/dtstats"
+	"github.com/EmadHeravi/streamsow/output/srt/srtstats"
+	"github.com/EmadHeravi/streamsow/ts/tsstats"
 	"github.com/EmadHeravi/streamsow/version"
 	"github.com/Showmax/go-fqdn"
 	"github.com/haivision/srtgo"
@@ -83,30 +87,52 @@ func structToMap(s interface{}) map[string]interface{} {
 	return m
 }
 
-func (s *Stats) writeInfluxStats(host, output_identifier string, u *url.URL, stats interface{}) {
-	configlock.RLock()
-	defer configlock.RUnlock()
+const (
+	kindSrt         = "srt"
+	kindSrtListener = "srt-listener"
+	kindRistRX      = "rist-receive"
+	kindRistTX      = "rist-sender"
+	kindDektecAsi   = "dektekasi"
+	kindHitless     = "hitless"
+	kindUDPInput    = "udp-input"
+	kindOutputQueue = "output-queue"
+	kindTR101290    = "tr101290"
+)
+
+// statsPoint returns the kind, tags and values for a stats struct, these are
+// shared between the influxdb and prometheus stats sinks.
+func (s *Stats) statsPoint(host, output_identifier string, u *url.URL, stats interface{}) (string, map[string]string, map[string]interface{}) {
 	values := structToMap(stats)
 	var (
-		measurement string
-		cname       string
+		kind  string
+		cname string
 	)
-	tags := map[string]string{"identifier": s.identifier, "hostname": hostname}
+	tags := map[string]string{"identifier": s.identifier}
 	switch stats.(type) {
 	case *libristwrapper.ReceiverFlowStats:
-		measurement = ristrxmeasurement
+		kind = kindRistRX
 		cname = values["CName"].(string)
 		delete(values, "CName")
 	case *libristwrapper.SenderPeerStats:
-		measurement = risttxmeasurement
+		kind = kindRistTX
 		cname = values["CName"].(string)
 		delete(values, "CName")
 	case *srtgo.SrtStats:
-		measurement = srtmeasurement
+		kind = kindSrt
+	case *srtstats.ListenerStats:
+		kind = kindSrtListener
 	case *dtstats.DektecAsiStats:
-		measurement = "dektekasi"
+		kind = kindDektecAsi
 		tags["port"] = strconv.FormatInt(int64(values["AsiPortno"].(int)), 10)
 		delete(values, "AsiPortno")
+	case *udpstats.HitlessStats:
+		kind = kindHitless
+	case *udpstats.InputStats:
+		kind = kindUDPInput
+	case *queuestats.QueueStats:
+		kind = kindOutputQueue
+	case *tsstats.TR101290Stats:
+		kind = kindTR101290
 	default:
 		panic("wrong interface")
 	}
@@ -122,6 +148,23 @@ func (s *Stats) writeInfluxStats(host, output_identifier string, u *url.URL, sta
 	if cname != "" {
 		tags["cname"] = cname
 	}
+	return kind, tags, values
+}
+
+func (s *Stats) writeInfluxStats(host, output_identifier string, u *url.URL, stats interface{}) {
+	configlock.RLock()
+	defer configlock.RUnlock()
+	kind, tags, values := s.statsPoint(host, output_identifier, u, stats)
+	tags["hostname"] = hostname
+	measurement := kind
+	switch kind {
+	case kindRistRX:
+		measurement = ristrxmeasurement
+	case kindRistTX:
+		measurement = risttxmeasurement
+	case kindSrt:
+		measurement = srtmeasurement
+	}
 	point := influxdb2.NewPoint(
 		measurement,
 		tags,
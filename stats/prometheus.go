@@ -0,0 +1,290 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/EmadHeravi/streamsow/version"
+)
+
+const (
+	promPrefix = "streamzeug_"
+	// samples not updated for this many stats intervals are dropped
+	promStaleIntervals = 3
+)
+
+// MetricType is the prometheus type of a metric.
+type MetricType string
+
+const (
+	Gauge   MetricType = "gauge"
+	Counter MetricType = "counter"
+)
+
+type promSample struct {
+	kind    string
+	labels  map[string]string
+	values  map[string]interface{}
+	updated time.Time
+}
+
+var (
+	promLock    sync.Mutex
+	promSamples = make(map[string]*promSample)
+)
+
+// storePrometheusStats keeps the latest sample of every stats source, they
+// are exposed on the next scrape.
+func (s *Stats) storePrometheusStats(host, output_identifier string, u *url.URL, stats interface{}) {
+	kind, labels, values := s.statsPoint(host, output_identifier, u, stats)
+	key := kind + labelString(labels)
+	promLock.Lock()
+	promSamples[key] = &promSample{kind, labels, values, time.Now()}
+	promLock.Unlock()
+}
+
+type promLine struct {
+	labels string
+	value  float64
+}
+
+type promMetric struct {
+	typ   MetricType
+	lines []promLine
+}
+
+// PrometheusMetrics collects metrics for a single scrape and renders them
+// in the prometheus text exposition format.
+type PrometheusMetrics struct {
+	metrics map[string]*promMetric
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{metrics: make(map[string]*promMetric)}
+}
+
+// Add adds a sample, name is prefixed with streamzeug_.
+func (p *PrometheusMetrics) Add(name string, typ MetricType, labels map[string]string, value float64) {
+	name = promPrefix + name
+	m, ok := p.metrics[name]
+	if !ok {
+		m = &promMetric{typ: typ}
+		p.metrics[name] = m
+	}
+	m.lines = append(m.lines, promLine{labelString(labels), value})
+}
+
+// AddStats adds the latest sample of all stats sources, string values are
+// added as labels.
+func (p *PrometheusMetrics) AddStats() {
+	maxAge := time.Duration(promStaleIntervals*StatsIntervalSeconds) * time.Second
+	promLock.Lock()
+	defer promLock.Unlock()
+	for key, sample := range promSamples {
+		if time.Since(sample.updated) > maxAge {
+			delete(promSamples, key)
+			continue
+		}
+		labels := make(map[string]string, len(sample.labels))
+		for k, v := range sample.labels {
+			labels[k] = v
+		}
+		for field, v := range sample.values {
+			if str, ok := v.(string); ok {
+				labels[metricName(field)] = str
+			}
+		}
+		kind := strings.ReplaceAll(sample.kind, "-", "_")
+		for field, v := range sample.values {
+			value, ok := toFloat(v)
+			if !ok {
+				continue
+			}
+			name := kind + "_" + metricName(field)
+			typ := promType(kind, field)
+			if typ == Counter && !strings.HasSuffix(name, "_total") {
+				name += "_total"
+			}
+			p.Add(name, typ, labels, value)
+		}
+	}
+}
+
+// promCounters are the fields of stats kinds that are totals since the
+// source started, all other fields are gauges.
+var promCounters = map[string]map[string]bool{
+	"output_queue": {
+		"QueuedBlocks": true, "QueuedBytes": true,
+		"WrittenBlocks": true, "WrittenBytes": true,
+		"DroppedBlocks": true, "DroppedBytes": true,
+		"NullPackets": true, "OverflowPackets": true, "DeletedNulls": true,
+	},
+	"udp_input": {
+		"Packets": true, "Bytes": true, "ReadErrors": true, "SourceChanges": true,
+		"Size188": true, "Size376": true, "Size564": true, "Size752": true,
+		"Size940": true, "Size1128": true, "Size1316": true, "SizeOther": true,
+		"SequenceGaps": true, "SequenceLost": true, "Reordered": true,
+		"Duplicates": true,
+	},
+	"hitless": {
+		"Merged": true, "Recovered": true, "Lost": true,
+		"Leg1Packets": true, "Leg1Lost": true, "Leg2Packets": true, "Leg2Lost": true,
+	},
+	kindSrtListener: {
+		"Accepted": true, "Rejected": true, "RejectedDenied": true, "RejectedNotAllowed": true,
+		"RejectedStreamID": true, "RejectedMaxClients": true, "RejectedFlow": true,
+		"DroppedBlocks": true, "DroppedBytes": true,
+	},
+}
+
+// promType returns the prometheus type of a stats field, srt reports its
+// totals with a Total suffix.
+func promType(kind, field string) MetricType {
+	if promCounters[kind][field] || (kind == kindSrt && strings.HasSuffix(field, "Total")) {
+		return Counter
+	}
+	return Gauge
+}
+
+// AddRuntime adds go runtime metrics.
+func (p *PrometheusMetrics) AddRuntime() {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	p.Add("build_info", Gauge, map[string]string{"version": version.CombinedVersion, "goversion": runtime.Version()}, 1)
+	p.Add("go_goroutines", Gauge, nil, float64(runtime.NumGoroutine()))
+	p.Add("go_memstats_alloc_bytes", Gauge, nil, float64(mem.Alloc))
+	p.Add("go_memstats_heap_inuse_bytes", Gauge, nil, float64(mem.HeapInuse))
+	p.Add("go_memstats_heap_objects", Gauge, nil, float64(mem.HeapObjects))
+	p.Add("go_memstats_sys_bytes", Gauge, nil, float64(mem.Sys))
+	p.Add("go_memstats_mallocs_total", Counter, nil, float64(mem.Mallocs))
+	p.Add("go_memstats_frees_total", Counter, nil, float64(mem.Frees))
+	p.Add("go_gc_cycles_total", Counter, nil, float64(mem.NumGC))
+	p.Add("go_gc_pause_seconds_total", Counter, nil, float64(mem.PauseTotalNs)/float64(time.Second))
+}
+
+// WriteTo writes all metrics in the prometheus text exposition format.
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	names := make([]string, 0, len(p.metrics))
+	for name := range p.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	var written int64
+	for _, name := range names {
+		m := p.metrics[name]
+		sort.Slice(m.lines, func(i, j int) bool {
+			return m.lines[i].labels < m.lines[j].labels
+		})
+		n, err := fmt.Fprintf(bw, "# TYPE %s %s\n", name, m.typ)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		for _, l := range m.lines {
+			n, err := fmt.Fprintf(bw, "%s%s %g\n", name, l.labels, l.value)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, bw.Flush()
+}
+
+// labelString renders labels as {a="b",c="d"} in sorted order.
+func labelString(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, "\n", `\n`)
+	return strings.ReplaceAll(v, `"`, `\"`)
+}
+
+// metricName converts a go field name like PktSentTotal to pkt_sent_total.
+func metricName(field string) string {
+	var b strings.Builder
+	runes := []rune(field)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -13,8 +13,12 @@ import (
 	"time"
 
 	"code.videolan.org/rist/ristgo/libristwrapper"
+	"github.com/EmadHeravi/streamsow/input/udp/udpstats"
 	"github.com/EmadHeravi/streamsow/logging"
+	"github.com/EmadHeravi/streamsow/mainloop/queuestats"
 	"github.com/EmadHeravi/streamsow/output/dektecasi/dtstats"
+	"github.com/EmadHeravi/streamsow/output/srt/srtstats"
+	"github.com/EmadHeravi/streamsow/ts/tsstats"
 	"github.com/haivision/srtgo"
 	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 )
@@ -70,6 +74,11 @@ type wrappedSrtStats struct {
 	*srtgo.SrtStats
 }
 
+type wrappedSrtListenerStats struct {
+	*statsPrepend
+	*srtstats.ListenerStats
+}
+
 type wrappedRistReceiverFlowStats struct {
 	*statsPrepend
 	*libristwrapper.ReceiverFlowStats
@@ -85,6 +94,26 @@ type wrappedDektecAsiStats struct {
 	*dtstats.DektecAsiStats
 }
 
+type wrappedHitlessStats struct {
+	*statsPrepend
+	*udpstats.HitlessStats
+}
+
+type wrappedUDPInputStats struct {
+	*statsPrepend
+	*udpstats.InputStats
+}
+
+type wrappedQueueStats struct {
+	*statsPrepend
+	*queuestats.QueueStats
+}
+
+type wrappedTR101290Stats struct {
+	*statsPrepend
+	*tsstats.TR101290Stats
+}
+
 func (s *Stats) HandleStats(Host, identifier string, u *url.URL, stats interface{}) {
 	now := time.Now()
 	prepend := &statsPrepend{now.Format("2006-01-02T15:04:05-0700"), "", Host}
@@ -94,6 +123,9 @@ func (s *Stats) HandleStats(Host, identifier string, u *url.URL, stats interface
 		case *srtgo.SrtStats:
 			prepend.Type = "SrtStats"
 			wrappedStats = &wrappedSrtStats{prepend, v}
+		case *srtstats.ListenerStats:
+			prepend.Type = "SrtListenerStats"
+			wrappedStats = &wrappedSrtListenerStats{prepend, v}
 		case *libristwrapper.ReceiverFlowStats:
 			prepend.Type = "RistReceiverStats"
 			wrappedStats = &wrappedRistReceiverFlowStats{prepend, v}
@@ -103,6 +135,18 @@ func (s *Stats) HandleStats(Host, identifier string, u *url.URL, stats interface
 		case *dtstats.DektecAsiStats:
 			prepend.Type = "DektecAsiStats"
 			wrappedStats = &wrappedDektecAsiStats{prepend, v}
+		case *udpstats.HitlessStats:
+			prepend.Type = "HitlessStats"
+			wrappedStats = &wrappedHitlessStats{prepend, v}
+		case *udpstats.InputStats:
+			prepend.Type = "UdpInputStats"
+			wrappedStats = &wrappedUDPInputStats{prepend, v}
+		case *queuestats.QueueStats:
+			prepend.Type = "OutputQueueStats"
+			wrappedStats = &wrappedQueueStats{prepend, v}
+		case *tsstats.TR101290Stats:
+			prepend.Type = "TR101290Stats"
+			wrappedStats = &wrappedTR101290Stats{prepend, v}
 		default:
 			panic("unhandled stats")
 		}
@@ -121,6 +165,8 @@ func (s *Stats) HandleStats(Host, identifier string, u *url.URL, stats interface
 		}
 	}
 
+	s.storePrometheusStats(Host, identifier, u, stats)
+
 	if influxDBWriteApi != nil {
 		s.writeInfluxStats(Host, identifier, u, stats)
 	}
@@ -0,0 +1,322 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"sync"
+	"time"
+
+	"github.com/EmadHeravi/streamsow/ts/tsstats"
+)
+
+const (
+	// packets with a bad sync byte before sync is lost
+	syncLossPackets = 2
+	// packets with a good sync byte before sync is acquired
+	syncAcquirePackets = 5
+
+	DefaultPSITimeout = 500 * time.Millisecond
+	DefaultPIDTimeout = 5 * time.Second
+)
+
+// AnalyzerSettings configures the TR 101 290 timeouts.
+type AnalyzerSettings struct {
+	// max interval between PAT and PMT sections
+	PSITimeout time.Duration
+	// max interval between packets of a PID referenced in a PMT
+	PIDTimeout time.Duration
+}
+
+type pidstate struct {
+	cc       uint8
+	hasCC    bool
+	dups     int
+	lastSeen time.Time
+}
+
+type pmtstate struct {
+	asm      SectionAssembler
+	program  uint16
+	version  int
+	pids     []uint16
+	lastSeen time.Time
+}
+
+// Analyzer checks a transport stream for TR 101 290 priority 1 errors.
+type Analyzer struct {
+	lock       sync.Mutex
+	settings   AnalyzerSettings
+	stats      tsstats.TR101290Stats
+	goodSync   int
+	badSync    int
+	lastPacket time.Time
+	pids       map[uint16]*pidstate
+	patAsm     SectionAssembler
+	patVersion int
+	lastPAT    time.Time
+	pmts       map[uint16]*pmtstate
+	// PIDs referenced by the PMTs, mapped to the time they were referenced
+	referenced map[uint16]time.Time
+}
+
+// NewAnalyzer creates an analyzer, zero timeouts are set to their default.
+func NewAnalyzer(settings AnalyzerSettings) *Analyzer {
+	if settings.PSITimeout <= 0 {
+		settings.PSITimeout = DefaultPSITimeout
+	}
+	if settings.PIDTimeout <= 0 {
+		settings.PIDTimeout = DefaultPIDTimeout
+	}
+	return &Analyzer{
+		settings:   settings,
+		pids:       make(map[uint16]*pidstate),
+		patVersion: -1,
+		pmts:       make(map[uint16]*pmtstate),
+		referenced: make(map[uint16]time.Time),
+	}
+}
+
+// Settings returns the settings the analyzer was created with.
+func (a *Analyzer) Settings() AnalyzerSettings {
+	return a.settings
+}
+
+// Stats returns the error counters.
+func (a *Analyzer) Stats() tsstats.TR101290Stats {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	s := a.stats
+	s.Programs = len(a.pmts)
+	return s
+}
+
+// Analyze checks all packets in data, a trailing partial packet counts as a
+// sync byte error.
+func (a *Analyzer) Analyze(data []byte, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.lastPacket = now
+	for offset := 0; offset < len(data); offset += PacketSize {
+		if offset+PacketSize > len(data) {
+			a.stats.Packets++
+			a.syncByte(false)
+			break
+		}
+		a.packet(data[offset:offset+PacketSize], now)
+	}
+}
+
+// syncByte tracks sync acquisition and loss, it returns true when the packet
+// should be analyzed.
+func (a *Analyzer) syncByte(ok bool) bool {
+	if !ok {
+		a.goodSync = 0
+		if !a.stats.InSync {
+			return false
+		}
+		a.stats.SyncByteErrors++
+		a.badSync++
+		if a.badSync >= syncLossPackets {
+			a.stats.InSync = false
+			a.stats.SyncLoss++
+		}
+		return false
+	}
+	a.badSync = 0
+	if !a.stats.InSync {
+		a.goodSync++
+		if a.goodSync < syncAcquirePackets {
+			return false
+		}
+		a.stats.InSync = true
+		// packets were skipped while out of sync
+		for _, st := range a.pids {
+			st.hasCC = false
+		}
+	}
+	return true
+}
+
+func (a *Analyzer) packet(p []byte, now time.Time) {
+	a.stats.Packets++
+	if !a.syncByte(p[0] == SyncByte) || TransportError(p) {
+		return
+	}
+	pid := PID(p)
+	if pid == NullPID {
+		return
+	}
+	a.continuity(pid, p, now)
+
+	if pid == PATPID {
+		a.pat(p, now)
+		return
+	}
+	if pmt, ok := a.pmts[pid]; ok {
+		a.pmt(pmt, p, now)
+	}
+}
+
+// continuity implements 1.4 Continuity_count_error, a single duplicate
+// packet is allowed.
+func (a *Analyzer) continuity(pid uint16, p []byte, now time.Time) {
+	st, ok := a.pids[pid]
+	if !ok {
+		st = &pidstate{}
+		a.pids[pid] = st
+	}
+	st.lastSeen = now
+	if !HasPayload(p) {
+		return
+	}
+	cc := ContinuityCounter(p)
+	switch {
+	case !st.hasCC || Discontinuity(p):
+		st.dups = 0
+	case cc == st.cc:
+		st.dups++
+		if st.dups > 1 {
+			a.stats.CCErrors++
+		}
+	case cc != (st.cc+1)&0x0f:
+		a.stats.CCErrors++
+		st.dups = 0
+	default:
+		st.dups = 0
+	}
+	st.cc = cc
+	st.hasCC = true
+}
+
+// pat implements the table_id and scrambling part of 1.3 PAT_error_2.
+func (a *Analyzer) pat(p []byte, now time.Time) {
+	if Scrambled(p) {
+		a.stats.PatErrors++
+		return
+	}
+	for _, section := range a.patAsm.Push(p) {
+		if section[0] != TableIDPAT {
+			a.stats.PatErrors++
+			continue
+		}
+		pat, err := ParsePAT(section)
+		if err != nil {
+			continue
+		}
+		a.lastPAT = now
+		if int(pat.Version) == a.patVersion && pat.SectionNumber == 0 && pat.LastSectionNumber == 0 {
+			continue
+		}
+		a.updatePrograms(pat, now)
+	}
+}
+
+// updatePrograms updates the set of PMT PIDs, multi section PATs are merged
+// until the version changes.
+func (a *Analyzer) updatePrograms(pat *PAT, now time.Time) {
+	if int(pat.Version) != a.patVersion {
+		a.patVersion = int(pat.Version)
+		keep := make(map[uint16]bool)
+		for _, prog := range pat.Programs {
+			keep[prog.PID] = true
+		}
+		for pid := range a.pmts {
+			if !keep[pid] {
+				delete(a.pmts, pid)
+			}
+		}
+	}
+	for _, prog := range pat.Programs {
+		if prog.Number == 0 {
+			continue
+		}
+		if pmt, ok := a.pmts[prog.PID]; ok && pmt.program == prog.Number {
+			continue
+		}
+		a.pmts[prog.PID] = &pmtstate{program: prog.Number, version: -1, lastSeen: now}
+	}
+	a.updateReferenced(now)
+}
+
+// pmt implements the table_id and scrambling part of 1.5 PMT_error_2.
+func (a *Analyzer) pmt(st *pmtstate, p []byte, now time.Time) {
+	if Scrambled(p) {
+		a.stats.PmtErrors++
+		return
+	}
+	for _, section := range st.asm.Push(p) {
+		if section[0] != TableIDPMT {
+			a.stats.PmtErrors++
+			continue
+		}
+		pmt, err := ParsePMT(section)
+		if err != nil || pmt.TableIDExtension != st.program {
+			continue
+		}
+		st.lastSeen = now
+		if int(pmt.Version) == st.version {
+			continue
+		}
+		st.version = int(pmt.Version)
+		st.pids = st.pids[:0]
+		if pmt.PCRPID != NullPID {
+			st.pids = append(st.pids, pmt.PCRPID)
+		}
+		for _, es := range pmt.Streams {
+			st.pids = append(st.pids, es.PID)
+		}
+		a.updateReferenced(now)
+	}
+}
+
+func (a *Analyzer) updateReferenced(now time.Time) {
+	referenced := make(map[uint16]time.Time)
+	for _, pmt := range a.pmts {
+		for _, pid := range pmt.pids {
+			if t, ok := a.referenced[pid]; ok {
+				referenced[pid] = t
+			} else {
+				referenced[pid] = now
+			}
+		}
+	}
+	a.referenced = referenced
+}
+
+// Check implements the timeout parts of 1.3, 1.5 and 1.6, it should be
+// called periodically. Timeouts aren't counted while the input delivers no
+// data at all, the flow itself reports that.
+func (a *Analyzer) Check(now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if !a.stats.InSync || now.Sub(a.lastPacket) > a.settings.PSITimeout {
+		return
+	}
+	if a.lastPAT.IsZero() {
+		a.lastPAT = now
+	}
+	if now.Sub(a.lastPAT) > a.settings.PSITimeout {
+		a.stats.PatErrors++
+		a.lastPAT = now
+	}
+	for _, pmt := range a.pmts {
+		if now.Sub(pmt.lastSeen) > a.settings.PSITimeout {
+			a.stats.PmtErrors++
+			pmt.lastSeen = now
+		}
+	}
+	for pid, since := range a.referenced {
+		last := since
+		if st, ok := a.pids[pid]; ok && st.lastSeen.After(last) {
+			last = st.lastSeen
+		}
+		if now.Sub(last) > a.settings.PIDTimeout {
+			a.stats.PidErrors++
+			a.referenced[pid] = now
+		}
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EmadHeravi/streamsow/ts/tsstats"
+)
+
+// syncedAnalyzer returns an analyzer that acquired sync at now.
+func syncedAnalyzer(t *testing.T, now time.Time) *Analyzer {
+	t.Helper()
+	a := NewAnalyzer(AnalyzerSettings{})
+	for i := 0; i < syncAcquirePackets; i++ {
+		a.Analyze(NullPacket(), now)
+	}
+	if !a.Stats().InSync {
+		t.Fatal("sync not acquired")
+	}
+	return a
+}
+
+func badSync() []byte {
+	p := NullPacket()
+	p[0] = 0x48
+	return p
+}
+
+func packets(p ...[]byte) []byte {
+	var data []byte
+	for _, packet := range p {
+		data = append(data, packet...)
+	}
+	return data
+}
+
+func TestAnalyzerSync(t *testing.T) {
+	now := time.Now()
+	a := NewAnalyzer(AnalyzerSettings{})
+	for i := 0; i < syncAcquirePackets-1; i++ {
+		a.Analyze(NullPacket(), now)
+	}
+	if a.Stats().InSync {
+		t.Fatalf("sync acquired after %d packets", syncAcquirePackets-1)
+	}
+	// a bad packet restarts acquisition and isn't an error without sync
+	a.Analyze(packets(badSync(), NullPacket(), NullPacket(), NullPacket(), NullPacket()), now)
+	if st := a.Stats(); st.InSync || st.SyncByteErrors != 0 {
+		t.Fatalf("in sync %v with %d sync byte errors after a bad packet", st.InSync, st.SyncByteErrors)
+	}
+	a.Analyze(NullPacket(), now)
+	if !a.Stats().InSync {
+		t.Fatal("sync not acquired")
+	}
+
+	// a single bad packet keeps sync
+	a.Analyze(packets(badSync(), NullPacket()), now)
+	if st := a.Stats(); !st.InSync || st.SyncByteErrors != 1 || st.SyncLoss != 0 {
+		t.Fatalf("after a bad packet: %+v", st)
+	}
+	a.Analyze(packets(badSync(), badSync(), badSync()), now)
+	st := a.Stats()
+	if st.InSync || st.SyncByteErrors != 3 || st.SyncLoss != 1 {
+		t.Fatalf("after %d bad packets: %+v", syncLossPackets+1, st)
+	}
+	if st.Packets != 15 {
+		t.Fatalf("%d packets, want 15", st.Packets)
+	}
+
+	a = syncedAnalyzer(t, now)
+	// a trailing partial packet is a sync byte error
+	a.Analyze(NullPacket()[:100], now)
+	if st := a.Stats(); st.SyncByteErrors != 1 {
+		t.Fatalf("%d sync byte errors after a partial packet, want 1", st.SyncByteErrors)
+	}
+}
+
+func ccPacket(cc int) []byte {
+	return testPacket(0x100, cc)
+}
+
+// discontinuityPacket returns a packet with payload and the
+// discontinuity_indicator set.
+func discontinuityPacket(cc int) []byte {
+	p := testPacket(0x100, cc)
+	p[3] = 0x30 | byte(cc&0x0f)
+	p[4] = 1
+	p[5] = 0x80
+	return p
+}
+
+func TestAnalyzerContinuity(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets [][]byte
+		errors  int
+	}{
+		{"in order", [][]byte{ccPacket(14), ccPacket(15), ccPacket(0), ccPacket(1)}, 0},
+		{"gap", [][]byte{ccPacket(0), ccPacket(1), ccPacket(3), ccPacket(4)}, 1},
+		{"out of order", [][]byte{ccPacket(0), ccPacket(2), ccPacket(1)}, 2},
+		{"one duplicate", [][]byte{ccPacket(0), ccPacket(1), ccPacket(1), ccPacket(2)}, 0},
+		{"two duplicates", [][]byte{ccPacket(0), ccPacket(1), ccPacket(1), ccPacket(1), ccPacket(2)}, 1},
+		{"duplicates of different packets", [][]byte{ccPacket(0), ccPacket(0), ccPacket(1), ccPacket(1)}, 0},
+		{"discontinuity indicator", [][]byte{ccPacket(0), ccPacket(1), discontinuityPacket(7), ccPacket(8)}, 0},
+		{"gap after discontinuity", [][]byte{ccPacket(0), discontinuityPacket(7), ccPacket(9)}, 1},
+		{"no payload", [][]byte{ccPacket(0), PCRPacket(0x100, 0, 0), PCRPacket(0x100, 0, 5), ccPacket(1)}, 0},
+		{"first packet", [][]byte{ccPacket(9), ccPacket(10)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := syncedAnalyzer(t, time.Now())
+			a.Analyze(packets(tt.packets...), time.Now())
+			if st := a.Stats(); st.CCErrors != tt.errors {
+				t.Fatalf("%d cc errors, want %d", st.CCErrors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestAnalyzerContinuityResync(t *testing.T) {
+	now := time.Now()
+	a := syncedAnalyzer(t, now)
+	a.Analyze(packets(ccPacket(0), ccPacket(1)), now)
+	// packets skipped while out of sync aren't continuity errors
+	a.Analyze(packets(badSync(), badSync()), now)
+	for i := 0; i < syncAcquirePackets; i++ {
+		a.Analyze(NullPacket(), now)
+	}
+	a.Analyze(ccPacket(9), now)
+	if st := a.Stats(); !st.InSync || st.CCErrors != 0 {
+		t.Fatalf("after resync: %+v", st)
+	}
+}
+
+func scrambled(data []byte) []byte {
+	data[3] |= 0x80
+	return data
+}
+
+func TestAnalyzerPSIErrors(t *testing.T) {
+	pat := &PAT{
+		SectionHeader: SectionHeader{TableIDExtension: 1, CurrentNext: true},
+		Programs:      []Program{{Number: 1, PID: testPMT1}},
+	}
+	tests := []struct {
+		name  string
+		data  []byte
+		stats tsstats.TR101290Stats
+	}{
+		{"valid", testStream(), tsstats.TR101290Stats{Programs: 2}},
+		{"pmt on the pat pid", testSection(PATPID, testPMTSection(1)), tsstats.TR101290Stats{PatErrors: 1}},
+		{"scrambled pat", scrambled(testSection(PATPID, pat.Marshal())), tsstats.TR101290Stats{PatErrors: 1}},
+		{
+			"pat on a pmt pid",
+			packets(testSection(PATPID, pat.Marshal()), testSection(testPMT1, pat.Marshal())),
+			tsstats.TR101290Stats{PmtErrors: 1, Programs: 1},
+		},
+		{
+			"scrambled pmt",
+			packets(testSection(PATPID, pat.Marshal()), scrambled(testSection(testPMT1, testPMTSection(1)))),
+			tsstats.TR101290Stats{PmtErrors: 1, Programs: 1},
+		},
+		{
+			"pmt of another program",
+			packets(testSection(PATPID, pat.Marshal()), testSection(testPMT1, testPMTSection(2))),
+			tsstats.TR101290Stats{Programs: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := syncedAnalyzer(t, time.Now())
+			a.Analyze(tt.data, time.Now())
+			st := a.Stats()
+			st.Packets, st.InSync = 0, false
+			if st != tt.stats {
+				t.Fatalf("stats %+v, want %+v", st, tt.stats)
+			}
+		})
+	}
+}
+
+// testPMTSection returns a PMT section of program with a single stream.
+func testPMTSection(program uint16) []byte {
+	pmt := &PMT{
+		SectionHeader: SectionHeader{TableIDExtension: program, CurrentNext: true},
+		PCRPID:        0x100,
+		Streams:       []Stream{{Type: 0x1b, PID: 0x100}},
+	}
+	return pmt.Marshal()
+}
+
+func TestAnalyzerTimeouts(t *testing.T) {
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	a := syncedAnalyzer(t, start)
+	a.Analyze(testStream(), start)
+
+	check := func(d time.Duration, pat, pmt, pid int) {
+		t.Helper()
+		// keep the input delivering data
+		a.Analyze(NullPacket(), at(d))
+		a.Check(at(d))
+		st := a.Stats()
+		if st.PatErrors != pat || st.PmtErrors != pmt || st.PidErrors != pid {
+			t.Fatalf("after %s: %d pat, %d pmt and %d pid errors, want %d, %d and %d", d, st.PatErrors, st.PmtErrors, st.PidErrors, pat, pmt, pid)
+		}
+	}
+	check(DefaultPSITimeout, 0, 0, 0)
+	check(DefaultPSITimeout+time.Millisecond, 1, 2, 0)
+	// the psi tables are repeated, the elementary streams aren't
+	a.Analyze(testStream()[:5*PacketSize], at(DefaultPSITimeout+time.Millisecond))
+	check(2*DefaultPSITimeout, 1, 2, 0)
+	check(DefaultPIDTimeout, 2, 4, 0)
+	// the 4 elementary streams timed out, the network PID isn't referenced
+	check(DefaultPIDTimeout+time.Millisecond, 2, 4, 4)
+}
+
+func TestAnalyzerNoTimeouts(t *testing.T) {
+	start := time.Now()
+	a := syncedAnalyzer(t, start)
+	a.Analyze(testStream(), start)
+	// the input stopped delivering data
+	a.Check(start.Add(time.Minute))
+	if st := a.Stats(); st.P1Errors() != 0 {
+		t.Fatalf("errors without data: %+v", st)
+	}
+	// out of sync
+	a.Analyze(packets(badSync(), badSync()), start.Add(time.Minute))
+	a.Check(start.Add(time.Minute + DefaultPIDTimeout))
+	if st := a.Stats(); st.PatErrors != 0 || st.PmtErrors != 0 || st.PidErrors != 0 {
+		t.Fatalf("timeouts without sync: %+v", st)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import "encoding/binary"
+
+// PCRClock is the frequency of the program clock reference.
+const PCRClock = 27000000
+
+// PCRWrap is the value the 33 bit base and 9 bit extension wrap at.
+const PCRWrap = (1 << 33) * 300
+
+// longSection builds a long form section with the data following the
+// header and appends the CRC.
+func longSection(h SectionHeader, data []byte) []byte {
+	length := sectionHeaderSize - 3 + len(data) + crcSize
+	section := make([]byte, 0, 3+length)
+	section = append(section,
+		h.TableID,
+		0xb0|byte(length>>8)&0x0f,
+		byte(length),
+		byte(h.TableIDExtension>>8),
+		byte(h.TableIDExtension),
+		0xc0|(h.Version&0x1f)<<1,
+		h.SectionNumber,
+		h.LastSectionNumber,
+	)
+	if h.CurrentNext {
+		section[5] |= 0x01
+	}
+	section = append(section, data...)
+	crc := make([]byte, crcSize)
+	binary.BigEndian.PutUint32(crc, CRC32(section))
+	return append(section, crc...)
+}
+
+// Marshal returns the PAT as a section, TableIDExtension is the
+// transport_stream_id.
+func (p *PAT) Marshal() []byte {
+	h := p.SectionHeader
+	h.TableID = TableIDPAT
+	data := make([]byte, 0, 4*len(p.Programs))
+	for _, prog := range p.Programs {
+		data = append(data, byte(prog.Number>>8), byte(prog.Number), 0xe0|byte(prog.PID>>8), byte(prog.PID))
+	}
+	return longSection(h, data)
+}
+
+// Marshal returns the PMT as a section, TableIDExtension is the
+// program_number.
+func (p *PMT) Marshal() []byte {
+	h := p.SectionHeader
+	h.TableID = TableIDPMT
+	data := make([]byte, 0, 4+len(p.Descriptors)+5*len(p.Streams))
+	data = append(data,
+		0xe0|byte(p.PCRPID>>8), byte(p.PCRPID),
+		0xf0|byte(len(p.Descriptors)>>8)&0x0f, byte(len(p.Descriptors)))
+	data = append(data, p.Descriptors...)
+	for _, es := range p.Streams {
+		data = append(data,
+			es.Type,
+			0xe0|byte(es.PID>>8), byte(es.PID),
+			0xf0|byte(len(es.Descriptors)>>8)&0x0f, byte(len(es.Descriptors)))
+		data = append(data, es.Descriptors...)
+	}
+	return longSection(h, data)
+}
+
+// SectionPackets splits a section into packets of pid, the remainder of the
+// last packet is stuffed. cc is the continuity counter of the previous
+// packet of pid and is updated.
+func SectionPackets(pid uint16, section []byte, cc *uint8) [][]byte {
+	var packets [][]byte
+	first := true
+	for first || len(section) > 0 {
+		p := make([]byte, PacketSize)
+		p[0] = SyncByte
+		SetPID(p, pid)
+		*cc = (*cc + 1) & 0x0f
+		p[3] = 0x10 | *cc
+		payload := p[4:]
+		if first {
+			p[1] |= 0x40
+			payload[0] = 0 // pointer_field
+			payload = payload[1:]
+			first = false
+		}
+		n := copy(payload, section)
+		for i := n; i < len(payload); i++ {
+			payload[i] = 0xff
+		}
+		section = section[n:]
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+// NullPacket returns a new null packet.
+func NullPacket() []byte {
+	p := make([]byte, PacketSize)
+	p[0] = SyncByte
+	SetPID(p, NullPID)
+	p[3] = 0x10
+	for i := 4; i < PacketSize; i++ {
+		p[i] = 0xff
+	}
+	return p
+}
+
+// hasPCR returns true when the adaptation field carries a PCR.
+func hasPCR(p []byte) bool {
+	return adaptationFieldLength(p) >= 7 && p[5]&0x10 != 0
+}
+
+// PCR returns the program clock reference in 27MHz units.
+func PCR(p []byte) (uint64, bool) {
+	if !hasPCR(p) {
+		return 0, false
+	}
+	base := uint64(p[6])<<25 | uint64(p[7])<<17 | uint64(p[8])<<9 | uint64(p[9])<<1 | uint64(p[10])>>7
+	ext := uint64(p[10]&0x01)<<8 | uint64(p[11])
+	return base*300 + ext, true
+}
+
+// SetPCR replaces the program clock reference of a packet carrying one, it
+// returns false when the packet has none.
+func SetPCR(p []byte, pcr uint64) bool {
+	if !hasPCR(p) {
+		return false
+	}
+	pcr %= PCRWrap
+	base, ext := pcr/300, pcr%300
+	p[6] = byte(base >> 25)
+	p[7] = byte(base >> 17)
+	p[8] = byte(base >> 9)
+	p[9] = byte(base >> 1)
+	p[10] = byte(base&0x01)<<7 | 0x7e | byte(ext>>8)&0x01
+	p[11] = byte(ext)
+	return true
+}
+
+// PCRPacket returns an adaptation field only packet of pid carrying pcr,
+// it doesn't increment the continuity counter.
+func PCRPacket(pid uint16, pcr uint64, cc uint8) []byte {
+	p := make([]byte, PacketSize)
+	p[0] = SyncByte
+	SetPID(p, pid)
+	p[3] = 0x20 | cc&0x0f
+	p[4] = PacketSize - 5
+	p[5] = 0x10
+	for i := 12; i < PacketSize; i++ {
+		p[i] = 0xff
+	}
+	SetPCR(p, pcr)
+	return p
+}
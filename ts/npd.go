@@ -0,0 +1,138 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import "bytes"
+
+// Null packet deletion replaces the null packets of a group of up to
+// npdGroup packets by a single marker in front of the remaining packets.
+// The marker is a null packet itself, receivers unaware of it see a valid
+// stream, its payload is:
+//
+//	"SZNP" version(1) packets-in-group null-bitmap(bit n is packet n)
+const (
+	npdGroup   = 7
+	npdVersion = 1
+)
+
+var npdMagic = []byte("SZNP")
+
+// nullMarker returns the deletion marker of a group of n packets.
+func nullMarker(n int, bitmap uint8) []byte {
+	p := NullPacket()
+	payload := p[4:]
+	copy(payload, npdMagic)
+	payload[4] = npdVersion
+	payload[5] = byte(n)
+	payload[6] = bitmap
+	return p
+}
+
+// parseNullMarker returns the group size and bitmap of a deletion marker.
+func parseNullMarker(p []byte) (int, uint8, bool) {
+	if p[0] != SyncByte || PID(p) != NullPID || HasAdaptationField(p) {
+		return 0, 0, false
+	}
+	payload := p[4:]
+	if !bytes.Equal(payload[:4], npdMagic) || payload[4] != npdVersion {
+		return 0, 0, false
+	}
+	n := int(payload[5])
+	if n == 0 || n > npdGroup {
+		return 0, 0, false
+	}
+	return n, payload[6], true
+}
+
+// DeleteNulls returns data with the null packets removed and signalled,
+// groups with less than two null packets are passed unmodified as the
+// marker wouldn't save anything. It returns the number of packets removed.
+func DeleteNulls(data []byte) ([]byte, int) {
+	out := make([]byte, 0, len(data))
+	deleted := 0
+	for len(data) >= PacketSize {
+		n := len(data) / PacketSize
+		if n > npdGroup {
+			n = npdGroup
+		}
+		group := data[:n*PacketSize]
+		data = data[n*PacketSize:]
+		var bitmap uint8
+		nulls := 0
+		for i := 0; i < n; i++ {
+			p := group[i*PacketSize : (i+1)*PacketSize]
+			if p[0] == SyncByte && PID(p) == NullPID {
+				bitmap |= 1 << uint(i)
+				nulls++
+			}
+		}
+		if nulls < 2 {
+			out = append(out, group...)
+			continue
+		}
+		out = append(out, nullMarker(n, bitmap)...)
+		for i := 0; i < n; i++ {
+			if bitmap&(1<<uint(i)) == 0 {
+				out = append(out, group[i*PacketSize:(i+1)*PacketSize]...)
+			}
+		}
+		deleted += nulls - 1
+	}
+	return out, deleted
+}
+
+// NullInserter restores the null packets removed by DeleteNulls. It keeps
+// the position within a group, so groups may span blocks. After packet
+// loss the group is completed at the next marker.
+type NullInserter struct {
+	n      int
+	pos    int
+	bitmap uint8
+}
+
+// fill appends the null packets due at the current position.
+func (r *NullInserter) fill(out []byte) []byte {
+	for r.pos < r.n && r.bitmap&(1<<uint(r.pos)) != 0 {
+		out = append(out, NullPacket()...)
+		r.pos++
+	}
+	return out
+}
+
+// Process returns data with the deleted null packets reinserted, streams
+// without markers are returned unmodified.
+func (r *NullInserter) Process(data []byte) []byte {
+	var out []byte
+	for i := 0; i+PacketSize <= len(data); i += PacketSize {
+		p := data[i : i+PacketSize]
+		if n, bitmap, ok := parseNullMarker(p); ok {
+			if out == nil {
+				out = make([]byte, 0, len(data)+npdGroup*PacketSize)
+				out = append(out, data[:i]...)
+			}
+			r.n, r.pos, r.bitmap = n, 0, bitmap
+			out = r.fill(out)
+			continue
+		}
+		if out == nil {
+			if r.pos >= r.n {
+				continue
+			}
+			out = make([]byte, 0, len(data)+npdGroup*PacketSize)
+			out = append(out, data[:i]...)
+		}
+		out = append(out, p...)
+		if r.pos < r.n {
+			r.pos++
+			out = r.fill(out)
+		}
+	}
+	if out == nil {
+		return data
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"bytes"
+	"testing"
+)
+
+// npdStream returns a stream of len(pattern) packets, 'n' is a null packet,
+// any other character a unique packet on PID 0x100.
+func npdStream(pattern string) []byte {
+	var out []byte
+	for i, c := range pattern {
+		if c == 'n' {
+			out = append(out, NullPacket()...)
+			continue
+		}
+		out = append(out, testPacket(0x100, i)...)
+	}
+	return out
+}
+
+// chunks splits data in blocks of the given sizes in packets, cycling
+// through sizes.
+func chunks(data []byte, sizes ...int) [][]byte {
+	var out [][]byte
+	for i := 0; len(data) > 0; i++ {
+		n := sizes[i%len(sizes)] * PacketSize
+		if n > len(data) {
+			n = len(data)
+		}
+		out = append(out, data[:n])
+		data = data[n:]
+	}
+	return out
+}
+
+func TestNullDeletionRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		// packets per block at the sender and receiver
+		send, recv []int
+	}{
+		{"no nulls", "ppppppppppppppppppppp", []int{7}, []int{7}},
+		{"single nulls", "npppppppppnppppppppppn", []int{7}, []int{7}},
+		{"all nulls", "nnnnnnnnnnnnnnnnnnnnn", []int{7}, []int{7}},
+		{"leading", "nnppppppnnppppppnnppppp", []int{7}, []int{7}},
+		{"trailing", "pppppnnpppppnnpppppnn", []int{7}, []int{7}},
+		{"mixed", "pnpnpnpnnpnpnnnpnppnnpnnpnnnnp", []int{7}, []int{7}},
+		{"partial groups", "pnnpnpnnnpppnnpnnn", []int{4, 9, 3}, []int{7}},
+		{"split across blocks", "pnpnpnpnnpnpnnnpnppnnpnnpnnnnpnn", []int{7}, []int{1, 2, 5}},
+		{"single packet blocks", "nnpnnpnnpnnpnnpnnpnnp", []int{14}, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := npdStream(tt.pattern)
+			var deleted []byte
+			for _, block := range chunks(in, tt.send...) {
+				d, _ := DeleteNulls(block)
+				deleted = append(deleted, d...)
+			}
+			var inserter NullInserter
+			var out []byte
+			for _, block := range chunks(deleted, tt.recv...) {
+				out = append(out, inserter.Process(block)...)
+			}
+			if !bytes.Equal(out, in) {
+				t.Fatalf("restored stream differs: got %d packets, want %d", len(out)/PacketSize, len(in)/PacketSize)
+			}
+		})
+	}
+}
+
+func TestDeleteNullsCount(t *testing.T) {
+	// groups: "pnnpnpn" 4 nulls, "npppppp" 1 null, "nn" 2 nulls, a marker
+	// replaces the nulls of the groups with at least two of them
+	in := npdStream("pnnpnpnnppppppnn")
+	out, deleted := DeleteNulls(in)
+	if deleted != 4 {
+		t.Errorf("deleted %d packets, want 4", deleted)
+	}
+	if len(in)-len(out) != deleted*PacketSize {
+		t.Errorf("output is %d bytes shorter, want %d", len(in)-len(out), deleted*PacketSize)
+	}
+}
+
+func TestNullInsertionLostMarker(t *testing.T) {
+	// three groups each with a marker, the marker of the second is lost
+	groups := []string{"pnnpnpp", "ppnnnpp", "nnpppnp"}
+	var in, deleted []byte
+	for _, g := range groups {
+		p := npdStream(g)
+		in = append(in, p...)
+		d, _ := DeleteNulls(p)
+		deleted = append(deleted, d...)
+	}
+	markers := 0
+	var lost []byte
+	for i := 0; i < len(deleted); i += PacketSize {
+		p := deleted[i : i+PacketSize]
+		if _, _, ok := parseNullMarker(p); ok {
+			markers++
+			if markers == 2 {
+				continue
+			}
+		}
+		lost = append(lost, p...)
+	}
+	if markers != 3 {
+		t.Fatalf("found %d markers, want 3", markers)
+	}
+	var inserter NullInserter
+	out := inserter.Process(lost)
+
+	// the nulls of the second group are missing, all else is restored
+	g1, g2 := len(npdStream(groups[0])), len(npdStream(groups[0]+groups[1]))
+	var want []byte
+	want = append(want, in[:g1]...)
+	for i := g1; i < g2; i += PacketSize {
+		if PID(in[i:]) != NullPID {
+			want = append(want, in[i:i+PacketSize]...)
+		}
+	}
+	want = append(want, in[g2:]...)
+	if !bytes.Equal(out, want) {
+		t.Fatalf("restored stream differs: got %d packets, want %d", len(out)/PacketSize, len(want)/PacketSize)
+	}
+}
+
+func TestNullInsertionPassthrough(t *testing.T) {
+	in := npdStream("pnpnpppnnp")
+	var inserter NullInserter
+	out := inserter.Process(in)
+	if !bytes.Equal(out, in) {
+		t.Fatal("stream without markers was modified")
+	}
+}
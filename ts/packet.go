@@ -0,0 +1,96 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+// Package ts implements parsing of MPEG transport stream packets and PSI
+// tables. Packet helpers expect a single 188 byte packet.
+package ts
+
+const (
+	PacketSize = 188
+	SyncByte   = 0x47
+
+	PATPID  uint16 = 0x0000
+	NullPID uint16 = 0x1fff
+
+	TableIDPAT = 0x00
+	TableIDPMT = 0x02
+)
+
+// PID returns the packet identifier.
+func PID(p []byte) uint16 {
+	return uint16(p[1]&0x1f)<<8 | uint16(p[2])
+}
+
+// SetPID sets the packet identifier.
+func SetPID(p []byte, pid uint16) {
+	p[1] = p[1]&0xe0 | byte(pid>>8)&0x1f
+	p[2] = byte(pid)
+}
+
+// TransportError returns the transport_error_indicator.
+func TransportError(p []byte) bool {
+	return p[1]&0x80 != 0
+}
+
+// PayloadUnitStart returns the payload_unit_start_indicator.
+func PayloadUnitStart(p []byte) bool {
+	return p[1]&0x40 != 0
+}
+
+// Scrambled returns true when transport_scrambling_control is set.
+func Scrambled(p []byte) bool {
+	return p[3]&0xc0 != 0
+}
+
+// HasAdaptationField returns true when the packet carries an adaptation field.
+func HasAdaptationField(p []byte) bool {
+	return p[3]&0x20 != 0
+}
+
+// HasPayload returns true when the packet carries payload.
+func HasPayload(p []byte) bool {
+	return p[3]&0x10 != 0
+}
+
+// ContinuityCounter returns the 4 bit continuity counter.
+func ContinuityCounter(p []byte) uint8 {
+	return p[3] & 0x0f
+}
+
+// SetContinuityCounter sets the 4 bit continuity counter.
+func SetContinuityCounter(p []byte, cc uint8) {
+	p[3] = p[3]&0xf0 | cc&0x0f
+}
+
+// adaptationFieldLength returns the adaptation field length, 0 when there
+// is none.
+func adaptationFieldLength(p []byte) int {
+	if !HasAdaptationField(p) {
+		return 0
+	}
+	return int(p[4])
+}
+
+// Discontinuity returns the discontinuity_indicator of the adaptation field.
+func Discontinuity(p []byte) bool {
+	return adaptationFieldLength(p) > 0 && p[5]&0x80 != 0
+}
+
+// Payload returns the payload of the packet, nil when there is none or the
+// adaptation field is invalid.
+func Payload(p []byte) []byte {
+	if !HasPayload(p) {
+		return nil
+	}
+	offset := 4
+	if HasAdaptationField(p) {
+		offset += 1 + adaptationFieldLength(p)
+	}
+	if offset >= PacketSize {
+		return nil
+	}
+	return p[offset:PacketSize]
+}
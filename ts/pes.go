@@ -0,0 +1,96 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+const (
+	StreamTypeMPEG1Video = 0x01
+	StreamTypeMPEG2Video = 0x02
+	StreamTypeMPEG4Video = 0x10
+	StreamTypeH264       = 0x1b
+	StreamTypeHEVC       = 0x24
+
+	// PTS wraps at 2^33
+	PTSWrap = 1 << 33
+)
+
+// IsVideo returns true for the video stream types keyframes are detected for.
+func IsVideo(streamType uint8) bool {
+	switch streamType {
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video, StreamTypeMPEG4Video, StreamTypeH264, StreamTypeHEVC:
+		return true
+	}
+	return false
+}
+
+// RandomAccess returns the random_access_indicator of the adaptation field.
+func RandomAccess(p []byte) bool {
+	return adaptationFieldLength(p) > 0 && p[5]&0x40 != 0
+}
+
+// PESPTS returns the PTS of the PES packet starting in payload.
+func PESPTS(payload []byte) (int64, bool) {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return 0, false
+	}
+	if payload[7]&0x80 == 0 {
+		return 0, false
+	}
+	b := payload[9:14]
+	pts := int64(b[0]>>1&0x07)<<30 |
+		int64(b[1])<<22 | int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 | int64(b[4]>>1)
+	return pts, true
+}
+
+// pesData returns the elementary stream data of the PES packet starting in
+// payload.
+func pesData(payload []byte) []byte {
+	if len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return nil
+	}
+	offset := 9 + int(payload[8])
+	if offset > len(payload) {
+		return nil
+	}
+	return payload[offset:]
+}
+
+// ContainsKeyframe scans the start of a PES packet for the start of a
+// keyframe: an IDR (H.264), IRAP (HEVC) or I-frame picture (MPEG-2/4).
+func ContainsKeyframe(streamType uint8, payload []byte) bool {
+	es := pesData(payload)
+	for i := 0; i+4 < len(es); i++ {
+		if es[i] != 0 || es[i+1] != 0 || es[i+2] != 1 {
+			continue
+		}
+		code := es[i+3]
+		switch streamType {
+		case StreamTypeH264:
+			if code&0x1f == 5 {
+				return true
+			}
+		case StreamTypeHEVC:
+			if t := code >> 1 & 0x3f; t >= 16 && t <= 21 {
+				return true
+			}
+		case StreamTypeMPEG1Video, StreamTypeMPEG2Video:
+			// sequence header, in practice only sent before I-frames
+			if code == 0xb3 {
+				return true
+			}
+			if code == 0x00 && i+5 < len(es) && es[i+5]>>3&0x07 == 1 {
+				return true
+			}
+		case StreamTypeMPEG4Video:
+			// I-VOP
+			if code == 0xb6 && es[i+4]>>6 == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,261 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+// ProcessorConfig selects the PIDs an output receives. PAT and PMTs are
+// always passed and rewritten to only reference the PIDs passed.
+type ProcessorConfig struct {
+	// when not zero only this program is passed, with its PMT, elementary
+	// streams, PCR PID and the reserved PSI/SI PIDs
+	Program uint16
+	// handling of the SDT and EIT when a program is selected
+	SI SIMode
+	// when not empty only these PIDs are passed
+	Keep []uint16
+	// PIDs dropped, dropping a PMT PID removes its program from the PAT
+	Drop []uint16
+	// PIDs renumbered, applies to the PAT and PMTs as well. A remap target
+	// that is sent as well collides, its own packets are dropped.
+	Remap map[uint16]uint16
+}
+
+type pmtRewrite struct {
+	asm SectionAssembler
+	cc  uint8
+}
+
+// Processor filters and remaps the packets of a transport stream, it keeps
+// the state of the PSI tables so a processor must only be used for a single
+// stream.
+type Processor struct {
+	keep   map[uint16]bool
+	drop   map[uint16]bool
+	remap  map[uint16]uint16
+	patAsm SectionAssembler
+	patCC  uint8
+	pmts   map[uint16]*pmtRewrite
+
+	// remap targets, and the ones seen colliding with a sent PID
+	targets    map[uint16]bool
+	collided   map[uint16]bool
+	collisions []uint16
+
+	program uint16
+	si      SIMode
+	// elementary stream and PCR PIDs of the selected program
+	programPIDs map[uint16]bool
+	sdtAsm      SectionAssembler
+	sdtCC       uint8
+	eitAsm      SectionAssembler
+	eitCC       uint8
+}
+
+func pidSet(pids []uint16) map[uint16]bool {
+	if len(pids) == 0 {
+		return nil
+	}
+	set := make(map[uint16]bool, len(pids))
+	for _, pid := range pids {
+		set[pid] = true
+	}
+	return set
+}
+
+// NewProcessor creates a processor for a single stream.
+func NewProcessor(c ProcessorConfig) *Processor {
+	p := &Processor{
+		keep:  pidSet(c.Keep),
+		drop:  pidSet(c.Drop),
+		remap: make(map[uint16]uint16, len(c.Remap)),
+		pmts:  make(map[uint16]*pmtRewrite),
+		patCC: 0x0f,
+
+		targets:  make(map[uint16]bool, len(c.Remap)),
+		collided: make(map[uint16]bool),
+
+		program:     c.Program,
+		si:          c.SI,
+		programPIDs: make(map[uint16]bool),
+		sdtCC:       0x0f,
+		eitCC:       0x0f,
+	}
+	if p.si == "" {
+		p.si = SIFilter
+	}
+	for from, to := range c.Remap {
+		p.remap[from] = to
+		p.targets[to] = true
+	}
+	return p
+}
+
+// passes returns true when packets of pid are passed.
+func (p *Processor) passes(pid uint16) bool {
+	if p.drop[pid] {
+		return false
+	}
+	if p.keep != nil && !p.keep[pid] {
+		return false
+	}
+	if p.program != 0 && pid >= FirstUserPID && pid != NullPID && !p.programPIDs[pid] {
+		return false
+	}
+	return !p.collides(pid)
+}
+
+// collides returns true when pid is a remap target without being remapped
+// itself, the remapped PID takes its place.
+func (p *Processor) collides(pid uint16) bool {
+	if _, ok := p.remap[pid]; ok || !p.targets[pid] {
+		return false
+	}
+	if !p.collided[pid] {
+		p.collided[pid] = true
+		p.collisions = append(p.collisions, pid)
+	}
+	return true
+}
+
+// Collisions returns the PIDs found colliding with a remap target since the
+// last call, each PID is reported once.
+func (p *Processor) Collisions() []uint16 {
+	c := p.collisions
+	p.collisions = nil
+	return c
+}
+
+func (p *Processor) mapPID(pid uint16) uint16 {
+	if to, ok := p.remap[pid]; ok {
+		return to
+	}
+	return pid
+}
+
+// Process returns the processed packets of data, which must consist of
+// complete packets. Data is not modified, a trailing partial packet is
+// dropped.
+func (p *Processor) Process(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for len(data) >= PacketSize {
+		packet := data[:PacketSize]
+		data = data[PacketSize:]
+		if packet[0] != SyncByte {
+			// leave unsynced data for the receivers to report
+			out = append(out, packet...)
+			continue
+		}
+		pid := PID(packet)
+		if pid == PATPID {
+			for _, section := range p.patAsm.Push(packet) {
+				out = p.rewritePAT(section, out)
+			}
+			continue
+		}
+		if p.program != 0 && (pid == SDTPID || pid == EITPID) && p.si != SIPass {
+			if p.si == SIFilter && p.passes(pid) {
+				out = p.filterSI(pid, packet, out)
+			}
+			continue
+		}
+		if pmt, ok := p.pmts[pid]; ok {
+			for _, section := range pmt.asm.Push(packet) {
+				out = p.rewritePMT(pid, pmt, section, out)
+			}
+			continue
+		}
+		if !p.passes(pid) {
+			continue
+		}
+		start := len(out)
+		out = append(out, packet...)
+		if to, ok := p.remap[pid]; ok {
+			SetPID(out[start:], to)
+		}
+	}
+	return out
+}
+
+// rewritePAT removes the programs of dropped PMT PIDs and remaps the rest,
+// the network PID is treated like any other PID.
+func (p *Processor) rewritePAT(section []byte, out []byte) []byte {
+	pat, err := ParsePAT(section)
+	if err != nil {
+		return out
+	}
+	programs := pat.Programs[:0]
+	for _, prog := range pat.Programs {
+		if prog.Number == 0 {
+			if !p.passes(prog.PID) {
+				continue
+			}
+		} else {
+			if p.drop[prog.PID] || (p.program != 0 && prog.Number != p.program) {
+				continue
+			}
+			if _, ok := p.pmts[prog.PID]; !ok {
+				p.pmts[prog.PID] = &pmtRewrite{cc: 0x0f}
+			}
+		}
+		prog.PID = p.mapPID(prog.PID)
+		programs = append(programs, prog)
+	}
+	pat.Programs = programs
+	for _, packet := range SectionPackets(PATPID, pat.Marshal(), &p.patCC) {
+		out = append(out, packet...)
+	}
+	return out
+}
+
+// rewritePMT removes the streams that aren't passed and remaps the rest.
+func (p *Processor) rewritePMT(pid uint16, st *pmtRewrite, section []byte, out []byte) []byte {
+	pmt, err := ParsePMT(section)
+	if err != nil {
+		return out
+	}
+	if p.program != 0 && pmt.TableIDExtension == p.program {
+		p.programPIDs = map[uint16]bool{pmt.PCRPID: true}
+		for _, es := range pmt.Streams {
+			p.programPIDs[es.PID] = true
+		}
+	}
+	streams := pmt.Streams[:0]
+	for _, es := range pmt.Streams {
+		if !p.passes(es.PID) {
+			continue
+		}
+		es.PID = p.mapPID(es.PID)
+		streams = append(streams, es)
+	}
+	pmt.Streams = streams
+	pmt.PCRPID = p.mapPID(pmt.PCRPID)
+	for _, packet := range SectionPackets(p.mapPID(pid), pmt.Marshal(), &st.cc) {
+		out = append(out, packet...)
+	}
+	return out
+}
+
+// filterSI rewrites the SDT and EIT to the selected program.
+func (p *Processor) filterSI(pid uint16, packet []byte, out []byte) []byte {
+	asm, cc := &p.sdtAsm, &p.sdtCC
+	if pid == EITPID {
+		asm, cc = &p.eitAsm, &p.eitCC
+	}
+	for _, section := range asm.Push(packet) {
+		if pid == SDTPID {
+			section = filterSDT(section, p.program)
+		} else if !keepEIT(section, p.program) {
+			section = nil
+		}
+		if section == nil {
+			continue
+		}
+		for _, packet := range SectionPackets(p.mapPID(pid), section, cc) {
+			out = append(out, packet...)
+		}
+	}
+	return out
+}
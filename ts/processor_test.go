@@ -0,0 +1,173 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"reflect"
+	"testing"
+)
+
+// processorTest is the output expected from processing the test stream.
+type processorTest struct {
+	name   string
+	config ProcessorConfig
+	pids   []uint16
+	// PAT programs and the PMTs by PMT PID as sent
+	programs []Program
+	pmts     map[uint16]PMT
+	// services of the SDT and EIT, nil when not sent
+	sdt []uint16
+	eit []uint16
+}
+
+func TestProcessor(t *testing.T) {
+	runProcessorTests(t, []processorTest{
+		{
+			name:     "passthrough",
+			pids:     []uint16{0x00, 0x10, 0x11, 0x12, 0x100, 0x101, 0x200, 0x201, 0x1000, 0x1001},
+			programs: []Program{{0, 0x10}, {1, testPMT1}, {2, testPMT2}},
+			pmts: map[uint16]PMT{
+				testPMT1: {PCRPID: 0x100, Streams: []Stream{{Type: 0x1b, PID: 0x100}, {Type: 0x0f, PID: 0x101}}},
+				testPMT2: {PCRPID: 0x200, Streams: []Stream{{Type: 0x1b, PID: 0x200}, {Type: 0x0f, PID: 0x201}}},
+			},
+			sdt: []uint16{1, 2},
+			eit: []uint16{1, 2},
+		},
+		{
+			name:     "keep",
+			config:   ProcessorConfig{Keep: []uint16{0x100, 0x101}},
+			pids:     []uint16{0x00, 0x100, 0x101, 0x1000, 0x1001},
+			programs: []Program{{1, testPMT1}, {2, testPMT2}},
+			pmts: map[uint16]PMT{
+				testPMT1: {PCRPID: 0x100, Streams: []Stream{{Type: 0x1b, PID: 0x100}, {Type: 0x0f, PID: 0x101}}},
+				testPMT2: {PCRPID: 0x200},
+			},
+		},
+		{
+			name:     "drop stream and program",
+			config:   ProcessorConfig{Drop: []uint16{0x101, testPMT2}},
+			pids:     []uint16{0x00, 0x10, 0x11, 0x12, 0x100, 0x200, 0x201, 0x1000},
+			programs: []Program{{0, 0x10}, {1, testPMT1}},
+			pmts: map[uint16]PMT{
+				testPMT1: {PCRPID: 0x100, Streams: []Stream{{Type: 0x1b, PID: 0x100}}},
+			},
+			sdt: []uint16{1, 2},
+			eit: []uint16{1, 2},
+		},
+		{
+			name:     "remap",
+			config:   ProcessorConfig{Remap: map[uint16]uint16{0x100: 0x300, testPMT1: 0x1100}},
+			pids:     []uint16{0x00, 0x10, 0x11, 0x12, 0x101, 0x200, 0x201, 0x300, 0x1001, 0x1100},
+			programs: []Program{{0, 0x10}, {1, 0x1100}, {2, testPMT2}},
+			pmts: map[uint16]PMT{
+				0x1100:   {PCRPID: 0x300, Streams: []Stream{{Type: 0x1b, PID: 0x300}, {Type: 0x0f, PID: 0x101}}},
+				testPMT2: {PCRPID: 0x200, Streams: []Stream{{Type: 0x1b, PID: 0x200}, {Type: 0x0f, PID: 0x201}}},
+			},
+			sdt: []uint16{1, 2},
+			eit: []uint16{1, 2},
+		},
+		{
+			name:     "remap collision",
+			config:   ProcessorConfig{Remap: map[uint16]uint16{0x100: 0x200}},
+			pids:     []uint16{0x00, 0x10, 0x11, 0x12, 0x101, 0x200, 0x201, 0x1000, 0x1001},
+			programs: []Program{{0, 0x10}, {1, testPMT1}, {2, testPMT2}},
+			pmts: map[uint16]PMT{
+				testPMT1: {PCRPID: 0x200, Streams: []Stream{{Type: 0x1b, PID: 0x200}, {Type: 0x0f, PID: 0x101}}},
+				testPMT2: {PCRPID: 0x200, Streams: []Stream{{Type: 0x0f, PID: 0x201}}},
+			},
+			sdt: []uint16{1, 2},
+			eit: []uint16{1, 2},
+		},
+	})
+}
+
+func runProcessorTests(t *testing.T, tests []processorTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := testStream()
+			inCopy := append([]byte(nil), in...)
+			out := NewProcessor(tt.config).Process(in)
+			if !reflect.DeepEqual(in, inCopy) {
+				t.Fatal("input was modified")
+			}
+			psi := []uint16{PATPID, SDTPID, EITPID}
+			for pid := range tt.pmts {
+				psi = append(psi, pid)
+			}
+			pids, sections := demux(t, out, psi...)
+			if !reflect.DeepEqual(pids, tt.pids) {
+				t.Errorf("pids %x, want %x", pids, tt.pids)
+			}
+
+			if len(sections[PATPID]) != 1 {
+				t.Fatalf("got %d pat sections, want 1", len(sections[PATPID]))
+			}
+			pat, err := ParsePAT(sections[PATPID][0])
+			if err != nil {
+				t.Fatalf("parsing pat: %v", err)
+			}
+			if !reflect.DeepEqual(pat.Programs, tt.programs) {
+				t.Errorf("pat programs %v, want %v", pat.Programs, tt.programs)
+			}
+
+			for pid, want := range tt.pmts {
+				if len(sections[pid]) != 1 {
+					t.Fatalf("pid %x: got %d pmt sections, want 1", pid, len(sections[pid]))
+				}
+				pmt, err := ParsePMT(sections[pid][0])
+				if err != nil {
+					t.Fatalf("pid %x: parsing pmt: %v", pid, err)
+				}
+				if pmt.PCRPID != want.PCRPID {
+					t.Errorf("pid %x: pcr pid %x, want %x", pid, pmt.PCRPID, want.PCRPID)
+				}
+				streams := pmt.Streams
+				for i := range streams {
+					streams[i].Descriptors = nil
+				}
+				if len(streams) == 0 {
+					streams = nil
+				}
+				if !reflect.DeepEqual(streams, want.Streams) {
+					t.Errorf("pid %x: streams %v, want %v", pid, streams, want.Streams)
+				}
+			}
+
+			var sdt []uint16
+			for _, s := range sections[SDTPID] {
+				sdt = append(sdt, sdtServices(t, s)...)
+			}
+			if !reflect.DeepEqual(sdt, tt.sdt) {
+				t.Errorf("sdt services %v, want %v", sdt, tt.sdt)
+			}
+			var eit []uint16
+			for _, s := range sections[EITPID] {
+				h, _, err := parseSection(s)
+				if err != nil {
+					t.Fatalf("parsing eit: %v", err)
+				}
+				eit = append(eit, h.TableIDExtension)
+			}
+			if !reflect.DeepEqual(eit, tt.eit) {
+				t.Errorf("eit services %v, want %v", eit, tt.eit)
+			}
+		})
+	}
+}
+
+func TestProcessorCollisions(t *testing.T) {
+	p := NewProcessor(ProcessorConfig{Remap: map[uint16]uint16{0x100: 0x200}})
+	p.Process(testStream())
+	if got := p.Collisions(); !reflect.DeepEqual(got, []uint16{0x200}) {
+		t.Errorf("collisions %x, want [200]", got)
+	}
+	p.Process(testStream())
+	if got := p.Collisions(); got != nil {
+		t.Errorf("collisions %x reported again", got)
+	}
+}
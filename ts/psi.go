@@ -0,0 +1,223 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	maxSectionSize = 4096
+	// table_id up to and including last_section_number
+	sectionHeaderSize = 8
+	crcSize           = 4
+)
+
+var (
+	ErrInvalidSection = errors.New("invalid psi section")
+	ErrCRC            = errors.New("psi section crc mismatch")
+)
+
+// SectionAssembler reassembles PSI sections from the packets of a single PID.
+type SectionAssembler struct {
+	buf     []byte
+	started bool
+}
+
+// Push adds a packet and returns the sections completed by it, the returned
+// slices are only valid until the next call to Push.
+func (s *SectionAssembler) Push(p []byte) [][]byte {
+	payload := Payload(p)
+	if payload == nil {
+		return nil
+	}
+	var sections [][]byte
+	if PayloadUnitStart(p) {
+		pointer := int(payload[0])
+		if 1+pointer > len(payload) {
+			s.Reset()
+			return nil
+		}
+		if s.started {
+			s.buf = append(s.buf, payload[1:1+pointer]...)
+			sections = s.extract(sections)
+		}
+		s.buf = append(s.buf[:0], payload[1+pointer:]...)
+		s.started = true
+	} else if s.started {
+		s.buf = append(s.buf, payload...)
+	} else {
+		return nil
+	}
+	if len(s.buf) > maxSectionSize+3 {
+		s.Reset()
+		return sections
+	}
+	return s.extract(sections)
+}
+
+func (s *SectionAssembler) extract(sections [][]byte) [][]byte {
+	for len(s.buf) >= 3 {
+		if s.buf[0] == 0xff {
+			// stuffing, nothing follows in this packet
+			s.Reset()
+			break
+		}
+		l := 3 + int(binary.BigEndian.Uint16(s.buf[1:3])&0x0fff)
+		if len(s.buf) < l {
+			return sections
+		}
+		sections = append(sections, s.buf[:l:l])
+		s.buf = s.buf[l:]
+	}
+	if len(s.buf) == 0 {
+		s.started = false
+	}
+	return sections
+}
+
+// Reset drops a partially received section.
+func (s *SectionAssembler) Reset() {
+	s.buf = s.buf[:0]
+	s.started = false
+}
+
+var crcTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}()
+
+// CRC32 returns the MPEG-2 CRC of data.
+func CRC32(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// SectionHeader is the long form header shared by PAT and PMT sections.
+type SectionHeader struct {
+	TableID           uint8
+	TableIDExtension  uint16
+	Version           uint8
+	CurrentNext       bool
+	SectionNumber     uint8
+	LastSectionNumber uint8
+}
+
+// parseSection checks the CRC of a long form section and returns its header
+// and the data between header and CRC.
+func parseSection(section []byte) (SectionHeader, []byte, error) {
+	var h SectionHeader
+	if len(section) < sectionHeaderSize+crcSize || section[1]&0x80 == 0 {
+		return h, nil, ErrInvalidSection
+	}
+	if CRC32(section) != 0 {
+		return h, nil, ErrCRC
+	}
+	h.TableID = section[0]
+	h.TableIDExtension = binary.BigEndian.Uint16(section[3:5])
+	h.Version = section[5] >> 1 & 0x1f
+	h.CurrentNext = section[5]&0x01 != 0
+	h.SectionNumber = section[6]
+	h.LastSectionNumber = section[7]
+	return h, section[sectionHeaderSize : len(section)-crcSize], nil
+}
+
+// Program is a PAT entry, program number 0 refers to the network PID.
+type Program struct {
+	Number uint16
+	PID    uint16
+}
+
+// PAT is a program association table section.
+type PAT struct {
+	SectionHeader
+	Programs []Program
+}
+
+// ParsePAT parses a complete PAT section.
+func ParsePAT(section []byte) (*PAT, error) {
+	h, data, err := parseSection(section)
+	if err != nil {
+		return nil, err
+	}
+	if h.TableID != TableIDPAT || len(data)%4 != 0 {
+		return nil, ErrInvalidSection
+	}
+	pat := &PAT{SectionHeader: h}
+	for i := 0; i < len(data); i += 4 {
+		pat.Programs = append(pat.Programs, Program{
+			Number: binary.BigEndian.Uint16(data[i : i+2]),
+			PID:    binary.BigEndian.Uint16(data[i+2:i+4]) & 0x1fff,
+		})
+	}
+	return pat, nil
+}
+
+// Stream is a PMT elementary stream entry.
+type Stream struct {
+	Type        uint8
+	PID         uint16
+	Descriptors []byte
+}
+
+// PMT is a program map table section.
+type PMT struct {
+	SectionHeader
+	PCRPID      uint16
+	Descriptors []byte
+	Streams     []Stream
+}
+
+// ParsePMT parses a complete PMT section.
+func ParsePMT(section []byte) (*PMT, error) {
+	h, data, err := parseSection(section)
+	if err != nil {
+		return nil, err
+	}
+	if h.TableID != TableIDPMT || len(data) < 4 {
+		return nil, ErrInvalidSection
+	}
+	pmt := &PMT{SectionHeader: h}
+	pmt.PCRPID = binary.BigEndian.Uint16(data[0:2]) & 0x1fff
+	infoLen := int(binary.BigEndian.Uint16(data[2:4]) & 0x0fff)
+	if 4+infoLen > len(data) {
+		return nil, ErrInvalidSection
+	}
+	pmt.Descriptors = append([]byte(nil), data[4:4+infoLen]...)
+	data = data[4+infoLen:]
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, ErrInvalidSection
+		}
+		esInfoLen := int(binary.BigEndian.Uint16(data[3:5]) & 0x0fff)
+		if 5+esInfoLen > len(data) {
+			return nil, ErrInvalidSection
+		}
+		pmt.Streams = append(pmt.Streams, Stream{
+			Type:        data[0],
+			PID:         binary.BigEndian.Uint16(data[1:3]) & 0x1fff,
+			Descriptors: append([]byte(nil), data[5:5+esInfoLen]...),
+		})
+		data = data[5+esInfoLen:]
+	}
+	return pmt, nil
+}
@@ -0,0 +1,137 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import "time"
+
+// pcrPacketBits is a packet in bits times the PCR clock, dividing by the
+// bitrate gives the duration of a packet in PCR ticks.
+const pcrPacketBits = PacketSize * 8 * PCRClock
+
+// PCRs further than this from the restamped clock are a discontinuity.
+const maxPCRDrift = 2 * PCRClock
+
+// Shaper turns a variable bitrate stream into a constant bitrate stream by
+// inserting null packets. The PCRs are restamped to the position the packet
+// is sent at, which corrects them for the inserted packets and the time
+// packets spent queued.
+type Shaper struct {
+	bitrate   uint64
+	maxQueued int
+	queue     []byte
+
+	// clock of the next packet in PCR ticks, not wrapped
+	clock    uint64
+	step     uint64
+	stepRem  uint64
+	clockRem uint64
+	// per PCR PID the offset between the input PCR and the clock
+	offsets map[uint16]uint64
+
+	// NullPackets is the number of null packets inserted
+	NullPackets uint64
+	// OverflowPackets is the number of packets dropped because the input
+	// exceeded the bitrate for longer than the max delay
+	OverflowPackets uint64
+}
+
+// NewShaper creates a shaper sending at bitrate bits/s which queues at most
+// maxDelay of packets.
+func NewShaper(bitrate int, maxDelay time.Duration) *Shaper {
+	s := &Shaper{
+		bitrate: uint64(bitrate),
+		offsets: make(map[uint16]uint64),
+	}
+	s.step, s.stepRem = pcrPacketBits/s.bitrate, pcrPacketBits%s.bitrate
+	s.maxQueued = int(maxDelay.Seconds() * float64(bitrate) / (PacketSize * 8))
+	if s.maxQueued < 1 {
+		s.maxQueued = 1
+	}
+	return s
+}
+
+// Push queues the packets of data, a trailing partial packet is dropped.
+func (s *Shaper) Push(data []byte) {
+	data = data[:len(data)-len(data)%PacketSize]
+	free := (s.maxQueued - len(s.queue)/PacketSize) * PacketSize
+	if len(data) > free {
+		s.OverflowPackets += uint64((len(data) - free) / PacketSize)
+		data = data[:free]
+	}
+	s.queue = append(s.queue, data...)
+}
+
+// Queued returns the number of packets waiting to be sent.
+func (s *Shaper) Queued() int {
+	return len(s.queue) / PacketSize
+}
+
+// Elapsed returns the time since the start at which the next packet is
+// sent.
+func (s *Shaper) Elapsed() time.Duration {
+	return time.Duration(s.clock * 1000 / (PCRClock / 1000000))
+}
+
+// Next returns the next packets, queued packets are sent first and null
+// packets fill the remainder.
+func (s *Shaper) Next(packets int) []byte {
+	out := make([]byte, 0, packets*PacketSize)
+	for i := 0; i < packets; i++ {
+		if len(s.queue) >= PacketSize {
+			start := len(out)
+			out = append(out, s.queue[:PacketSize]...)
+			s.queue = s.queue[PacketSize:]
+			s.restamp(out[start:])
+		} else {
+			out = append(out, NullPacket()...)
+			s.NullPackets++
+		}
+		s.tick()
+	}
+	if len(s.queue) == 0 {
+		s.queue = nil
+	}
+	return out
+}
+
+func (s *Shaper) tick() {
+	s.clock += s.step
+	s.clockRem += s.stepRem
+	if s.clockRem >= s.bitrate {
+		s.clock++
+		s.clockRem -= s.bitrate
+	}
+}
+
+// restamp replaces the PCR of a packet with the clock plus the offset of
+// its PID, the offset is taken from the first PCR and after discontinuities.
+func (s *Shaper) restamp(p []byte) {
+	if p[0] != SyncByte {
+		return
+	}
+	pcr, ok := PCR(p)
+	if !ok {
+		return
+	}
+	pid := PID(p)
+	clock := s.clock % PCRWrap
+	offset, ok := s.offsets[pid]
+	if ok && !Discontinuity(p) {
+		expected := (clock + offset) % PCRWrap
+		drift := (pcr + PCRWrap - expected) % PCRWrap
+		if drift > PCRWrap/2 {
+			drift = PCRWrap - drift
+		}
+		if drift <= maxPCRDrift {
+			SetPCR(p, expected)
+			return
+		}
+		// signal the jump to the receivers
+		p[5] |= 0x80
+	}
+	s.offsets[pid] = (pcr + PCRWrap - clock) % PCRWrap
+}
@@ -0,0 +1,181 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShaperRate(t *testing.T) {
+	for _, bitrate := range []int{1000000, 3000001, 38000000} {
+		s := NewShaper(bitrate, time.Second)
+		sent := uint64(0)
+		for _, n := range []int{1, 7, 100, 1000, 12345} {
+			s.Next(n)
+			sent += uint64(n)
+			// the clock is exact, the remainders don't accumulate
+			want := sent * pcrPacketBits / uint64(bitrate)
+			if s.clock != want {
+				t.Fatalf("%d bit/s: clock %d after %d packets, want %d", bitrate, s.clock, sent, want)
+			}
+		}
+		want := time.Duration(float64(sent*PacketSize*8) / float64(bitrate) * float64(time.Second))
+		if d := s.Elapsed() - want; d < -time.Microsecond || d > time.Microsecond {
+			t.Fatalf("%d bit/s: elapsed %s after %d packets, want %s", bitrate, s.Elapsed(), sent, want)
+		}
+	}
+}
+
+func TestShaperNext(t *testing.T) {
+	s := NewShaper(1000000, time.Second)
+	s.Push(packets(testPacket(0x100, 0), testPacket(0x100, 1), testPacket(0x101, 2)))
+	out := s.Next(10)
+	if len(out) != 10*PacketSize {
+		t.Fatalf("%d bytes, want %d", len(out), 10*PacketSize)
+	}
+	for i, want := range []uint16{0x100, 0x100, 0x101, NullPID, NullPID, NullPID, NullPID, NullPID, NullPID, NullPID} {
+		if pid := PID(out[i*PacketSize:]); pid != want {
+			t.Fatalf("packet %d on pid %d, want %d", i, pid, want)
+		}
+	}
+	if s.NullPackets != 7 || s.Queued() != 0 {
+		t.Fatalf("%d null packets and %d queued, want 7 and 0", s.NullPackets, s.Queued())
+	}
+	// only null packets without input
+	s.Next(3)
+	if s.NullPackets != 10 {
+		t.Fatalf("%d null packets, want 10", s.NullPackets)
+	}
+}
+
+func TestShaperPush(t *testing.T) {
+	// 10 packets per 100ms
+	s := NewShaper(PacketSize*8*100, 100*time.Millisecond)
+	var data []byte
+	for i := 0; i < 15; i++ {
+		data = append(data, testPacket(0x100, i)...)
+	}
+	// a trailing partial packet is dropped without counting it
+	s.Push(data[:8*PacketSize+10])
+	if s.Queued() != 8 || s.OverflowPackets != 0 {
+		t.Fatalf("%d queued and %d overflow, want 8 and 0", s.Queued(), s.OverflowPackets)
+	}
+	s.Push(data[8*PacketSize:])
+	if s.Queued() != 10 || s.OverflowPackets != 5 {
+		t.Fatalf("%d queued and %d overflow, want 10 and 5", s.Queued(), s.OverflowPackets)
+	}
+	// the oldest packets are kept
+	out := s.Next(10)
+	for i := 0; i < 10; i++ {
+		if cc := ContinuityCounter(out[i*PacketSize:]); int(cc) != i {
+			t.Fatalf("packet %d has cc %d", i, cc)
+		}
+	}
+	// at least a packet is queued
+	if s := NewShaper(1000000, 0); s.maxQueued != 1 {
+		t.Fatalf("max queued %d, want 1", s.maxQueued)
+	}
+}
+
+func TestShaperRestamp(t *testing.T) {
+	const bitrate = 2000000
+	// PCR ticks of n packets at the bitrate
+	ticks := func(n int) uint64 { return uint64(n) * pcrPacketBits / bitrate }
+	tests := []struct {
+		name string
+		// input PCRs, sent with 9 packets between them
+		pcrs []uint64
+		// restamped PCRs and whether the discontinuity_indicator is set
+		want []uint64
+		disc []bool
+	}{
+		{
+			name: "burst",
+			// the input sent the packets at a higher rate
+			pcrs: []uint64{1000, 1000 + ticks(5), 1000 + ticks(10)},
+			want: []uint64{1000, 1000 + ticks(10), 1000 + ticks(20)},
+			disc: []bool{false, false, false},
+		},
+		{
+			name: "drift beyond the max",
+			pcrs: []uint64{1000, 1000 + ticks(10) + maxPCRDrift + 1, 1000 + ticks(20) + maxPCRDrift + 1},
+			// the jump is passed on and signalled, the next PCR continues
+			// from it
+			want: []uint64{1000, 1000 + ticks(10) + maxPCRDrift + 1, 1000 + ticks(20) + maxPCRDrift + 1},
+			disc: []bool{false, true, false},
+		},
+		{
+			name: "drift within the max",
+			pcrs: []uint64{10 * PCRClock, 10*PCRClock + ticks(10) + maxPCRDrift, 10*PCRClock + ticks(20) - maxPCRDrift},
+			want: []uint64{10 * PCRClock, 10*PCRClock + ticks(10), 10*PCRClock + ticks(20)},
+			disc: []bool{false, false, false},
+		},
+		{
+			name: "pcr wrap",
+			pcrs: []uint64{PCRWrap - ticks(15), PCRWrap - ticks(5), ticks(5)},
+			want: []uint64{PCRWrap - ticks(15), PCRWrap - ticks(5), ticks(5)},
+			disc: []bool{false, false, false},
+		},
+		{
+			name: "backwards beyond the max",
+			pcrs: []uint64{10 * PCRClock, 10*PCRClock + ticks(10) - maxPCRDrift - 1, 10*PCRClock + ticks(20) - maxPCRDrift - 1},
+			want: []uint64{10 * PCRClock, 10*PCRClock + ticks(10) - maxPCRDrift - 1, 10*PCRClock + ticks(20) - maxPCRDrift - 1},
+			disc: []bool{false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewShaper(bitrate, time.Second)
+			// the clock doesn't start at zero
+			s.Next(3)
+			for i, pcr := range tt.pcrs {
+				s.Push(PCRPacket(0x100, pcr, 0))
+				for j := 0; j < 9; j++ {
+					s.Push(testPacket(0x101, i*9+j))
+				}
+			}
+			out := s.Next(40)
+			var got []uint64
+			var disc []bool
+			for i := 0; i < len(out)/PacketSize; i++ {
+				p := out[i*PacketSize : (i+1)*PacketSize]
+				if PID(p) != 0x100 {
+					continue
+				}
+				if i != len(got)*10 {
+					t.Fatalf("pcr packet %d sent at %d", len(got), i)
+				}
+				pcr, _ := PCR(p)
+				got = append(got, pcr)
+				disc = append(disc, Discontinuity(p))
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] || disc[i] != tt.disc[i] {
+					t.Fatalf("pcrs %v discontinuity %v, want %v %v", got, disc, tt.want, tt.disc)
+				}
+			}
+		})
+	}
+}
+
+func TestShaperRestampPIDs(t *testing.T) {
+	s := NewShaper(1000000, time.Second)
+	// PIDs have their own offset, input discontinuities reset it
+	s.Push(packets(PCRPacket(0x100, 5000, 0), PCRPacket(0x200, 9*PCRClock, 0)))
+	s.Next(2)
+	step := pcrPacketBits / uint64(1000000)
+	disc := PCRPacket(0x100, 3*PCRClock, 0)
+	disc[5] |= 0x80
+	s.Push(packets(PCRPacket(0x200, 9*PCRClock+5, 0), disc, PCRPacket(0x100, 3*PCRClock+step+100, 0)))
+	out := s.Next(3)
+	for i, want := range []uint64{9*PCRClock + step, 3 * PCRClock, 3*PCRClock + step} {
+		if pcr, _ := PCR(out[i*PacketSize:]); pcr != want {
+			t.Fatalf("packet %d: pcr %d, want %d", i, pcr, want)
+		}
+	}
+}
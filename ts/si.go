@@ -0,0 +1,71 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import "encoding/binary"
+
+// DVB SI PIDs and tables, PIDs below FirstUserPID are reserved for PSI/SI.
+const (
+	SDTPID       uint16 = 0x0011
+	EITPID       uint16 = 0x0012
+	FirstUserPID uint16 = 0x0020
+
+	TableIDSDTActual     = 0x42
+	TableIDEITActualPF   = 0x4e
+	TableIDEITActualSch  = 0x50
+	TableIDEITActualLast = 0x5f
+)
+
+// SIMode decides how the SDT and EIT are handled when extracting a program.
+type SIMode string
+
+const (
+	// SIFilter rewrites the SDT and EIT to only describe the program
+	SIFilter SIMode = "filter"
+	// SIPass passes the SDT and EIT unmodified
+	SIPass SIMode = "pass"
+	// SIDrop drops the SDT and EIT
+	SIDrop SIMode = "drop"
+)
+
+// filterSDT returns the actual SDT section with only the service, nil for
+// other tables on the SDT PID or when the service isn't in the section.
+func filterSDT(section []byte, service uint16) []byte {
+	h, data, err := parseSection(section)
+	if err != nil || h.TableID != TableIDSDTActual || len(data) < 3 {
+		return nil
+	}
+	// original_network_id and reserved byte
+	filtered := append([]byte(nil), data[:3]...)
+	found := false
+	loop := data[3:]
+	for len(loop) >= 5 {
+		l := 5 + int(binary.BigEndian.Uint16(loop[3:5])&0x0fff)
+		if l > len(loop) {
+			return nil
+		}
+		if binary.BigEndian.Uint16(loop[0:2]) == service {
+			filtered = append(filtered, loop[:l]...)
+			found = true
+		}
+		loop = loop[l:]
+	}
+	if !found {
+		return nil
+	}
+	return longSection(h, filtered)
+}
+
+// keepEIT returns true for actual EIT sections of the service.
+func keepEIT(section []byte, service uint16) bool {
+	h, _, err := parseSection(section)
+	if err != nil || h.TableIDExtension != service {
+		return false
+	}
+	return h.TableID == TableIDEITActualPF ||
+		(h.TableID >= TableIDEITActualSch && h.TableID <= TableIDEITActualLast)
+}
@@ -0,0 +1,115 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcessorProgram(t *testing.T) {
+	runProcessorTests(t, []processorTest{
+		{
+			name:     "program",
+			config:   ProcessorConfig{Program: 2},
+			pids:     []uint16{0x00, 0x10, 0x11, 0x12, 0x200, 0x201, 0x1001},
+			programs: []Program{{0, 0x10}, {2, testPMT2}},
+			pmts: map[uint16]PMT{
+				testPMT2: {PCRPID: 0x200, Streams: []Stream{{Type: 0x1b, PID: 0x200}, {Type: 0x0f, PID: 0x201}}},
+			},
+			sdt: []uint16{2},
+			eit: []uint16{2},
+		},
+		{
+			name:     "program si pass",
+			config:   ProcessorConfig{Program: 2, SI: SIPass},
+			pids:     []uint16{0x00, 0x10, 0x11, 0x12, 0x200, 0x201, 0x1001},
+			programs: []Program{{0, 0x10}, {2, testPMT2}},
+			pmts: map[uint16]PMT{
+				testPMT2: {PCRPID: 0x200, Streams: []Stream{{Type: 0x1b, PID: 0x200}, {Type: 0x0f, PID: 0x201}}},
+			},
+			sdt: []uint16{1, 2},
+			eit: []uint16{1, 2},
+		},
+		{
+			name:     "program si drop",
+			config:   ProcessorConfig{Program: 2, SI: SIDrop},
+			pids:     []uint16{0x00, 0x10, 0x200, 0x201, 0x1001},
+			programs: []Program{{0, 0x10}, {2, testPMT2}},
+			pmts: map[uint16]PMT{
+				testPMT2: {PCRPID: 0x200, Streams: []Stream{{Type: 0x1b, PID: 0x200}, {Type: 0x0f, PID: 0x201}}},
+			},
+		},
+		{
+			name:     "program remapped",
+			config:   ProcessorConfig{Program: 1, Remap: map[uint16]uint16{0x101: 0x102}},
+			pids:     []uint16{0x00, 0x10, 0x11, 0x12, 0x100, 0x102, 0x1000},
+			programs: []Program{{0, 0x10}, {1, testPMT1}},
+			pmts: map[uint16]PMT{
+				testPMT1: {PCRPID: 0x100, Streams: []Stream{{Type: 0x1b, PID: 0x100}, {Type: 0x0f, PID: 0x102}}},
+			},
+			sdt: []uint16{1},
+			eit: []uint16{1},
+		},
+	})
+}
+
+func TestFilterSDT(t *testing.T) {
+	tests := []struct {
+		name    string
+		section []byte
+		service uint16
+		want    []uint16
+	}{
+		{"first", testSDT(1, 2, 3), 1, []uint16{1}},
+		{"last", testSDT(1, 2, 3), 3, []uint16{3}},
+		{"missing", testSDT(1, 2, 3), 4, nil},
+		{"other table", testEIT(TableIDEITActualPF, 1), 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			section := filterSDT(tt.section, tt.service)
+			if tt.want == nil {
+				if section != nil {
+					t.Fatal("got a section, want none")
+				}
+				return
+			}
+			if section == nil {
+				t.Fatal("got no section")
+			}
+			if CRC32(section) != 0 {
+				t.Fatal("section crc mismatch")
+			}
+			if got := sdtServices(t, section); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("services %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepEIT(t *testing.T) {
+	tests := []struct {
+		name    string
+		section []byte
+		want    bool
+	}{
+		{"present/following", testEIT(TableIDEITActualPF, 1), true},
+		{"schedule", testEIT(TableIDEITActualSch, 1), true},
+		{"last schedule", testEIT(TableIDEITActualLast, 1), true},
+		{"other service", testEIT(TableIDEITActualPF, 2), false},
+		{"other ts", testEIT(0x4f, 1), false},
+		{"sdt", testSDT(1), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keepEIT(tt.section, 1); got != tt.want {
+				t.Errorf("keepEIT %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package ts
+
+import (
+	"encoding/binary"
+	"sort"
+	"testing"
+)
+
+// Test stream: program 1 with PMT 0x1000, PCR and video 0x100 and audio
+// 0x101, program 2 with PMT 0x1001, PCR and video 0x200 and audio 0x201.
+// Both programs are described in the SDT and EIT.
+const (
+	testPMT1 uint16 = 0x1000
+	testPMT2 uint16 = 0x1001
+)
+
+// testPacket returns a payload only packet on pid, the continuity counter
+// and payload are derived from i so packets can be told apart.
+func testPacket(pid uint16, i int) []byte {
+	p := make([]byte, PacketSize)
+	p[0] = SyncByte
+	SetPID(p, pid)
+	p[3] = 0x10 | byte(i&0x0f)
+	for j := 4; j < PacketSize; j++ {
+		p[j] = byte(i)
+	}
+	return p
+}
+
+// testSection returns the packets of a section on pid.
+func testSection(pid uint16, section []byte) []byte {
+	var out []byte
+	var cc uint8 = 0x0f
+	for _, p := range SectionPackets(pid, section, &cc) {
+		out = append(out, p...)
+	}
+	return out
+}
+
+func testSDT(services ...uint16) []byte {
+	data := []byte{0x00, 0x01, 0xff}
+	for _, s := range services {
+		// service_id, EIT flags, running status and empty descriptor loop
+		data = append(data, byte(s>>8), byte(s), 0xfc, 0x80, 0x00)
+	}
+	return longSection(SectionHeader{TableID: TableIDSDTActual, TableIDExtension: 1, CurrentNext: true}, data)
+}
+
+func testEIT(tableID uint8, service uint16) []byte {
+	data := []byte{0x00, 0x01, 0x00, 0x01, 0x00, tableID}
+	return longSection(SectionHeader{TableID: tableID, TableIDExtension: service, CurrentNext: true}, data)
+}
+
+// testStream returns the PSI/SI of the test stream followed by a packet of
+// the network PID and every elementary stream.
+func testStream() []byte {
+	pat := &PAT{
+		SectionHeader: SectionHeader{TableIDExtension: 1, CurrentNext: true},
+		Programs:      []Program{{Number: 0, PID: 0x10}, {Number: 1, PID: testPMT1}, {Number: 2, PID: testPMT2}},
+	}
+	out := testSection(PATPID, pat.Marshal())
+	for i, pid := range []uint16{testPMT1, testPMT2} {
+		base := uint16(0x100 * (i + 1))
+		pmt := &PMT{
+			SectionHeader: SectionHeader{TableIDExtension: uint16(i + 1), CurrentNext: true},
+			PCRPID:        base,
+			Streams:       []Stream{{Type: 0x1b, PID: base}, {Type: 0x0f, PID: base + 1}},
+		}
+		out = append(out, testSection(pid, pmt.Marshal())...)
+	}
+	out = append(out, testSection(SDTPID, testSDT(1, 2))...)
+	out = append(out, testSection(EITPID, testEIT(TableIDEITActualPF, 1))...)
+	out = append(out, testSection(EITPID, testEIT(TableIDEITActualPF, 2))...)
+	for i, pid := range []uint16{0x10, 0x100, 0x101, 0x200, 0x201} {
+		out = append(out, testPacket(pid, i)...)
+	}
+	return out
+}
+
+// demux returns the packet PIDs and the sections per PID of a stream, the
+// sections are of the PSI PIDs given.
+func demux(t *testing.T, data []byte, psi ...uint16) ([]uint16, map[uint16][][]byte) {
+	t.Helper()
+	asms := make(map[uint16]*SectionAssembler)
+	for _, pid := range psi {
+		asms[pid] = &SectionAssembler{}
+	}
+	seen := make(map[uint16]bool)
+	sections := make(map[uint16][][]byte)
+	for ; len(data) >= PacketSize; data = data[PacketSize:] {
+		p := data[:PacketSize]
+		if p[0] != SyncByte {
+			t.Fatal("lost sync")
+		}
+		pid := PID(p)
+		seen[pid] = true
+		if asm, ok := asms[pid]; ok {
+			for _, s := range asm.Push(p) {
+				if CRC32(s) != 0 {
+					t.Fatalf("pid %d: section crc mismatch", pid)
+				}
+				sections[pid] = append(sections[pid], append([]byte(nil), s...))
+			}
+		}
+	}
+	if len(data) != 0 {
+		t.Fatal("trailing partial packet")
+	}
+	var pids []uint16
+	for pid := range seen {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, sections
+}
+
+func sdtServices(t *testing.T, section []byte) []uint16 {
+	t.Helper()
+	h, data, err := parseSection(section)
+	if err != nil || h.TableID != TableIDSDTActual {
+		t.Fatalf("invalid sdt: %v", err)
+	}
+	var services []uint16
+	for loop := data[3:]; len(loop) >= 5; loop = loop[5+int(binary.BigEndian.Uint16(loop[3:5])&0x0fff):] {
+		services = append(services, binary.BigEndian.Uint16(loop[0:2]))
+	}
+	return services
+}
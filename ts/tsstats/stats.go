@@ -0,0 +1,32 @@
+/*
+ * SPDX-FileCopyrightText: Streamzeug Copyright © 2021 ODMedia B.V. All right reserved.
+ * SPDX-FileContributor: Author: Gijs Peskens <gijs@peskens.net>
+ * SPDX-License-Identifier: GPL-3.0-or-later
+ */
+
+package tsstats
+
+// TR101290Stats are the ETSI TR 101 290 priority 1 counters of a flow, all
+// counters are totals since the analyzer was started.
+type TR101290Stats struct {
+	Packets int
+	// 1.1 TS_sync_loss
+	SyncLoss int
+	// 1.2 Sync_byte_error
+	SyncByteErrors int
+	// 1.3 PAT_error_2
+	PatErrors int
+	// 1.4 Continuity_count_error
+	CCErrors int
+	// 1.5 PMT_error_2
+	PmtErrors int
+	// 1.6 PID_error
+	PidErrors int
+	InSync    bool
+	Programs  int
+}
+
+// P1Errors returns the sum of all priority 1 error counters.
+func (s *TR101290Stats) P1Errors() int {
+	return s.SyncLoss + s.SyncByteErrors + s.PatErrors + s.CCErrors + s.PmtErrors + s.PidErrors
+}